@@ -0,0 +1,124 @@
+package nbio
+
+import (
+	"io"
+	"sync"
+)
+
+// Broadcaster passes the data of one source to any number of subscribers,
+// as returned by NewBroadcaster.
+type Broadcaster struct {
+	r io.ReadCloser // source
+
+	mutex sync.Mutex
+	subs  []*Writer // current subscribers
+	err   error     // source error, once the routine ended
+
+	done      chan struct{} // signals routine termination
+	closeOnce sync.Once
+	closeErr  error // Close result
+}
+
+// NewBroadcaster returns a new distributor, which reads source in a routine
+// of its own, and which writes each chunk to all subscribers. Subscribers
+// are a Writer each, with their own buffers, timeout and FullPolicy, e.g.,
+// a Writer with FullDropOldest suits a feed where recent data matters most.
+// A subscriber whose write fails, which includes ErrWriteBufferFull from a
+// Writer with FullWait, is dropped, and it gets closed on a routine of its
+// own. Note that such a subscriber may delay the others for its timeout.
+//
+// Once source ends, all subscribers are closed, and Err has the cause.
+func NewBroadcaster(source io.ReadCloser) *Broadcaster {
+	b := &Broadcaster{r: source, done: make(chan struct{})}
+	go b.readRoutine()
+	return b
+}
+
+// readRoutine passes each chunk of source to the subscribers, until the
+// source fails.
+func (b *Broadcaster) readRoutine() {
+	defer close(b.done)
+
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := b.r.Read(buf)
+		if n != 0 {
+			b.mutex.Lock()
+			for i := 0; i < len(b.subs); i++ {
+				w := b.subs[i]
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					// slow or broken consumer
+					b.subs = append(b.subs[:i], b.subs[i+1:]...)
+					i--
+					go w.Close()
+				}
+			}
+			b.mutex.Unlock()
+		}
+		if err != nil {
+			b.mutex.Lock()
+			b.err = err
+			subs := b.subs
+			b.subs = nil
+			b.mutex.Unlock()
+
+			for _, w := range subs {
+				w.Close()
+			}
+			return
+		}
+	}
+}
+
+// Subscribe adds w to the receivers of each chunk from then on. Writes on
+// w must be left to the Broadcaster. A Broadcaster which ended closes w
+// right away.
+func (b *Broadcaster) Subscribe(w *Writer) {
+	b.mutex.Lock()
+	if b.err != nil {
+		b.mutex.Unlock()
+		w.Close()
+		return
+	}
+	b.subs = append(b.subs, w)
+	b.mutex.Unlock()
+}
+
+// Unsubscribe removes w from the receivers, if present, without closing w.
+// No writes on w happen once Unsubscribe returns.
+func (b *Broadcaster) Unsubscribe(w *Writer) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, sub := range b.subs {
+		if sub == w {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Subscribers returns the number of receivers.
+func (b *Broadcaster) Subscribers() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subs)
+}
+
+// Err returns the error of source once the Broadcaster ended, or nil when
+// it did not end yet.
+func (b *Broadcaster) Err() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.err
+}
+
+// Close closes source, and it closes all subscribers once the routine
+// terminated, i.e., subscribers get the pending data before Close returns.
+// The return is the result of the Close on source.
+func (b *Broadcaster) Close() error {
+	b.closeOnce.Do(func() {
+		b.closeErr = b.r.Close()
+		<-b.done
+	})
+	return b.closeErr
+}
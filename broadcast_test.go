@@ -0,0 +1,54 @@
+package nbio
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Broadcaster must pass all data to each subscriber, and drop the ones
+// which fail.
+func TestBroadcaster(t *testing.T) {
+	pr, pw := io.Pipe()
+	b := NewBroadcaster(pr)
+	defer b.Close()
+
+	sink1, sink2 := new(recordSink), new(recordSink)
+	b.Subscribe(NewWriter(sink1, time.Second))
+	b.Subscribe(NewWriter(sink2, time.Second))
+	b.Subscribe(NewWriter(failSink{errSink}, time.Second))
+
+	pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if n := b.Subscribers(); n != 2 {
+		t.Errorf("got %d subscribers, want 2 after sink error", n)
+	}
+	if err := b.Err(); err != nil {
+		t.Errorf("got error %v before end of source", err)
+	}
+
+	pw.Close()
+	<-b.done
+	if err := b.Err(); err != io.EOF {
+		t.Errorf("got error %v, want %v", err, io.EOF)
+	}
+	for i, sink := range []*recordSink{sink1, sink2} {
+		sink.Lock()
+		got := strings.Join(sink.writes, "")
+		sink.Unlock()
+		if want := feed + feed; got != want {
+			t.Errorf("subscriber %d got %q, want %q", i, got, want)
+		}
+	}
+
+	// late subscriber
+	sink3 := new(recordSink)
+	w := NewWriter(sink3, time.Second)
+	b.Subscribe(w)
+	if _, err := w.Write([]byte(feed)); err != ErrWriterClosed {
+		t.Errorf("late subscriber got Write error %v, want %v", err, ErrWriterClosed)
+	}
+}
@@ -0,0 +1,140 @@
+package nbio
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrCheckpointExceeded signals a checkpoint which retained more data
+// than the limit permits.
+var ErrCheckpointExceeded = errors.New("checkpoint retention limit exceeded")
+
+var (
+	errCheckpointLost     = errors.New("checkpoint lost on consumption other than read")
+	errCheckpointReleased = errors.New("checkpoint released")
+	errCheckpointAhead    = errors.New("checkpoint ahead of the read position")
+)
+
+// DefaultCheckpointLimit is the retention limit when no other is set.
+const defaultCheckpointLimit = 16 * bufferSize
+
+// Token is a consumption position, as returned by Checkpoint.
+type Token struct {
+	epoch uint64 // checkpoints generation
+	pos   int64  // read position
+}
+
+// Checkpoints is the Checkpoint state of a Reader.
+type checkpoints struct {
+	outstanding int    // number of tokens not released
+	limit       int    // journal size limit, with zero for the default
+	epoch       uint64 // increments on each end of the journal
+	endErr      error  // cause for the end of the previous epoch
+
+	journal    []byte // data read since the first outstanding token
+	journalPos int64  // read position of the journal start
+}
+
+// SetCheckpointLimit sets the maximum number of bytes retained for
+// checkpoints, which defaults to 32 KiB. Memory use is in the order of
+// the limit while any checkpoint is outstanding.
+func (r *Reader) SetCheckpointLimit(bytes int) {
+	r.cp.limit = bytes
+}
+
+// Checkpoint captures the read position, such that Restore can get it
+// back. Data read after the first outstanding checkpoint is retained, up
+// to the limit of SetCheckpointLimit, until all checkpoints are released.
+// Both Read and its variants are covered. Other means of consumption, such
+// as Seek, Lease and StepRead discards, invalidate all checkpoints. So
+// does a retention beyond the limit, with ErrCheckpointExceeded.
+func (r *Reader) Checkpoint() (Token, error) {
+	if r.fatal != nil {
+		return Token{}, r.fatal
+	}
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return Token{}, ErrClosed
+	}
+	if r.cp.outstanding == 0 {
+		r.cp.journal = r.cp.journal[:0]
+		r.cp.journalPos = r.pos
+	}
+	r.cp.outstanding++
+	return Token{epoch: r.cp.epoch, pos: r.pos}, nil
+}
+
+// Restore gets the read position of a checkpoint back. Any data read
+// after the checkpoint is delivered once more. The checkpoint remains
+// outstanding. Checkpoints taken after t can be restored after the read
+// position passes them again.
+func (r *Reader) Restore(t Token) error {
+	if err := r.checkToken(t); err != nil {
+		return err
+	}
+	if t.pos == r.pos {
+		return nil
+	}
+
+	replay := r.cp.journal[t.pos-r.cp.journalPos:]
+	joined := append([]byte(nil), replay...)
+	if r.buf != nil {
+		joined = append(joined, r.buf[r.i:]...)
+	}
+	r.cp.journal = r.cp.journal[:t.pos-r.cp.journalPos]
+	r.pos = t.pos
+	r.queued(len(replay))
+
+	// position change only; no swap
+	if cap(r.buf) != 0 && !r.loose {
+		r.pool <- r.buf
+	}
+	r.buf, r.i, r.loose = joined, 0, true
+	return nil
+}
+
+// Release ends a checkpoint. The data retained is freed once all
+// checkpoints are released.
+func (r *Reader) Release(t Token) error {
+	if t.epoch != r.cp.epoch {
+		return r.checkToken(t)
+	}
+	r.cp.outstanding--
+	if r.cp.outstanding == 0 {
+		r.endCheckpoints(errCheckpointReleased)
+	}
+	return nil
+}
+
+// CheckToken returns whether t can be restored.
+func (r *Reader) checkToken(t Token) error {
+	switch {
+	case t.epoch+1 == r.cp.epoch:
+		return r.cp.endErr
+	case t.epoch != r.cp.epoch:
+		return errCheckpointReleased
+	case t.pos > r.pos:
+		return errCheckpointAhead
+	}
+	return nil
+}
+
+// Record retains data read.
+func (r *Reader) record(data []byte) {
+	limit := r.cp.limit
+	if limit == 0 {
+		limit = defaultCheckpointLimit
+	}
+	if len(r.cp.journal)+len(data) > limit {
+		r.endCheckpoints(ErrCheckpointExceeded)
+		return
+	}
+	r.cp.journal = append(r.cp.journal, data...)
+}
+
+// EndCheckpoints invalidates all outstanding tokens.
+func (r *Reader) endCheckpoints(cause error) {
+	r.cp.outstanding = 0
+	r.cp.epoch++
+	r.cp.endErr = cause
+	r.cp.journal = nil
+}
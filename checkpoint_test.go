@@ -0,0 +1,78 @@
+package nbio
+
+import (
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must deliver data again after Restore.
+func TestCheckpointRestore(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World!"}}, time.Second)
+	defer r.Close()
+	time.Sleep(9 * time.Millisecond)
+
+	read := func(size int, want string) {
+		t.Helper()
+		buf := make([]byte, size)
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("got %q, want %q", buf[:n], want)
+		}
+	}
+
+	read(3, "Hel")
+	t1, err := r.Checkpoint()
+	if err != nil {
+		t.Fatal("Checkpoint error:", err)
+	}
+	read(3, "lo ")
+	t2, err := r.Checkpoint()
+	if err != nil {
+		t.Fatal("Checkpoint error:", err)
+	}
+	read(64, "World!")
+
+	if err := r.Restore(t2); err != nil {
+		t.Fatal("Restore error:", err)
+	}
+	read(64, "World!")
+	if err := r.Restore(t1); err != nil {
+		t.Fatal("Restore error:", err)
+	}
+	if err := r.Restore(t2); err == nil {
+		t.Error("Restore of a later checkpoint got no error")
+	}
+	read(64, "lo World!")
+
+	if err := r.Release(t1); err != nil {
+		t.Error("Release error:", err)
+	}
+	if err := r.Release(t2); err != nil {
+		t.Error("Release error:", err)
+	}
+	if err := r.Restore(t1); err == nil {
+		t.Error("Restore after Release got no error")
+	}
+}
+
+// Non blocking Reader must limit the data retained for checkpoints.
+func TestCheckpointExceeded(t *testing.T) {
+	r := NewReader(&stepSource{{data: feed}}, time.Second)
+	defer r.Close()
+	r.SetCheckpointLimit(4)
+
+	tok, err := r.Checkpoint()
+	if err != nil {
+		t.Fatal("Checkpoint error:", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	}
+	if err := r.Restore(tok); err != ErrCheckpointExceeded {
+		t.Errorf("Restore got error %v, want ErrCheckpointExceeded", err)
+	}
+}
@@ -0,0 +1,36 @@
+package nbio
+
+import "time"
+
+// Clock is a source of time, as configured with WithClock. Tests may use
+// a fake for deterministic timeouts.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a new Timer, which expires after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a time.Timer of a Clock.
+type Timer interface {
+	// C returns the channel on which the expiry is sent.
+	C() <-chan time.Time
+	// Stop prevents the expiry, like time.Timer Stop does.
+	Stop() bool
+	// Reset makes the timer expire after d, like time.Timer Reset does.
+	Reset(d time.Duration) bool
+}
+
+// StdClock is the Clock of package time.
+type stdClock struct{}
+
+func (stdClock) Now() time.Time { return time.Now() }
+
+func (stdClock) NewTimer(d time.Duration) Timer {
+	return stdTimer{time.NewTimer(d)}
+}
+
+// StdTimer is the Timer of package time.
+type stdTimer struct{ *time.Timer }
+
+func (t stdTimer) C() <-chan time.Time { return t.Timer.C }
@@ -0,0 +1,117 @@
+package nbio
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock advances on demand only.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d), armed: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Armed returns the number of timers pending.
+func (c *fakeClock) armed() int {
+	c.Lock()
+	defer c.Unlock()
+	var n int
+	for _, t := range c.timers {
+		if t.armed {
+			n++
+		}
+	}
+	return n
+}
+
+// Advance moves the time forward by d, and it fires any timers due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.armed && !t.at.After(c.now) {
+			t.armed = false
+			t.c <- c.now
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	c     chan time.Time
+	at    time.Time
+	armed bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	was := t.armed
+	t.armed = false
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	was := t.armed
+	t.at, t.armed = t.clock.now.Add(d), true
+	return was
+}
+
+// Non blocking Reader must time out on the clock of WithClock.
+func TestReaderOptionsClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1, 0)}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReaderOptions(pr, WithTimeout(time.Hour), WithClock(clock))
+	defer r.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 64))
+		done <- err
+	}()
+	for clock.armed() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.advance(time.Hour - 1)
+	select {
+	case err := <-done:
+		t.Fatalf("Read returned %v before due", err)
+	case <-time.After(9 * time.Millisecond):
+	}
+	clock.advance(1)
+	select {
+	case err := <-done:
+		if err != ErrNoData {
+			t.Errorf("Read got error %v, want %v", err, ErrNoData)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not time out on the clock")
+	}
+	if got := r.Stats().Waited; got != time.Hour {
+		t.Errorf("got %s waited, want 1h", got)
+	}
+}
@@ -0,0 +1,35 @@
+package nbio
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// NewCmdReader starts cmd with both its standard output and its standard
+// error on one pipe, and it returns a Reader of the pipe, with the timeout
+// semantics of NewReader. The output of both streams arrives in order of
+// write by the process. Reads give io.EOF once the process exited, and
+// once any child which inherited the pipe exited too. Close on the Reader
+// does not stop the process. The Wait method of cmd still applies, which
+// may be called at any time, as the Reader owns the pipe regardless.
+func NewCmdReader(cmd *exec.Cmd, timeout time.Duration) (*Reader, error) {
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, errors.New("command output already set")
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	err = cmd.Start()
+	// the process has its own copy
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	return NewReader(pr, timeout), nil
+}
@@ -0,0 +1,44 @@
+package nbio
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// Cmd Reader must deliver both output streams of a process.
+func TestCmdReader(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell:", err)
+	}
+	cmd := exec.Command("sh", "-c", "echo out; sleep 0.05; echo err >&2")
+	r, err := NewCmdReader(cmd, 9*time.Millisecond)
+	if err != nil {
+		t.Fatal("NewCmdReader error:", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 16)
+	r.SetReadTimeout(time.Second)
+	if n, err := r.Read(buf); string(buf[:n]) != "out\n" || err != nil {
+		t.Errorf("Read = (%d, %v) %q, want (4, <nil>) %q", n, err, buf[:n], "out\n")
+	}
+	r.SetReadTimeout(0)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read during sleep = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	r.SetReadTimeout(time.Second)
+	if all, err := ioutil.ReadAll(r); string(all) != "err\n" || err != nil {
+		t.Errorf("got %q with error %v, want %q", all, err, "err\n")
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Error("Wait error:", err)
+	}
+
+	cmd = exec.Command("sh", "-c", "true")
+	cmd.Stdout = ioutil.Discard
+	if _, err := NewCmdReader(cmd, 0); err == nil {
+		t.Error("NewCmdReader with Stdout set got no error")
+	}
+}
@@ -0,0 +1,164 @@
+package nbio
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Conn is a net.Conn with non blocking reads, as returned by NewConn.
+// Writes and addressing pass through to the connection as is.
+type Conn struct {
+	net.Conn         // connection for all but reads
+	r        *Reader // reads on the connection
+
+	readDeadline atomic.Value // time.Time from SetReadDeadline
+}
+
+// NewConn returns a new wrapper whose Read function gives a time out
+// (with ErrNoData, a net.Error) when no data arrives within timeout, like
+// a Reader from NewReader does. The Reader owns the read side of conn.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	c := &Conn{Conn: conn, r: NewReader(conn, timeout)}
+	c.readDeadline.Store(time.Time{})
+	return c
+}
+
+// Read implements the net.Conn interface. A read deadline in effect
+// applies on top of the timeout, with os.ErrDeadlineExceeded, like a
+// net.Conn does, and the Conn remains usable.
+func (c *Conn) Read(p []byte) (int, error) {
+	return readDeadline(c.r, c.readDeadline.Load().(time.Time), p)
+}
+
+// ReadDeadline reads from r with the deadline on top of the timeout, if
+// any, with os.ErrDeadlineExceeded on expiry.
+func readDeadline(r *Reader, deadline time.Time, p []byte) (int, error) {
+	if deadline.IsZero() {
+		return r.Read(p)
+	}
+	n, err := r.ReadBoth(deadline, p)
+	if err == ErrDeadlineExceeded {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// Close implements the net.Conn interface.
+func (c *Conn) Close() error {
+	return c.r.Close()
+}
+
+// SetDeadline implements the net.Conn interface. The read deadline does
+// not reach the connection, as the read routine must not fail on it.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements the net.Conn interface. The zero value
+// restores reads with just the timeout.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(t)
+	return nil
+}
+
+// RWConn is a net.Conn on top of an io.ReadWriteCloser, as returned by
+// NewRWConn.
+type RWConn struct {
+	rwc io.ReadWriteCloser
+	rw  *ReadWriter // I/O on rwc
+
+	readDeadline  atomic.Value // time.Time from SetReadDeadline
+	writeDeadline atomic.Value // time.Time from SetWriteDeadline
+}
+
+// NewRWConn returns a new net.Conn for streams without deadline support,
+// such as SSH channels, websockets, and serial ports. Reads give a time
+// out (with ErrNoData) when no data arrives within timeout, like a Reader
+// from NewReader does. Writes block like they do on a net.Conn, yet they
+// pass through buffers, i.e., a Write may return before its data reaches
+// rwc. Read and write deadlines apply with os.ErrDeadlineExceeded, as they
+// do on a net.Conn, and the RWConn remains usable. Addresses come from
+// rwc, when it has LocalAddr and RemoteAddr methods. Unlike a net.Conn,
+// reads must not be concurrent with each other, and neither may writes.
+func NewRWConn(rwc io.ReadWriteCloser, timeout time.Duration) *RWConn {
+	c := &RWConn{rwc: rwc, rw: NewReadWriter(rwc, timeout, -1)}
+	c.readDeadline.Store(time.Time{})
+	c.writeDeadline.Store(time.Time{})
+	return c
+}
+
+// Read implements the net.Conn interface.
+func (c *RWConn) Read(p []byte) (int, error) {
+	return readDeadline(c.rw.Reader, c.readDeadline.Load().(time.Time), p)
+}
+
+// Write implements the net.Conn interface. An expired write deadline
+// fails with os.ErrDeadlineExceeded, in which case the remainder of p is
+// not written at all.
+func (c *RWConn) Write(p []byte) (int, error) {
+	deadline := c.writeDeadline.Load().(time.Time)
+	if deadline.IsZero() {
+		return c.rw.Write(p)
+	}
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := c.rw.write(p, wait)
+	if err == ErrWriteBufferFull {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// Close implements the net.Conn interface. Pending writes pass to rwc
+// before rwc is closed, which blocks for as long as rwc does.
+func (c *RWConn) Close() error {
+	return c.rw.Close()
+}
+
+// LocalAddr implements the net.Conn interface.
+func (c *RWConn) LocalAddr() net.Addr {
+	if a, ok := c.rwc.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	return rwAddr{}
+}
+
+// RemoteAddr implements the net.Conn interface.
+func (c *RWConn) RemoteAddr() net.Addr {
+	if a, ok := c.rwc.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr()
+	}
+	return rwAddr{}
+}
+
+// RwAddr is the net.Addr of streams without address.
+type rwAddr struct{}
+
+func (rwAddr) Network() string { return "nbio" }
+func (rwAddr) String() string  { return "nbio" }
+
+// SetDeadline implements the net.Conn interface.
+func (c *RWConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements the net.Conn interface. The zero value
+// restores reads with just the timeout.
+func (c *RWConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(t)
+	return nil
+}
+
+// SetWriteDeadline implements the net.Conn interface. The zero value
+// restores writes without limit.
+func (c *RWConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return nil
+}
@@ -0,0 +1,129 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Non blocking Conn must time out on reads as a net.Error.
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := NewConn(client, 9*time.Millisecond)
+	defer c.Close()
+	var _ net.Conn = c
+
+	buf := make([]byte, len(feed))
+	n, err := c.Read(buf)
+	if n != 0 || err != ErrNoData {
+		t.Fatalf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("Read error %v is not a net.Error timeout", err)
+	}
+
+	go server.Write([]byte(feed))
+	if n, err := c.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	if n, err := c.Read(buf); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read after deadline = (%d, %v), want (0, %v)", n, err, os.ErrDeadlineExceeded)
+	}
+	c.SetReadDeadline(time.Time{})
+	if n, err := c.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read with deadline cleared = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	// pass-through
+	if got, want := c.LocalAddr(), client.LocalAddr(); got != want {
+		t.Errorf("LocalAddr = %v, want %v", got, want)
+	}
+	if got, want := c.RemoteAddr(), client.RemoteAddr(); got != want {
+		t.Errorf("RemoteAddr = %v, want %v", got, want)
+	}
+	go func() {
+		got := make([]byte, len(feed))
+		n, _ := server.Read(got)
+		server.Write(got[:n])
+	}()
+	if n, err := c.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Errorf("Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = c.Read(buf)
+	for i := 0; err == ErrNoData && i < 100; i++ {
+		n, err = c.Read(buf)
+	}
+	if n != len(feed) || err != nil || string(buf) != feed {
+		t.Errorf("echo Read = (%d, %v) %q, want (%d, <nil>) %q", n, err, buf[:n], len(feed), feed)
+	}
+}
+
+// PipeRWC joins two pipes into a stream.
+type pipeRWC struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeRWC) Close() error {
+	p.PipeWriter.Close()
+	return p.PipeReader.Close()
+}
+
+// Non blocking RWConn must apply deadlines on both reads and writes.
+func TestRWConn(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	defer inW.Close()
+	defer outR.Close()
+	c := NewRWConn(pipeRWC{inR, outW}, 9*time.Millisecond)
+	defer c.Close()
+	var _ net.Conn = c
+
+	buf := make([]byte, len(feed))
+	if n, err := c.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	if n, err := c.Read(buf); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read after deadline = (%d, %v), want (0, %v)", n, err, os.ErrDeadlineExceeded)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	go inW.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if n, err := c.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	// nothing reads from out; buffers fill up
+	c.SetWriteDeadline(time.Now().Add(9 * time.Millisecond))
+	chunk := make([]byte, 4*bufferSize)
+	if n, err := c.Write(chunk); n >= len(chunk) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write on full buffers = (%d, %v), want less than %d bytes with %v", n, err, len(chunk), os.ErrDeadlineExceeded)
+	}
+	c.SetWriteDeadline(time.Now().Add(-time.Second))
+	if n, err := c.Write([]byte(feed)); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write after deadline = (%d, %v), want (0, %v)", n, err, os.ErrDeadlineExceeded)
+	}
+
+	if got := c.LocalAddr().String(); got != "nbio" {
+		t.Errorf("LocalAddr = %q, want \"nbio\"", got)
+	}
+	if got := c.RemoteAddr().Network(); got != "nbio" {
+		t.Errorf("RemoteAddr network = %q, want \"nbio\"", got)
+	}
+
+	// Close flushes
+	go ioutil.ReadAll(outR)
+	if err := c.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+}
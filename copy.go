@@ -0,0 +1,60 @@
+package nbio
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ErrIdleTimeout signals a Copy which got no data within the idle timeout.
+// The error satisfies net.Error, like ErrNoData does.
+var ErrIdleTimeout error = &timeoutError{"no data within idle timeout"}
+
+// Copy copies from src to dst, like io.Copy does, until either io.EOF, an
+// error, or until no data arrives for idleTimeout, in which case Copy
+// fails with ErrIdleTimeout. A negative idle timeout waits without limit.
+// Copy returns nil on io.EOF. A Reader as src passes its buffers to dst
+// as is, without copy, and its timeout does not apply. Other sources get a
+// Reader of their own, whose read routine lives on until a pending read
+// on src returns, after an idle timeout. Data read ahead is lost then.
+func Copy(dst io.Writer, src io.Reader, idleTimeout time.Duration) (written int64, err error) {
+	r, ok := src.(*Reader)
+	if !ok {
+		r = NewReader(ioutil.NopCloser(src), 0)
+		// detaches from src after an idle timeout
+		defer r.CloseWithTimeout(0)
+	}
+
+	idleSince := time.Now()
+	for {
+		wait, waitErr := idleTimeout, ErrIdleTimeout
+		if idleTimeout >= 0 {
+			wait = time.Until(idleSince.Add(idleTimeout))
+			if wait < 0 {
+				wait = 0 // poll once more
+			}
+		}
+		if !r.deadline.IsZero() {
+			if until := time.Until(r.deadline); wait < 0 || until < wait {
+				wait, waitErr = until, ErrDeadlineExceeded
+				if wait <= 0 {
+					return written, waitErr
+				}
+			}
+		}
+
+		if err := r.await(wait, waitErr); err != nil {
+			r.track(0, err)
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+		done, err := r.writeBuf(dst)
+		written += int64(done)
+		if err != nil {
+			return written, err
+		}
+		idleSince = time.Now()
+	}
+}
@@ -0,0 +1,57 @@
+package nbio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Copy must abort once data stops flowing.
+func TestCopyIdle(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			pw.Write([]byte(feed))
+			time.Sleep(3 * time.Millisecond)
+		}
+	}()
+
+	var dst bytes.Buffer
+	n, err := Copy(&dst, r, 50*time.Millisecond)
+	if err != ErrIdleTimeout {
+		t.Errorf("Copy got error %v, want %v", err, ErrIdleTimeout)
+	}
+	if want := strings.Repeat(feed, 3); n != int64(len(want)) || dst.String() != want {
+		t.Errorf("Copy = %d bytes %q, want %q", n, dst.String(), want)
+	}
+}
+
+// Copy must pass all data on any io.Reader.
+func TestCopyReader(t *testing.T) {
+	payload := make([]byte, 100*bufferSize)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+
+	var dst bytes.Buffer
+	n, err := Copy(&dst, bytes.NewReader(payload), time.Second)
+	if n != int64(len(payload)) || err != nil {
+		t.Errorf("Copy = (%d, %v), want (%d, <nil>)", n, err, len(payload))
+	}
+	if !bytes.Equal(dst.Bytes(), payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", dst.Len(), len(payload))
+	}
+
+	// blocking source without data
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	if n, err := Copy(&dst, pr, 9*time.Millisecond); n != 0 || err != ErrIdleTimeout {
+		t.Errorf("Copy on silent pipe = (%d, %v), want (0, %v)", n, err, ErrIdleTimeout)
+	}
+}
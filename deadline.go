@@ -0,0 +1,109 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DeadlineSource is a source with deadline support, such as net.Conn, or
+// an os.File in non blocking mode.
+type DeadlineSource interface {
+	io.ReadCloser
+	SetReadDeadline(time.Time) error
+}
+
+// DeadlineReader is a non blocking reader without read routine, as
+// returned by NewDeadlineReader.
+type DeadlineReader struct {
+	isClosed int32 // Close flag
+
+	source  DeadlineSource
+	timeout time.Duration
+
+	err error // sticky error of source
+}
+
+// NewDeadlineReader returns a new non blocking wrapper whose Read function
+// gives a time out (with ErrNoData) when no data arrives within timeout,
+// like a Reader from NewReader does. Instead of a read routine, each Read
+// sets a deadline on source, and it reads into p directly. This saves the
+// goroutine, the data copy and the handoff per source, at the cost of a
+// deadline update per Read. A negative timeout makes Read wait without
+// limit. Timeouts below a millisecond apply as one millisecond, because a
+// deadline in the past fails without any read. The Reader owns source,
+// including its read deadline. The mode works on any platform with deadline
+// support in source, which includes sockets and named pipes on Windows.
+//
+// Errors of the underlying reader are sticky, as with NewReader. Deadline
+// expiry is not an error of source. Reads give either data or an error,
+// i.e., any data along with an error from source comes first, and the error
+// comes on the call after.
+func NewDeadlineReader(source DeadlineSource, timeout time.Duration) *DeadlineReader {
+	if timeout >= 0 && timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	return &DeadlineReader{source: source, timeout: timeout}
+}
+
+// Read implements the io.Reader interface. Read must not be called
+// concurrently with itself.
+func (r *DeadlineReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	var deadline time.Time // zero blocks
+	if r.timeout >= 0 {
+		deadline = time.Now().Add(r.timeout)
+	}
+	if err := r.source.SetReadDeadline(deadline); err != nil {
+		r.err = err
+		return 0, err
+	}
+
+	n, err := r.source.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	if isDeadlineErr(err) {
+		if n != 0 {
+			return n, nil
+		}
+		return 0, ErrNoData
+	}
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		// interrupted by Close
+		if n != 0 {
+			return n, nil // ErrClosed on the next call
+		}
+		return 0, ErrClosed
+	}
+	r.err = err
+	if n != 0 {
+		return n, nil // error on the next call
+	}
+	return 0, err
+}
+
+// IsDeadlineErr returns whether err came from deadline expiry.
+func isDeadlineErr(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// Close closes the source, which interrupts any pending Read. Any read
+// afterwards fails with ErrClosed.
+func (r *DeadlineReader) Close() error {
+	atomic.StoreInt32(&r.isClosed, 1)
+	return r.source.Close()
+}
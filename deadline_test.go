@@ -0,0 +1,94 @@
+package nbio
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Deadline Reader must time out with ErrNoData, without read routine.
+func TestDeadlineRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	r := NewDeadlineReader(client, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("no data: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q present in:\n%s", readRoutineStackEl, dump)
+	}
+
+	go server.Write([]byte(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	} else if got := string(buf); got != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+
+	// timeout does not stick
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("idle: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	// pipe may fail on SetReadDeadline rather than Read
+	server.Close()
+	n, err := r.Read(buf)
+	if n != 0 || err == nil || err == ErrNoData {
+		t.Fatalf("Read after server close = (%d, %v), want (0, <source error>)", n, err)
+	}
+	if n, again := r.Read(buf); n != 0 || again != err {
+		t.Errorf("Read after source error = (%d, %v), want (0, %v)", n, again, err)
+	}
+}
+
+// Deadline Reader must abort a pending read on Close.
+func TestDeadlineReadClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	r := NewDeadlineReader(client, -1)
+
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		r.Close()
+	}()
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrClosed {
+		t.Errorf("Read during Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrClosed {
+		t.Errorf("Read after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+}
+
+// DataEOFSource gives its data together with io.EOF.
+type dataEOFSource struct{ data string }
+
+func (s *dataEOFSource) Read(p []byte) (int, error) {
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	return n, io.EOF
+}
+
+func (*dataEOFSource) Close() error                    { return nil }
+func (*dataEOFSource) SetReadDeadline(time.Time) error { return nil }
+
+// Deadline Reader must deliver data along with an error before the error.
+func TestDeadlineReadDataEOF(t *testing.T) {
+	r := NewDeadlineReader(&dataEOFSource{feed}, time.Second)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read after data = (%d, %v), want (0, %v)", n, err, io.EOF)
+		}
+	}
+}
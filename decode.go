@@ -0,0 +1,124 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ErrDecodePending signals a DecodeTimeout on another value than the one
+// of the decode in progress.
+var ErrDecodePending = errors.New("decode of another value pending")
+
+// Decoder reads values from a stream, such as the decoders of packages
+// encoding/json, encoding/gob and encoding/xml do.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// TimedDecoder is a non blocking message decoder, as returned by
+// NewTimedDecoder.
+type TimedDecoder struct {
+	r   *Reader
+	dec Decoder
+
+	busy  interface{} // value of the decode in progress, if any
+	done  chan error  // result of the decode in progress
+	timer *time.Timer // lazy init, reusable
+}
+
+// NewTimedDecoder returns a new adapter which decodes the messages of r
+// with the decoder from newDecoder, e.g.,
+//
+//	nbio.NewTimedDecoder(r, func(r io.Reader) nbio.Decoder {
+//		return json.NewDecoder(r)
+//	})
+//
+// Decoders fail on read errors, which makes the timeout of r unfit for
+// them. Instead, the decoder gets reads which wait for data without limit,
+// on a routine of its own, while DecodeTimeout applies the timeout to the
+// message as a whole. Reads on r must be left to the TimedDecoder.
+func NewTimedDecoder(r *Reader, newDecoder func(io.Reader) Decoder) *TimedDecoder {
+	return &TimedDecoder{
+		r:    r,
+		dec:  newDecoder(decodeSource{r}),
+		done: make(chan error, 1),
+	}
+}
+
+// DecodeSource reads without timeout.
+type decodeSource struct{ r *Reader }
+
+func (s decodeSource) Read(p []byte) (int, error) {
+	return s.r.track(s.r.read(p, -1, ErrNoData))
+}
+
+// DecodeTimeout decodes the next message into v, which must be a pointer,
+// and it waits for the message up to d. Expiry gives ErrNoData, in which
+// case the partial message remains with the decode in progress, and v may
+// still change. The next DecodeTimeout must pass the same v, which then
+// continues where the previous left off. Other values fail with
+// ErrDecodePending until then. Zero d waits for nothing, while negative d
+// waits without limit. Errors from the decoder are returned as is.
+func (t *TimedDecoder) DecodeTimeout(v interface{}, d time.Duration) error {
+	if reflect.ValueOf(v).Kind() != reflect.Ptr {
+		return errors.New("decode into non-pointer")
+	}
+	if t.busy == nil {
+		t.busy = v
+		go func() { t.done <- t.dec.Decode(v) }()
+	} else if t.busy != v {
+		return ErrDecodePending
+	}
+
+	var err error
+	select {
+	case err = <-t.done:
+	default:
+		if d == 0 {
+			return ErrNoData
+		}
+		var expire <-chan time.Time // nil blocks
+		if d > 0 {
+			expire = t.resetTimer(d)
+		}
+		select {
+		case err = <-t.done:
+			if expire != nil {
+				t.stopTimer()
+			}
+		case <-expire:
+			return ErrNoData
+		}
+	}
+	t.busy = nil
+	return err
+}
+
+// resetTimer arms the timer for d, regardless of its prior state.
+func (t *TimedDecoder) resetTimer(d time.Duration) <-chan time.Time {
+	if t.timer == nil {
+		t.timer = time.NewTimer(d)
+	} else {
+		t.stopTimer()
+		t.timer.Reset(d)
+	}
+	return t.timer.C
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (t *TimedDecoder) stopTimer() {
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+			// received already
+		}
+	}
+}
+
+// Close closes the Reader, which ends any decode in progress.
+func (t *TimedDecoder) Close() error {
+	return t.r.Close()
+}
@@ -0,0 +1,39 @@
+package nbio
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// Timed Decoder must retain partial messages across timeouts.
+func TestTimedDecoder(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	d := NewTimedDecoder(NewReader(pr, 0), func(r io.Reader) Decoder {
+		return json.NewDecoder(r)
+	})
+	defer d.Close()
+
+	var v struct{ A int }
+	if err := d.DecodeTimeout(&v, 9*time.Millisecond); err != ErrNoData {
+		t.Errorf("idle: DecodeTimeout got error %v, want %v", err, ErrNoData)
+	}
+	go pw.Write([]byte(`{"A":`))
+	if err := d.DecodeTimeout(&v, 9*time.Millisecond); err != ErrNoData {
+		t.Errorf("partial: DecodeTimeout got error %v, want %v", err, ErrNoData)
+	}
+	var other struct{ A int }
+	if err := d.DecodeTimeout(&other, 0); err != ErrDecodePending {
+		t.Errorf("DecodeTimeout on other value got error %v, want %v", err, ErrDecodePending)
+	}
+
+	go pw.Write([]byte(`42} {"A":7}`))
+	if err := d.DecodeTimeout(&v, time.Second); err != nil || v.A != 42 {
+		t.Errorf("DecodeTimeout got %+v with error %v, want A 42", v, err)
+	}
+	if err := d.DecodeTimeout(&other, time.Second); err != nil || other.A != 7 {
+		t.Errorf("DecodeTimeout got %+v with error %v, want A 7", other, err)
+	}
+}
@@ -0,0 +1,107 @@
+package nbio
+
+import (
+	"io"
+	"time"
+)
+
+// Retry pauses of ReadAtLeast on ErrNoData without data.
+const (
+	minRetryPause = time.Millisecond
+	maxRetryPause = 64 * time.Millisecond
+)
+
+// DrainAvailable reads from r until the first ErrNoData, and it returns
+// all data up to then. ErrNoData itself is not an error to DrainAvailable,
+// as it means "done for now". Any other error, including io.EOF, comes
+// with the data read before it. Note that a Reader waits for its timeout
+// before the final ErrNoData, which makes a timeout of zero read whatever
+// is available without delay.
+func DrainAvailable(r io.ReadCloser) ([]byte, error) {
+	var all []byte
+	for {
+		if len(all) == cap(all) {
+			all = append(all, make([]byte, bufferSize)...)[:len(all)]
+		}
+		n, err := r.Read(all[len(all):cap(all)])
+		all = all[:len(all)+n]
+		switch err {
+		case nil:
+			continue
+		case ErrNoData:
+			return all, nil
+		default:
+			return all, err
+		}
+	}
+}
+
+// ReadFull reads exactly len(buf) bytes from r into buf, like io.ReadFull
+// does, yet it retries on ErrNoData until deadline. See ReadAtLeast for
+// the details.
+func ReadFull(r io.Reader, buf []byte, deadline time.Time) (n int, err error) {
+	return ReadAtLeast(r, buf, len(buf), deadline)
+}
+
+// ReadAtLeast reads from r into buf until it has at least min bytes, like
+// io.ReadAtLeast does, yet it retries on ErrNoData until deadline, which
+// then fails with ErrDeadlineExceeded. The zero deadline retries without
+// limit. Reads on a Reader do not wait past deadline. Errors other than
+// ErrNoData are returned as with io.ReadAtLeast, i.e., io.EOF after some,
+// yet not all data becomes io.ErrUnexpectedEOF.
+//
+// Sources which give ErrNoData without wait, such as a Reader with a zero
+// timeout, a NewRawReader, or a PollReader with a zero timeout, get their
+// retries spaced out, with a pause which doubles up to maxRetryPause while
+// no data arrives. Such sources may thus see data with a delay up to then.
+func ReadAtLeast(r io.Reader, buf []byte, min int, deadline time.Time) (n int, err error) {
+	if len(buf) < min {
+		return 0, io.ErrShortBuffer
+	}
+	nr, _ := r.(*Reader)
+
+	pause := minRetryPause
+	for n < min && err == nil {
+		start := time.Now()
+		var did int
+		if nr != nil && !deadline.IsZero() {
+			did, err = nr.ReadBoth(deadline, buf[n:])
+		} else {
+			did, err = r.Read(buf[n:])
+		}
+		n += did
+
+		if err != ErrNoData {
+			continue
+		}
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			err = ErrDeadlineExceeded
+			continue
+		}
+		err = nil // retry
+
+		if did != 0 {
+			pause = minRetryPause
+			continue
+		}
+		// no busy loop on sources which do not wait
+		wait := pause - now.Sub(start)
+		if !deadline.IsZero() && wait > deadline.Sub(now) {
+			wait = deadline.Sub(now)
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		if pause < maxRetryPause {
+			pause *= 2
+		}
+	}
+
+	if n >= min {
+		err = nil
+	} else if n != 0 && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
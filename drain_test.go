@@ -0,0 +1,129 @@
+package nbio
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// DrainAvailable must stop on ErrNoData without error.
+func TestDrainAvailable(t *testing.T) {
+	pr, pw := io.Pipe()
+	// room for each chunk to be pending
+	r := NewReaderOptions(pr, WithBufferCount(5))
+	defer r.Close()
+
+	for _, chunk := range []string{"ab", "cd", "ef"} {
+		if _, err := pw.Write([]byte(chunk)); err != nil {
+			t.Fatal("pipe write error:", err)
+		}
+	}
+	time.Sleep(9 * time.Millisecond)
+
+	got, err := DrainAvailable(r)
+	if string(got) != "abcdef" || err != nil {
+		t.Errorf("DrainAvailable = (%q, %v), want (\"abcdef\", <nil>)", got, err)
+	}
+	got, err = DrainAvailable(r)
+	if len(got) != 0 || err != nil {
+		t.Errorf("DrainAvailable without data = (%q, %v), want (\"\", <nil>)", got, err)
+	}
+}
+
+// DrainAvailable must pass source errors with the data before them.
+func TestDrainAvailableError(t *testing.T) {
+	source := &stepSource{
+		{data: "ab"},
+		{data: "cd", err: errTransient},
+	}
+	r := NewReader(source, time.Second)
+	defer r.Close()
+
+	got, err := DrainAvailable(r)
+	if string(got) != "abcd" || err != errTransient {
+		t.Errorf("DrainAvailable = (%q, %v), want (\"abcd\", %v)", got, err, errTransient)
+	}
+	got, err = DrainAvailable(r)
+	if len(got) != 0 || err != errTransient {
+		t.Errorf("DrainAvailable after error = (%q, %v), want (\"\", %v)", got, err, errTransient)
+	}
+}
+
+// ReadFull must retry on ErrNoData until the data is complete.
+func TestReadFull(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Millisecond)
+	defer r.Close()
+
+	go func() {
+		for _, chunk := range []string{"ab", "cd", "ef"} {
+			time.Sleep(3 * time.Millisecond)
+			pw.Write([]byte(chunk))
+		}
+		pw.Close()
+	}()
+
+	buf := make([]byte, 4)
+	n, err := ReadFull(r, buf, time.Now().Add(time.Second))
+	if n != 4 || err != nil || string(buf) != "abcd" {
+		t.Errorf("ReadFull = (%d, %v) %q, want (4, <nil>) \"abcd\"", n, err, buf[:n])
+	}
+	n, err = ReadFull(r, buf, time.Time{})
+	if n != 2 || err != io.ErrUnexpectedEOF || string(buf[:n]) != "ef" {
+		t.Errorf("ReadFull at end = (%d, %v) %q, want (2, %v) \"ef\"", n, err, buf[:n], io.ErrUnexpectedEOF)
+	}
+	if n, err = ReadFull(r, buf, time.Time{}); n != 0 || err != io.EOF {
+		t.Errorf("ReadFull after end = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+}
+
+// ReadAtLeast must fail on deadline expiry with the partial data.
+func TestReadAtLeastDeadline(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	go pw.Write([]byte("ab"))
+	start := time.Now()
+	buf := make([]byte, 8)
+	n, err := ReadAtLeast(r, buf, 4, start.Add(9*time.Millisecond))
+	if n != 2 || err != ErrDeadlineExceeded {
+		t.Errorf("ReadAtLeast = (%d, %v), want (2, %v)", n, err, ErrDeadlineExceeded)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("ReadAtLeast took %s, want no wait past the deadline", d)
+	}
+
+	// any io.Reader
+	n, err = ReadAtLeast(iotest.OneByteReader(strings.NewReader(feed)), buf, 4, time.Time{})
+	if n < 4 || err != nil {
+		t.Errorf("ReadAtLeast on plain reader = (%d, %v), want at least 4 bytes", n, err)
+	}
+}
+
+// NoDataSource gives ErrNoData without wait, and it counts the reads.
+type noDataSource struct{ reads int }
+
+func (s *noDataSource) Read(p []byte) (int, error) {
+	s.reads++
+	return 0, ErrNoData
+}
+
+// ReadAtLeast must not busy loop on sources which do not wait.
+func TestReadAtLeastNoWait(t *testing.T) {
+	var source noDataSource
+	start := time.Now()
+	n, err := ReadFull(&source, make([]byte, 4), start.Add(100*time.Millisecond))
+	if n != 0 || err != ErrDeadlineExceeded {
+		t.Errorf("ReadFull = (%d, %v), want (0, %v)", n, err, ErrDeadlineExceeded)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("ReadFull took %s, want the 100ms deadline", d)
+	}
+	if source.reads > 20 {
+		t.Errorf("got %d reads in 100ms, want retries spaced out", source.reads)
+	}
+}
@@ -0,0 +1,90 @@
+package nbio
+
+import (
+	"io"
+	"time"
+)
+
+// ReadWriter is a non blocking wrapper for both directions, as returned by
+// NewReadWriter.
+type ReadWriter struct {
+	*Reader // reads on the stream
+	*Writer // writes on the stream
+
+	rwc io.ReadWriteCloser // stream
+}
+
+// NewReadWriter returns a new non blocking wrapper whose reads behave like
+// those of NewReader, with readTimeout, and whose writes behave like those
+// of NewWriter, with writeTimeout. Close terminates both the read routine
+// and the write routine, and it closes rwc once.
+func NewReadWriter(rwc io.ReadWriteCloser, readTimeout, writeTimeout time.Duration) *ReadWriter {
+	return &ReadWriter{
+		// Close on the Reader closes rwc
+		Reader: NewReader(rwc, readTimeout),
+		Writer: NewWriter(nopWriteCloser{rwc}, writeTimeout),
+		rwc:    rwc,
+	}
+}
+
+// NopWriteCloser is an io.WriteCloser whose Close does nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Close passes any pending writes to the stream, like Flush does, within
+// the timeout of the Writer, and then it terminates the write routine, and
+// it closes the stream once, like Reader.Close does. On expiry, the stream
+// is closed first, which aborts the pending writes, with ErrWriteBufferFull
+// as the return. Otherwise, the return is the first error of the Writer
+// and the Reader, in that order.
+func (rw *ReadWriter) Close() error {
+	err := rw.Writer.Flush()
+	switch err {
+	case ErrWriterClosed:
+		err = nil // CloseWrite or Close before
+	case ErrWriteBufferFull:
+		// stream does not take the writes in time
+		rw.Reader.Close()
+		rw.Writer.Close()
+		return err
+	}
+	if err2 := rw.Writer.Close(); err == nil {
+		err = err2
+	}
+	if err2 := rw.Reader.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// CloseRead shuts down the read side of the stream, such as a TCP half-close
+// does, and reads deliver the data buffered, after which they fail with
+// io.EOF. Writes continue unaffected. The stream needs a CloseRead method,
+// like *net.TCPConn and *net.UnixConn have, or else CloseRead fails with
+// ErrUnsupported. Close still applies afterwards.
+func (rw *ReadWriter) CloseRead() error {
+	c, ok := rw.rwc.(interface{ CloseRead() error })
+	if !ok {
+		return ErrUnsupported
+	}
+	return c.CloseRead()
+}
+
+// CloseWrite passes any pending writes to the stream, like Writer.Close
+// does, and then it shuts down the write side of the stream, such as a TCP
+// half-close does, which gives the peer an io.EOF. Reads continue
+// unaffected. The stream needs a CloseWrite method, like *net.TCPConn and
+// *net.UnixConn have, or else CloseWrite fails with ErrUnsupported, and
+// the Writer remains open. Close still applies afterwards.
+func (rw *ReadWriter) CloseWrite() error {
+	c, ok := rw.rwc.(interface{ CloseWrite() error })
+	if !ok {
+		return ErrUnsupported
+	}
+	err := rw.Writer.Close()
+	if err2 := c.CloseWrite(); err == nil {
+		err = err2
+	}
+	return err
+}
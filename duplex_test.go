@@ -0,0 +1,159 @@
+package nbio
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// CloseCountRWC counts Close calls on a stream.
+type closeCountRWC struct {
+	pipeRWC
+	closes int32
+}
+
+func (s *closeCountRWC) Close() error {
+	atomic.AddInt32(&s.closes, 1)
+	return s.pipeRWC.Close()
+}
+
+// Non blocking ReadWriter must end both routines with one Close.
+func TestReadWriter(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	defer inW.Close()
+	defer outR.Close()
+	stream := &closeCountRWC{pipeRWC: pipeRWC{inR, outW}}
+	rw := NewReadWriter(stream, 9*time.Millisecond, time.Second)
+
+	buf := make([]byte, len(feed))
+	if n, err := rw.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	go inW.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if n, err := rw.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if n, err := rw.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Errorf("Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	got := make(chan []byte)
+	go func() {
+		all, _ := ioutil.ReadAll(outR)
+		got <- all
+	}()
+	if err := rw.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+	if all := <-got; string(all) != feed {
+		t.Errorf("stream got %q, want %q", all, feed)
+	}
+	if n := atomic.LoadInt32(&stream.closes); n != 1 {
+		t.Errorf("stream got %d Close calls, want 1", n)
+	}
+
+	dump := stackDump()
+	for _, el := range []string{readRoutineStackEl, writeRoutineStackEl} {
+		if strings.Contains(dump, el) {
+			t.Errorf("routine element %q still present in:\n%s", el, dump)
+		}
+	}
+}
+
+// Non blocking ReadWriter must not wait past the write timeout on Close.
+func TestReadWriterCloseTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	rw := NewReadWriter(client, time.Second, 9*time.Millisecond)
+
+	// server does not read
+	if n, err := rw.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Errorf("Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	done := make(chan error, 1)
+	go func() { done <- rw.Close() }()
+	select {
+	case err := <-done:
+		if err != ErrWriteBufferFull {
+			t.Errorf("Close got error %v, want %v", err, ErrWriteBufferFull)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on pending writes")
+	}
+	if n, err := server.Read(make([]byte, len(feed))); n != 0 || err != io.EOF {
+		t.Errorf("server Read after Close = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+
+	dump := stackDump()
+	for _, el := range []string{readRoutineStackEl, writeRoutineStackEl} {
+		if strings.Contains(dump, el) {
+			t.Errorf("routine element %q still present in:\n%s", el, dump)
+		}
+	}
+}
+
+// Non blocking ReadWriter must shut down each direction on its own.
+func TestReadWriterHalfClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no TCP on loopback:", err)
+	}
+	defer ln.Close()
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer peer.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal("accept error:", err)
+	}
+	rw := NewReadWriter(conn, time.Second, time.Second)
+	defer rw.Close()
+
+	if _, err := rw.Write([]byte(feed)); err != nil {
+		t.Fatal("Write error:", err)
+	}
+	if err := rw.CloseWrite(); err != nil {
+		t.Fatal("CloseWrite error:", err)
+	}
+	if got, err := ioutil.ReadAll(peer); string(got) != feed || err != nil {
+		t.Errorf("peer got %q with error %v, want %q", got, err, feed)
+	}
+
+	// reads still work
+	if _, err := peer.Write([]byte(feed)); err != nil {
+		t.Fatal("peer write error:", err)
+	}
+	buf := make([]byte, len(feed))
+	if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != feed {
+		t.Errorf("got %q with error %v, want %q", buf, err, feed)
+	}
+	if err := rw.CloseRead(); err != nil {
+		t.Fatal("CloseRead error:", err)
+	}
+	if n, err := rw.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after CloseRead = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+
+	pr, pw := io.Pipe()
+	pipes := NewReadWriter(pipeRWC{pr, pw}, 0, 0)
+	defer pipes.Close()
+	if err := pipes.CloseRead(); err != ErrUnsupported {
+		t.Errorf("CloseRead on pipes got error %v, want %v", err, ErrUnsupported)
+	}
+	if err := pipes.CloseWrite(); err != ErrUnsupported {
+		t.Errorf("CloseWrite on pipes got error %v, want %v", err, ErrUnsupported)
+	}
+	// Writer must remain open
+	go io.Copy(ioutil.Discard, pr)
+	if n, err := pipes.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Errorf("Write after CloseWrite = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
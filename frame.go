@@ -0,0 +1,109 @@
+package nbio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrFrameTooLarge signals a frame which exceeds the maximum size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// FrameReader is a non blocking reader of length-prefixed frames, as
+// returned by NewFrameReader.
+type FrameReader struct {
+	r *Reader
+
+	max   int     // frame size limit
+	head  [4]byte // size prefix
+	headN int     // number of bytes in head
+	frame []byte  // pending payload, if any
+	err   error   // sticky error of ReadFrame
+}
+
+// NewFrameReader returns a new non blocking reader of frames, each with a
+// 4-byte size prefix in big-endian order, followed by the payload. Frames
+// of more than maxFrame bytes fail with ErrFrameTooLarge, before any
+// allocation, which guards against memory exhaustion.
+func NewFrameReader(source io.ReadCloser, timeout time.Duration, maxFrame int) *FrameReader {
+	return &FrameReader{r: NewReader(source, timeout), max: maxFrame}
+}
+
+// ReadFrame returns the payload of the next frame. The timeout covers the
+// call as a whole, i.e., it does not restart per source read. ReadFrame
+// fails with ErrNoData when the frame does not complete in time, in which
+// case the partial frame is retained for the next call. Frames which
+// exceed the limit fail with an error which wraps ErrFrameTooLarge,
+// including the size. Such error is sticky, and so are source errors. An
+// io.EOF within a frame gives io.ErrUnexpectedEOF.
+func (f *FrameReader) ReadFrame() ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var deadline time.Time
+	if f.r.timeout > 0 {
+		deadline = time.Now().Add(f.r.timeout)
+	}
+
+	for f.headN < len(f.head) {
+		n, err := f.fill(f.head[f.headN:], deadline)
+		f.headN += n
+		if err != nil {
+			return nil, f.fail(err)
+		}
+	}
+	if f.frame == nil {
+		size := binary.BigEndian.Uint32(f.head[:])
+		if uint64(size) > uint64(f.max) {
+			f.err = fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+			return nil, f.err
+		}
+		f.frame = make([]byte, 0, size)
+	}
+	for len(f.frame) < cap(f.frame) {
+		n, err := f.fill(f.frame[len(f.frame):cap(f.frame)], deadline)
+		f.frame = f.frame[:len(f.frame)+n]
+		if err != nil {
+			return nil, f.fail(err)
+		}
+	}
+
+	frame := f.frame
+	f.frame = nil
+	f.headN = 0
+	return frame, nil
+}
+
+// fill reads into p with the remainder of the time until deadline.
+func (f *FrameReader) fill(p []byte, deadline time.Time) (int, error) {
+	r := f.r
+	timeout := r.timeout
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+		if timeout < 0 {
+			timeout = 0 // poll once more
+		}
+	}
+	return r.track(r.read(p, timeout, ErrNoData))
+}
+
+// fail applies a read error to the FrameReader state.
+func (f *FrameReader) fail(err error) error {
+	if err == ErrNoData {
+		// partial frame retained
+		return err
+	}
+	if err == io.EOF && (f.headN != 0 || f.frame != nil) {
+		err = io.ErrUnexpectedEOF
+	}
+	f.err = err
+	return err
+}
+
+// Close closes the source. Any read afterwards fails with ErrClosed.
+func (f *FrameReader) Close() error {
+	return f.r.Close()
+}
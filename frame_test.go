@@ -0,0 +1,62 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Non blocking FrameReader must retain partial frames on timeout.
+func TestReadFrame(t *testing.T) {
+	pr, pw := io.Pipe()
+	f := NewFrameReader(pr, 9*time.Millisecond, 64)
+	defer f.Close()
+
+	go pw.Write([]byte{0, 0, 0, 12, 'H', 'e', 'l', 'l', 'o'})
+	if frame, err := f.ReadFrame(); err != ErrNoData {
+		t.Fatalf("ReadFrame = (%q, %v), want ErrNoData", frame, err)
+	}
+	go pw.Write([]byte(" World!\x00\x00\x00\x00"))
+	for _, want := range []string{feed, ""} {
+		frame, err := f.ReadFrame()
+		if err != nil {
+			t.Fatal("ReadFrame error:", err)
+		}
+		if string(frame) != want {
+			t.Errorf("got frame %q, want %q", frame, want)
+		}
+	}
+
+	pw.Close()
+	if frame, err := f.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame at end = (%q, %v), want %v", frame, err, io.EOF)
+	}
+}
+
+// Non blocking FrameReader must reject frames beyond its limit.
+func TestReadFrameTooLarge(t *testing.T) {
+	f := NewFrameReader(&stepSource{{data: "\x7f\xff\xff\xff"}}, time.Second, 4096)
+	defer f.Close()
+
+	frame, err := f.ReadFrame()
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("ReadFrame = (%q, %v), want ErrFrameTooLarge", frame, err)
+	}
+	if err.Error() != "frame too large: 2147483647 bytes" {
+		t.Errorf("got error %q", err)
+	}
+	if _, again := f.ReadFrame(); again != err {
+		t.Errorf("got error %v after %v, want sticky", again, err)
+	}
+}
+
+// Non blocking FrameReader must signal frames cut short.
+func TestReadFrameTruncated(t *testing.T) {
+	f := NewFrameReader(&stepSource{{data: "\x00\x00\x00\x05"}, {data: "abc"}}, time.Second, 64)
+	defer f.Close()
+
+	if frame, err := f.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFrame = (%q, %v), want %v", frame, err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,114 @@
+package nbio
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencySample is the timing of one read on source.
+type LatencySample struct {
+	Arrival  time.Time // read on source completed
+	Delivery time.Time // buffer became current for reads
+	Size     int       // number of bytes read
+}
+
+// QueueDelay returns the amount of time the data waited for the consumer.
+func (s LatencySample) QueueDelay() time.Duration {
+	return s.Delivery.Sub(s.Arrival)
+}
+
+// LatencyRing holds the samples of RecordLatency.
+type latencyRing struct {
+	sync.Mutex
+
+	// arrivals in flight, by sequence number
+	pending []pendingSample
+
+	samples []LatencySample // ring
+	n       int             // total number of samples
+}
+
+// PendingSample is an arrival which awaits delivery.
+type pendingSample struct {
+	seq uint64
+	LatencySample
+}
+
+// RecordLatency enables timing of the last n reads on source, as retrieved
+// with LatencySamples. Any previous recording is discarded. Memory use is
+// bounded by n.
+func (r *Reader) RecordLatency(n int) {
+	r.latency.Store(&latencyRing{
+		// each buffer may hold an arrival, plus one in handoff
+		pending: make([]pendingSample, cap(r.pool)+1),
+		samples: make([]LatencySample, n),
+	})
+}
+
+// LatencySamples returns the samples from RecordLatency, if any, in order
+// of delivery. It may be called from any goroutine.
+func (r *Reader) LatencySamples() []LatencySample {
+	l, _ := r.latency.Load().(*latencyRing)
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if l.n < len(l.samples) {
+		return append([]LatencySample(nil), l.samples[:l.n]...)
+	}
+	i := l.n % len(l.samples)
+	return append(append([]LatencySample(nil), l.samples[i:]...), l.samples[:i]...)
+}
+
+// Arrive registers a source read with its sequence number.
+func (l *latencyRing) arrive(seq uint64, size int) {
+	l.Lock()
+	p := &l.pending[seq%uint64(len(l.pending))]
+	p.seq = seq
+	p.Arrival = time.Now()
+	p.Size = size
+	l.Unlock()
+}
+
+// Deliver registers the consumption of the source read with seq.
+func (l *latencyRing) deliver(seq uint64) {
+	l.Lock()
+	defer l.Unlock()
+	p := &l.pending[seq%uint64(len(l.pending))]
+	if p.seq != seq || p.Arrival.IsZero() || len(l.samples) == 0 {
+		// not recorded
+		return
+	}
+	p.Delivery = time.Now()
+	l.samples[l.n%len(l.samples)] = p.LatencySample
+	l.n++
+	p.Arrival = time.Time{}
+}
+
+// ReaderTrace has optional hooks into the stages of a Reader, for latency
+// debugging, similar in spirit to net/http/httptrace. Nil hooks are not
+// called. The source hooks run on the read routine, and ReadWaited runs
+// on the goroutine of Read. Hooks must not block.
+type ReaderTrace struct {
+	// SourceReadStart is called before each read on source.
+	SourceReadStart func(t time.Time)
+	// SourceReadDone is called after each read on source.
+	SourceReadDone func(t time.Time, n int, err error)
+	// ReadWaited is called on each read which waited for data, with the
+	// amount of time waited, regardless of the outcome.
+	ReadWaited func(d time.Duration)
+}
+
+// SetTrace installs t, whose hooks apply from then on. A nil t disables
+// tracing. SetTrace may be called from any goroutine.
+func (r *Reader) SetTrace(t *ReaderTrace) {
+	r.trace.Store(t)
+}
+
+// loadTrace returns the SetTrace in effect, if any.
+func (r *Reader) loadTrace() *ReaderTrace {
+	t, _ := r.trace.Load().(*ReaderTrace)
+	return t
+}
@@ -0,0 +1,144 @@
+package nbio
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must sample the queue delay of each source read.
+func TestLatencySamples(t *testing.T) {
+	for _, n := range []int{1, 4} {
+		r := NewLazyReader(&stepSource{{data: "Hello "}, {data: "World!"}}, time.Second)
+		r.RecordLatency(n)
+		r.Start()
+		time.Sleep(9 * time.Millisecond)
+
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Fatal("read error:", err)
+		}
+		samples := r.LatencySamples()
+		r.Close()
+
+		want := 2
+		if n < want {
+			want = n
+		}
+		if len(samples) != want {
+			t.Fatalf("got %d samples with limit %d, want %d", len(samples), n, want)
+		}
+		for i, s := range samples {
+			if s.Size != 6 {
+				t.Errorf("limit %d: sample %d got size %d, want 6", n, i, s.Size)
+			}
+		}
+		if n > 1 && samples[0].QueueDelay() < 9*time.Millisecond {
+			t.Errorf("got queue delay %s for the first read, want at least 9ms", samples[0].QueueDelay())
+		}
+	}
+}
+
+// Non blocking Reader must sample each source read in flight, with any
+// number of buffers.
+func TestLatencySamplesBufferCount(t *testing.T) {
+	var steps stepSource
+	for i := 1; i <= 7; i++ {
+		steps = append(steps, struct {
+			data string
+			err  error
+		}{data: feed[:i]})
+	}
+	gate := make(chan struct{})
+	r := NewReaderOptions(gateSource{gate, &steps}, WithBufferCount(8), WithTimeout(time.Second))
+	defer r.Close()
+	r.RecordLatency(10)
+	close(gate)
+	time.Sleep(9 * time.Millisecond) // all pending
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal("read error:", err)
+	}
+	samples := r.LatencySamples()
+	if len(samples) != 7 {
+		t.Fatalf("got %d samples, want 7", len(samples))
+	}
+	for i, s := range samples {
+		if s.Size != i+1 {
+			t.Errorf("sample %d got size %d, want %d", i, s.Size, i+1)
+		}
+	}
+}
+
+// Non blocking Reader must sample each source read merged by SetSource.
+func TestLatencySamplesSetSource(t *testing.T) {
+	gate := make(chan struct{})
+	source := gateSource{gate, &stepSource{{data: "a"}, {data: "bc"}, {data: "def", err: errTransient}}}
+	r := NewReaderOptions(source, WithBufferCount(8), WithTimeout(time.Second))
+	defer r.Close()
+	r.RecordLatency(10)
+	close(gate)
+	time.Sleep(9 * time.Millisecond) // source failed
+
+	if err := r.SetSource(&stepSource{{data: "ghij"}}); err != nil {
+		t.Fatal("SetSource error:", err)
+	}
+	if got, err := ioutil.ReadAll(r); string(got) != "abcdefghij" || err != nil {
+		t.Fatalf("ReadAll = (%q, %v), want %q", got, err, "abcdefghij")
+	}
+	samples := r.LatencySamples()
+	if len(samples) != 4 {
+		t.Fatalf("got %d samples, want 4", len(samples))
+	}
+	for i, s := range samples {
+		if s.Size != i+1 {
+			t.Errorf("sample %d got size %d, want %d", i, s.Size, i+1)
+		}
+	}
+}
+
+// Non blocking Reader must call the trace hooks around each stage.
+func TestTrace(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewLazyReader(pr, time.Second)
+
+	var mutex sync.Mutex
+	var starts, dones int
+	var waited time.Duration
+	r.SetTrace(&ReaderTrace{
+		SourceReadStart: func(time.Time) {
+			mutex.Lock()
+			starts++
+			mutex.Unlock()
+		},
+		SourceReadDone: func(_ time.Time, n int, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			dones++
+			if dones == 1 && (n != len(feed) || err != nil) {
+				t.Errorf("source read done with (%d, %v), want (%d, <nil>)", n, err, len(feed))
+			}
+		},
+		ReadWaited: func(d time.Duration) { waited = d },
+	})
+
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	if n, err := r.Read(make([]byte, 64)); n != len(feed) || err != nil {
+		t.Fatalf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if waited < 9*time.Millisecond {
+		t.Errorf("got %s waited, want at least 9ms", waited)
+	}
+
+	// pending read on source fails
+	r.Close()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if starts != 2 || dones != 2 {
+		t.Errorf("got %d source read starts and %d completions, want 2 each", starts, dones)
+	}
+}
@@ -0,0 +1,38 @@
+package nbio
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+)
+
+// LeakWarnings enables a log warning for each Reader which is garbage
+// collected without Close. The setting applies to Readers constructed
+// while it is on. A read routine which is alive keeps its Reader from
+// garbage collection, i.e., the warning shows for Readers of which the
+// routine terminated, e.g., on io.EOF, or never started, as a Reader from
+// NewLazyReader may. The source of such a Reader was not closed.
+var LeakWarnings bool
+
+// LeakReport receives the LeakWarnings.
+var leakReport = log.Printf
+
+// watchLeak installs the LeakWarnings finalizer on r, when enabled.
+func watchLeak(r *Reader) {
+	if LeakWarnings {
+		runtime.SetFinalizer(r, reportLeak)
+	}
+}
+
+// unwatchLeak removes any watchLeak from r, and it returns r.
+func unwatchLeak(r *Reader) *Reader {
+	runtime.SetFinalizer(r, nil)
+	return r
+}
+
+// reportLeak is the finalizer for watchLeak. It must not retain r.
+func reportLeak(r *Reader) {
+	if atomic.LoadInt32(&r.isClosed) == 0 {
+		leakReport("nbio: Reader on %T garbage collected without Close", r.r)
+	}
+}
@@ -0,0 +1,81 @@
+package nbio
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must warn when garbage collected without Close.
+func TestLeakWarnings(t *testing.T) {
+	reports := make(chan string, 4)
+	leakReport = func(format string, args ...interface{}) {
+		reports <- fmt.Sprintf(format, args...)
+	}
+	LeakWarnings = true
+	defer func() {
+		LeakWarnings = false
+		leakReport = log.Printf
+	}()
+
+	func() {
+		NewLazyReader(errCloser{strings.NewReader(feed)}, time.Second)
+		r := NewLazyReader(errCloser{strings.NewReader(feed)}, time.Second)
+		r.Close()
+	}()
+
+	timeout := time.After(time.Second)
+	for {
+		runtime.GC()
+		select {
+		case report := <-reports:
+			if !strings.Contains(report, "nbio.errCloser") {
+				t.Errorf("got report %q, want source type", report)
+			}
+			select {
+			case report := <-reports:
+				t.Errorf("got report %q for closed Reader", report)
+			case <-time.After(9 * time.Millisecond):
+			}
+			return
+		case <-timeout:
+			t.Fatal("no leak report")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Non blocking Reader must not warn on the state Reset leaves behind.
+func TestLeakWarningsReset(t *testing.T) {
+	reports := make(chan string, 4)
+	leakReport = func(format string, args ...interface{}) {
+		reports <- fmt.Sprintf(format, args...)
+	}
+	LeakWarnings = true
+	defer func() {
+		LeakWarnings = false
+		leakReport = log.Printf
+	}()
+
+	shared := &sync.Pool{New: func() interface{} { return make([]byte, 128) }}
+	r := NewReaderOptions(errCloser{strings.NewReader(feed)},
+		WithTimeout(time.Second),
+		WithBufferSize(128),
+		WithSharedPool(shared))
+	r.Reset(errCloser{strings.NewReader(feed)}, time.Second)
+	defer r.Close()
+
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		select {
+		case report := <-reports:
+			t.Fatalf("got report %q for a live Reader", report)
+		case <-time.After(9 * time.Millisecond):
+		}
+	}
+	runtime.KeepAlive(r)
+}
@@ -0,0 +1,41 @@
+package nbio
+
+import "io"
+
+// LimitedReader is a non blocking reader of a fixed amount of data, as
+// returned by NewLimitedReader.
+type LimitedReader struct {
+	r *Reader
+	n int64 // bytes remaining
+}
+
+// NewLimitedReader returns a new reader of at most n bytes from r, e.g.,
+// for a message body with a known length. Reads fail with io.EOF once n
+// bytes passed, without any read on r, which retains the data after the
+// limit for further use. Timeouts give ErrNoData, like r does.
+func NewLimitedReader(r *Reader, n int64) *LimitedReader {
+	return &LimitedReader{r: r, n: n}
+}
+
+// Read implements the io.Reader interface.
+func (l *LimitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Remaining returns the number of bytes left until the limit.
+func (l *LimitedReader) Remaining() int64 {
+	return l.n
+}
+
+// Close closes the underlying Reader.
+func (l *LimitedReader) Close() error {
+	return l.r.Close()
+}
@@ -0,0 +1,35 @@
+package nbio
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// Non blocking LimitedReader must stop at the limit, and retain the rest.
+func TestLimitedReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	l := NewLimitedReader(r, 8)
+	defer l.Close()
+	var _ io.ReadCloser = l
+
+	buf := make([]byte, 64)
+	if n, err := l.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	go pw.Write([]byte("Hello World!"))
+	if n, err := l.Read(buf); n != 8 || err != nil || string(buf[:n]) != "Hello Wo" {
+		t.Errorf("Read = (%d, %v) %q, want (8, <nil>) \"Hello Wo\"", n, err, buf[:n])
+	}
+	if n, err := l.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at limit = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if got := l.Remaining(); got != 0 {
+		t.Errorf("got %d bytes remaining, want 0", got)
+	}
+	if n, err := r.Read(buf); n != 4 || err != nil || string(buf[:n]) != "rld!" {
+		t.Errorf("Read after limit = (%d, %v) %q, want (4, <nil>) \"rld!\"", n, err, buf[:n])
+	}
+}
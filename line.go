@@ -0,0 +1,82 @@
+package nbio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLineTooLong signals a line which exceeds the maximum length.
+var ErrLineTooLong = errors.New("line too long")
+
+// LineReader is a non blocking reader of text lines with a size limit, as
+// returned by NewBoundedLineReader.
+type LineReader struct {
+	r *Reader
+
+	max  int    // line size limit
+	line []byte // pending line
+	err  error  // sticky error of ReadLine
+}
+
+// NewBoundedLineReader returns a new non blocking reader of lines with at
+// most maxLine bytes each, excluding the newline. ReadLine fails with
+// ErrLineTooLong once maxLine is exceeded, before any more data buffers,
+// which guards against memory exhaustion.
+func NewBoundedLineReader(source io.ReadCloser, timeout time.Duration, maxLine int) *LineReader {
+	return &LineReader{r: NewReader(source, timeout), max: maxLine}
+}
+
+// ReadLine returns the next line, without its newline. ReadLine fails
+// with ErrNoData when the line does not complete in time. The partial
+// line is retained for the next call in such case. Lines which exceed the
+// limit fail with an error which wraps ErrLineTooLong, including the
+// number of bytes seen. Such error is sticky, and so are source errors.
+// Data after the last newline is lost on io.EOF.
+func (l *LineReader) ReadLine() ([]byte, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	r := l.r
+	for {
+		if err := r.await(r.timeout, ErrNoData); err != nil {
+			if err != ErrNoData {
+				l.err = err
+			}
+			return nil, err
+		}
+		chunk := r.buf[r.i:]
+
+		end := bytes.IndexByte(chunk, '\n')
+		n := end
+		if end < 0 {
+			n = len(chunk)
+		}
+		if len(l.line)+n > l.max {
+			l.err = fmt.Errorf("%w: %d bytes without newline", ErrLineTooLong, len(l.line)+n)
+			l.line = nil
+			return nil, l.err
+		}
+
+		l.line = append(l.line, chunk[:n]...)
+		atomic.AddUint64(&r.stats.delivered, uint64(n))
+		if end < 0 {
+			r.i += n
+			r.consumed(n)
+			continue
+		}
+		r.i += n + 1
+		r.consumed(n + 1)
+		line := l.line
+		l.line = nil
+		return line, nil
+	}
+}
+
+// Close closes the source. Any read afterwards fails with ErrClosed.
+func (l *LineReader) Close() error {
+	return l.r.Close()
+}
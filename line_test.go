@@ -0,0 +1,50 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Non blocking LineReader must retain partial lines on timeout.
+func TestReadLine(t *testing.T) {
+	pr, pw := io.Pipe()
+	l := NewBoundedLineReader(pr, 9*time.Millisecond, 12)
+	defer l.Close()
+
+	go pw.Write([]byte("Hello "))
+	if line, err := l.ReadLine(); err != ErrNoData {
+		t.Fatalf("ReadLine = (%q, %v), want ErrNoData", line, err)
+	}
+	go pw.Write([]byte("World!\nnext\n"))
+	for _, want := range []string{feed, "next"} {
+		line, err := l.ReadLine()
+		if err != nil {
+			t.Fatal("ReadLine error:", err)
+		}
+		if string(line) != want {
+			t.Errorf("got line %q, want %q", line, want)
+		}
+	}
+}
+
+// Non blocking LineReader must not buffer beyond its limit.
+func TestReadLineTooLong(t *testing.T) {
+	source := ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 1<<20)))
+	l := NewBoundedLineReader(source, time.Second, 4096)
+	defer l.Close()
+
+	line, err := l.ReadLine()
+	if !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("ReadLine = (%q, %v), want ErrLineTooLong", line, err)
+	}
+	if want := "line too long: 6144 bytes without newline"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if _, err2 := l.ReadLine(); err2 != err {
+		t.Errorf("ReadLine after limit got error %v, want %v", err2, err)
+	}
+}
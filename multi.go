@@ -0,0 +1,256 @@
+package nbio
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MultiReader merges the data of multiple sources, as returned by
+// NewMultiReader.
+type MultiReader struct {
+	live   []*Reader     // sources which did not end yet
+	cursor int           // index in live to read first
+	ready  chan struct{} // shared Ready signal of live
+	timer  *time.Timer   // lazy init, reusable
+
+	// maximum amount of time to wait for data
+	timeout time.Duration
+
+	err    error // first error of a dropped source
+	closed bool  // Close flag
+}
+
+// NewMultiReader returns a new non blocking wrapper which reads from all
+// sources at once, each with its own read routine. Read delivers from any
+// source with data, in turns, such that no source starves another. Reads
+// give a time out (with ErrNoData) when none of the sources has any data
+// within timeout, with the semantics of NewReader otherwise. A source which
+// fails is dropped, and reads continue with the rest. Read returns io.EOF
+// once all sources are gone. Close reports the first error of a dropped
+// source, if any.
+func NewMultiReader(timeout time.Duration, sources ...io.ReadCloser) *MultiReader {
+	m := &MultiReader{
+		live:    make([]*Reader, len(sources)),
+		ready:   make(chan struct{}, 1),
+		timeout: timeout,
+	}
+	for i, source := range sources {
+		// poll each Reader; the shared signal does the wait
+		r := newReader(source, 0)
+		r.ready = m.ready
+		r.Start()
+		m.live[i] = r
+	}
+	return m
+}
+
+// Read implements the io.Reader interface. Each call delivers data from
+// one source only.
+func (m *MultiReader) Read(p []byte) (int, error) {
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	var expire <-chan time.Time // lazy init; nil blocks
+	for {
+		for k := 0; k < len(m.live); k++ {
+			i := (m.cursor + k) % len(m.live)
+			n, err := m.live[i].Read(p)
+			switch {
+			case n != 0:
+				if expire != nil {
+					m.stopTimer()
+				}
+				m.cursor = i + 1
+				return n, nil
+			case err == ErrNoData:
+				continue
+			}
+
+			// drop source, and scan again from there
+			if closeErr := m.live[i].Close(); err == io.EOF {
+				err = closeErr
+			}
+			if m.err == nil {
+				m.err = err
+			}
+			m.live = append(m.live[:i], m.live[i+1:]...)
+			m.cursor, k = i, -1
+		}
+		if len(m.live) == 0 {
+			if expire != nil {
+				m.stopTimer()
+			}
+			return 0, io.EOF
+		}
+
+		if m.timeout == 0 {
+			return 0, ErrNoData
+		}
+		if expire == nil && m.timeout > 0 {
+			expire = m.resetTimer()
+		}
+		select {
+		case <-m.ready:
+			continue
+		case <-expire:
+			return 0, ErrNoData
+		}
+	}
+}
+
+// resetTimer arms the timer for the timeout, regardless of its prior
+// state, i.e., any expiry from before does not show on the return.
+func (m *MultiReader) resetTimer() <-chan time.Time {
+	if m.timer == nil {
+		m.timer = time.NewTimer(m.timeout)
+	} else {
+		m.stopTimer()
+		m.timer.Reset(m.timeout)
+	}
+	return m.timer.C
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (m *MultiReader) stopTimer() {
+	if !m.timer.Stop() {
+		select {
+		case <-m.timer.C:
+		default:
+			// received already
+		}
+	}
+}
+
+// Close closes all sources which did not end yet. The read routines
+// terminate before Close returns. Any read afterwards fails with
+// ErrClosed. Close must not be called concurrently with Read.
+func (m *MultiReader) Close() error {
+	err := m.err
+	for _, r := range m.live {
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	m.live = nil
+	m.closed = true
+	return err
+}
+
+// NewConcatReader returns a new Reader which delivers the data of each
+// source in turn, like io.MultiReader does, with the timeout semantics of
+// NewReader. Each source is closed as soon as it ends with io.EOF, after
+// which reads continue with the next source. Any other error of a source
+// is sticky, like it is with a single source. Reads give io.EOF once all
+// sources ended. Close closes the sources which did not end yet, and it
+// reports the first error from closing any of the sources.
+func NewConcatReader(timeout time.Duration, sources ...io.ReadCloser) *Reader {
+	return NewReader(&concatSource{todo: sources}, timeout)
+}
+
+// ConcatSource reads sources in order.
+type concatSource struct {
+	mutex  sync.Mutex
+	todo   []io.ReadCloser // pending sources, with the current first
+	closed bool            // Close flag
+	err    error           // first error from Close of an ended source
+}
+
+// Read implements the io.Reader interface.
+func (c *concatSource) Read(p []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if len(c.todo) == 0 {
+			c.mutex.Unlock()
+			return 0, io.EOF
+		}
+		current := c.todo[0]
+		c.mutex.Unlock()
+
+		n, err := current.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+
+		c.mutex.Lock()
+		if !c.closed {
+			if closeErr := current.Close(); c.err == nil {
+				c.err = closeErr
+			}
+			c.todo = c.todo[1:]
+		}
+		c.mutex.Unlock()
+		if n != 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close implements the io.Closer interface.
+func (c *concatSource) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.err
+	for _, source := range c.todo {
+		if closeErr := source.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	c.todo = nil
+	return err
+}
+
+// Select waits until any of readers has data ready, or an error to report,
+// without consuming anything, and it returns the index of the first such
+// reader in argument order. Select fails with ErrNoData when none of the
+// readers gets ready within timeout. A timeout of zero makes Select wait
+// for nothing, while a negative timeout makes it wait without limit. A
+// closed reader counts as ready, as its reads fail with ErrClosed. Select
+// fails with io.EOF without any readers. Select must be called from the
+// goroutine which reads.
+func Select(timeout time.Duration, readers ...*Reader) (int, error) {
+	if len(readers) == 0 {
+		return -1, io.EOF
+	}
+	var cases []reflect.SelectCase // lazy init
+	var timer *time.Timer          // lazy init
+	for {
+		for i, r := range readers {
+			if r.Buffered() != 0 || r.PeekErr() != nil || atomic.LoadInt32(&r.isClosed) != 0 {
+				if timer != nil {
+					timer.Stop()
+				}
+				return i, nil
+			}
+		}
+		if timeout == 0 {
+			return -1, ErrNoData
+		}
+
+		if cases == nil {
+			cases = make([]reflect.SelectCase, len(readers), len(readers)+1)
+			for i, r := range readers {
+				cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Ready())}
+			}
+			if timeout > 0 {
+				timer = time.NewTimer(timeout)
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+			}
+		}
+		// Ready signals may be spurious; check again
+		if chosen, _, _ := reflect.Select(cases); chosen == len(readers) {
+			return -1, ErrNoData
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package nbio
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Multi Reader must deliver data from all sources.
+func TestMultiReaderInterleave(t *testing.T) {
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	m := NewMultiReader(time.Second, pr1, pr2)
+
+	go func() {
+		for _, s := range []string{"a1", "a2", "a3"} {
+			pw1.Write([]byte(s))
+		}
+		pw1.Close()
+	}()
+	go func() {
+		for _, s := range []string{"b1", "b2", "b3"} {
+			pw2.Write([]byte(s))
+		}
+		pw2.Close()
+	}()
+
+	var got []string
+	buf := make([]byte, 2)
+	for {
+		n, err := m.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	sort.Strings(got)
+	if s := strings.Join(got, " "); s != "a1 a2 a3 b1 b2 b3" {
+		t.Errorf("got %q, want both sources in full", s)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Error("close error:", err)
+	}
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Multi Reader must drop a failing source, and continue with the others.
+func TestMultiReaderDrop(t *testing.T) {
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	defer pw2.Close()
+	m := NewMultiReader(9*time.Millisecond, pr1, pr2)
+
+	buf := make([]byte, len(feed))
+	if n, err := m.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	pw1.CloseWithError(errOnClose)
+	if n, err := m.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read after source error = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	go pw2.Write([]byte(feed))
+	if n, err := m.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	if err := m.Close(); err != errOnClose {
+		t.Errorf("got close error %v, want %v", err, errOnClose)
+	}
+	if n, err := m.Read(buf); n != 0 || err != ErrClosed {
+		t.Errorf("Read after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Concat Reader must deliver each source in turn, and close each source
+// as it ends.
+func TestConcatReader(t *testing.T) {
+	first := &closeFlagSource{Reader: strings.NewReader(feed[:6])}
+	pr, pw := io.Pipe()
+	r := NewConcatReader(9*time.Millisecond, first, pr)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 6 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (6, <nil>)", n, err)
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read on idle source = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if atomic.LoadInt32(&first.closed) == 0 {
+		t.Error("first source not closed after its end")
+	}
+
+	go func() {
+		pw.Write([]byte(feed[6:]))
+		pw.Close()
+	}()
+	if n, err := r.Read(buf); string(buf[:n]) != feed[6:] || err != nil {
+		t.Errorf("Read = (%d, %v) %q, want (%d, <nil>) %q", n, err, buf[:n], len(feed)-6, feed[6:])
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after all sources = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if err := r.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+}
+
+// Select must report the first reader with data or with an error.
+func TestSelect(t *testing.T) {
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	defer pw1.Close()
+	r1 := NewReader(pr1, 0)
+	defer r1.Close()
+	r2 := NewReader(pr2, 0)
+	defer r2.Close()
+
+	if i, err := Select(9*time.Millisecond, r1, r2); i != -1 || err != ErrNoData {
+		t.Errorf("idle: Select = (%d, %v), want (-1, %v)", i, err, ErrNoData)
+	}
+
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		pw2.Write([]byte(feed))
+	}()
+	if i, err := Select(time.Second, r1, r2); i != 1 || err != nil {
+		t.Errorf("Select = (%d, %v), want (1, <nil>)", i, err)
+	}
+	// nothing consumed
+	if i, err := Select(0, r1, r2); i != 1 || err != nil {
+		t.Errorf("poll: Select = (%d, %v), want (1, <nil>)", i, err)
+	}
+	buf := make([]byte, len(feed))
+	if n, err := r2.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	pw2.CloseWithError(errTransient)
+	if i, err := Select(-1, r1, r2); i != 1 || err != nil {
+		t.Errorf("source error: Select = (%d, %v), want (1, <nil>)", i, err)
+	}
+	if n, err := r2.Read(buf); n != 0 || err != errTransient {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, errTransient)
+	}
+
+	if i, err := Select(-1); i != -1 || err != io.EOF {
+		t.Errorf("no readers: Select = (%d, %v), want (-1, %v)", i, err, io.EOF)
+	}
+}
@@ -0,0 +1,220 @@
+// Package nbiotest provides sources, sinks and assertions for tests of code
+// on top of package nbio.
+package nbiotest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/nbio"
+)
+
+// Step is one read of a Source.
+type Step struct {
+	Data  string          // payload
+	Err   error           // optional error, returned along with Data
+	Delay time.Duration   // wait before delivery
+	Gate  <-chan struct{} // optional wait before delivery, until closed
+}
+
+// Source is an io.ReadCloser with scripted reads, as returned by NewSource.
+type Source struct {
+	mutex sync.Mutex
+	steps []Step
+	reads int // number of Read calls
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewSource returns a new source which delivers the steps in order. A
+// Read with a buffer too small for the payload gets the remainder on the
+// next Read, without delay. Reads fail with io.EOF after the steps. Close
+// aborts any wait, after which reads fail with io.ErrClosedPipe.
+func NewSource(steps ...Step) *Source {
+	return &Source{steps: steps, closed: make(chan struct{})}
+}
+
+// Read implements the io.Reader interface.
+func (s *Source) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	s.reads++
+	if len(s.steps) == 0 {
+		s.mutex.Unlock()
+		return 0, io.EOF
+	}
+	step := s.steps[0]
+	s.mutex.Unlock()
+
+	if step.Delay > 0 {
+		timer := time.NewTimer(step.Delay)
+		select {
+		case <-timer.C:
+		case <-s.closed:
+			timer.Stop()
+			return 0, io.ErrClosedPipe
+		}
+	}
+	if step.Gate != nil {
+		select {
+		case <-step.Gate:
+		case <-s.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	n := copy(p, step.Data)
+	if n < len(step.Data) {
+		// remainder without wait
+		s.steps[0] = Step{Data: step.Data[n:], Err: step.Err}
+		return n, nil
+	}
+	s.steps = s.steps[1:]
+	return n, step.Err
+}
+
+// Reads returns the number of Read calls so far.
+func (s *Source) Reads() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reads
+}
+
+// Close implements the io.Closer interface.
+func (s *Source) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// ErrSinkClosed signals a write to a closed Sink.
+var ErrSinkClosed = errors.New("write on closed sink")
+
+// Sink is an io.WriteCloser which records its writes, as returned by
+// NewSink.
+type Sink struct {
+	mutex  sync.Mutex
+	writes [][]byte
+	err    error         // fails writes, if any
+	gate   chan struct{} // blocks writes, if any
+	closed bool
+}
+
+// NewSink returns a new sink which accepts all writes, until Block or
+// Fail says otherwise.
+func NewSink() *Sink {
+	return new(Sink)
+}
+
+// Write implements the io.Writer interface.
+func (s *Sink) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	gate := s.gate
+	s.mutex.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case s.closed:
+		return 0, ErrSinkClosed
+	case s.err != nil:
+		return 0, s.err
+	}
+	s.writes = append(s.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// Writes returns a copy of each write so far.
+func (s *Sink) Writes() [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]byte(nil), s.writes...)
+}
+
+// Bytes returns all data written so far.
+func (s *Sink) Bytes() []byte {
+	return bytes.Join(s.Writes(), nil)
+}
+
+// Block makes writes wait until Release.
+func (s *Sink) Block() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.gate == nil {
+		s.gate = make(chan struct{})
+	}
+}
+
+// Release ends the wait of Block, if any.
+func (s *Sink) Release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.gate != nil {
+		close(s.gate)
+		s.gate = nil
+	}
+}
+
+// Fail makes all writes from then on fail with err.
+func (s *Sink) Fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// Close implements the io.Closer interface. Any blocked write is released.
+func (s *Sink) Close() error {
+	s.Release()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+// AssertNoData fails t unless a Read on r gives nbio.ErrNoData without any
+// data.
+func AssertNoData(t testing.TB, r io.Reader) {
+	t.Helper()
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n != 0 || err != nbio.ErrNoData {
+		t.Errorf("Read = (%d, %v) %q, want (0, %v)", n, err, buf[:n], nbio.ErrNoData)
+	}
+}
+
+// AssertRead fails t unless reads on r deliver want, without error, and
+// with nbio.ErrNoData ignored, within timeout.
+func AssertRead(t testing.TB, r io.Reader, want string, timeout time.Duration) {
+	t.Helper()
+	got := make([]byte, 0, len(want))
+	deadline := time.Now().Add(timeout)
+	for len(got) < len(want) {
+		n, err := r.Read(got[len(got):cap(got)])
+		got = got[:len(got)+n]
+		switch {
+		case err == nbio.ErrNoData:
+			if time.Now().After(deadline) {
+				t.Errorf("got %q within %s, want %q", got, timeout, want)
+				return
+			}
+		case err != nil:
+			t.Errorf("got %q with error %v, want %q", got, err, want)
+			return
+		}
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
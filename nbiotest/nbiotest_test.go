@@ -0,0 +1,77 @@
+package nbiotest
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/nbio"
+)
+
+// Source must deliver its steps with their delays and errors.
+func TestSource(t *testing.T) {
+	errStep := errors.New("step error test")
+	gate := make(chan struct{})
+	source := NewSource(
+		Step{Data: "Hello "},
+		Step{Data: "World!", Delay: 20 * time.Millisecond},
+		Step{Data: "gated", Gate: gate},
+		Step{Err: errStep},
+	)
+	r := nbio.NewReader(source, 9*time.Millisecond)
+	defer r.Close()
+
+	AssertRead(t, r, "Hello ", time.Second)
+	AssertNoData(t, r)
+	AssertRead(t, r, "World!", time.Second)
+	AssertNoData(t, r)
+	close(gate)
+	AssertRead(t, r, "gated", time.Second)
+	if n, err := r.Read(make([]byte, 64)); n != 0 || err != errStep {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, errStep)
+	}
+	if got := source.Reads(); got != 4 {
+		t.Errorf("got %d source reads, want 4", got)
+	}
+}
+
+// Source must abort waits on Close.
+func TestSourceClose(t *testing.T) {
+	source := NewSource(Step{Data: "never", Gate: make(chan struct{})})
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		source.Close()
+	}()
+	if n, err := source.Read(make([]byte, 64)); n != 0 || err != io.ErrClosedPipe {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, io.ErrClosedPipe)
+	}
+}
+
+// Sink must record writes, and block and fail on demand.
+func TestSink(t *testing.T) {
+	sink := NewSink()
+	w := nbio.NewWriter(sink, 9*time.Millisecond)
+
+	sink.Block()
+	w.Write([]byte("Hello "))
+	time.Sleep(9 * time.Millisecond)
+	if got := sink.Bytes(); len(got) != 0 {
+		t.Errorf("blocked sink got %q", got)
+	}
+	w.Write([]byte("World!"))
+	sink.Release()
+	if err := w.Flush(); err != nil {
+		t.Fatal("Flush error:", err)
+	}
+	if got := string(sink.Bytes()); got != "Hello World!" {
+		t.Errorf("sink got %q, want %q", got, "Hello World!")
+	}
+
+	errSink := errors.New("sink error test")
+	sink.Fail(errSink)
+	w.Write([]byte("lost"))
+	if err := w.Close(); err != errSink {
+		t.Errorf("Close got error %v, want %v", err, errSink)
+	}
+}
@@ -0,0 +1,176 @@
+package nbio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Option is a Reader setting for NewReaderOptions.
+type Option func(*options)
+
+// Options holds the NewReaderOptions configuration.
+type options struct {
+	timeout   time.Duration
+	bufSize   int
+	bufCount  int
+	shared    *sync.Pool
+	onDemand  bool
+	maxBuf    int
+	lowBuf    int
+	limiter   Limiter
+	routines  *Routines
+	priority  int
+	queue     time.Duration
+	retryMax  int
+	retryWait time.Duration
+	clock     Clock
+}
+
+// WithTimeout sets the maximum amount of time for Read to wait on data,
+// with the same semantics as the timeout of NewReader. The default is
+// zero, which makes Read poll without wait.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithBufferSize sets the capacity of each buffer, with a minimum of 64,
+// like NewReaderSize does. The default is 2048.
+func WithBufferSize(n int) Option {
+	return func(o *options) { o.bufSize = n }
+}
+
+// WithBufferCount sets the number of buffers, with a minimum of 2. The
+// default is 3. One buffer is with Read, and one is with the read routine.
+// The rest can be pending for Read, i.e., more buffers let the read
+// routine get further ahead of a slow consumer, at the cost of memory.
+func WithBufferCount(n int) Option {
+	return func(o *options) { o.bufCount = n }
+}
+
+// WithSharedPool makes the Reader take its buffers from p, and Close
+// returns them to p, except for the one which Read may still hold on to.
+// Many Readers with the same buffer size can share a pool to limit
+// allocation for short-lived connections. The pool holds []byte values.
+// Values of another capacity are ignored, which includes the nil from
+// an empty pool without New function.
+func WithSharedPool(p *sync.Pool) Option {
+	return func(o *options) { o.shared = p }
+}
+
+// WithBuffersOnDemand makes the Reader hold buffers from the WithSharedPool
+// only while data is in flight, i.e., buffers return to the shared pool as
+// soon as reads consume them, and the read routine takes a buffer for each
+// read on source. An idle Reader holds just the one buffer of the pending
+// read on source, which suits large numbers of mostly idle connections.
+// The option has no effect without WithSharedPool.
+func WithBuffersOnDemand() Option {
+	return func(o *options) { o.onDemand = true }
+}
+
+// WithMaxBuffered limits the read-ahead to n bytes, i.e., the read routine
+// pauses once the data read from source, yet not consumed, reaches n, and
+// it resumes as reads consume. Reads from source do not exceed the room
+// left, which makes n below the buffer size limit each read to n bytes.
+// RequestReadahead raises the limit temporarily. The default is no limit
+// other than the buffers.
+func WithMaxBuffered(n int) Option {
+	return func(o *options) { o.maxBuf, o.lowBuf = n, -1 }
+}
+
+// WithWatermarks is like WithMaxBuffered(high), yet once the read-ahead
+// reached high, the read routine resumes only after reads consumed down to
+// low bytes or less. The hysteresis saves a source read for each small
+// consumption, and it lets transports such as TCP apply backpressure in
+// full. A low out of range, i.e., negative or from high, resumes on any
+// consumption, like WithMaxBuffered does.
+func WithWatermarks(high, low int) Option {
+	return func(o *options) { o.maxBuf, o.lowBuf = high, low }
+}
+
+// Limiter paces reads from source, as configured with WithRateLimit. The
+// *rate.Limiter from golang.org/x/time/rate satisfies the interface.
+type Limiter interface {
+	// WaitN blocks until n bytes are permitted, or until ctx is done.
+	WaitN(ctx context.Context, n int) error
+	// Burst returns the maximum for n.
+	Burst() int
+}
+
+// WithRateLimit makes the read routine pace its reads from source with l.
+// Reads from source do not exceed the burst size of l, and the read
+// routine waits for permission to each read's amount, after it passed the
+// data to Read, which limits the read-ahead from a fast source. Close
+// aborts any wait. The default is reads as fast as source delivers.
+func WithRateLimit(l Limiter) Option {
+	return func(o *options) { o.limiter = l }
+}
+
+// WithQueueSampling makes the read routine sample the number of buffers
+// which wait for Read, for the queue fields of Stats. Samples are taken on
+// handoffs, at most once per interval, which costs a clock read on each
+// handoff. A source without data thus gives no samples. The default is no
+// sampling, at no cost.
+func WithQueueSampling(interval time.Duration) Option {
+	return func(o *options) { o.queue = interval }
+}
+
+// WithRoutines makes the read routine wait for a slot of rs before its
+// first read on source, with priority as its claim, i.e., readers with a
+// higher priority get their slot first on contention. See NewRoutines for
+// the fairness. The slot is held until the read routine terminates. Reads
+// which time out on the wait report WaitRoutine with LastWaitReason. Close
+// aborts any wait. The default is a read routine of its own, regardless.
+func WithRoutines(rs *Routines, priority int) Option {
+	return func(o *options) { o.routines, o.priority = rs, priority }
+}
+
+// WithRetry makes the read routine retry reads from source which fail
+// with a temporary error, i.e., a net.Error with Timeout or Temporary,
+// instead of latching the error as sticky. Up to max retries in a row
+// apply, with a negative max for no limit. The wait before each retry
+// starts at backoff, and it doubles with each retry in a row, up to 32
+// times backoff. Data from source resets the count. Close aborts any wait.
+// The default is no retries.
+func WithRetry(max int, backoff time.Duration) Option {
+	return func(o *options) { o.retryMax, o.retryWait = max, backoff }
+}
+
+// WithClock makes the Reader take the time from c, which applies to the
+// timeout of reads, to deadlines, and to heartbeats. Tests may use a fake
+// clock for deterministic timeouts. The default is the clock of package
+// time.
+func WithClock(c Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// NewReaderOptions returns a new Reader like NewReader does, configured
+// by opts in order of appearance.
+func NewReaderOptions(source io.ReadCloser, opts ...Option) *Reader {
+	o := options{bufSize: bufferSize, bufCount: 3}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.bufSize < minBufferSize {
+		o.bufSize = minBufferSize
+	}
+	if o.bufCount < 2 {
+		o.bufCount = 2
+	}
+
+	r := newReaderBuffers(source, o.timeout, o.bufSize, o.bufCount, o.shared)
+	r.limitBuffered(o.maxBuf, o.lowBuf)
+	r.limiter = o.limiter
+	r.routines, r.priority = o.routines, o.priority
+	r.queueEvery = o.queue
+	r.retryMax, r.retryWait = o.retryMax, o.retryWait
+	if o.clock != nil {
+		r.clock = o.clock
+	}
+	if o.onDemand {
+		r.buffersOnDemand()
+	}
+	r.Start()
+	return r
+}
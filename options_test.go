@@ -0,0 +1,368 @@
+package nbio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must deliver large payloads with any buffer count.
+func TestReaderOptionsBufferCount(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+
+	for _, count := range []int{2, 5} {
+		r := NewReaderOptions(errCloser{bytes.NewReader(payload)},
+			WithTimeout(time.Second),
+			WithBufferSize(4096),
+			WithBufferCount(count))
+
+		if got := cap(r.pool); got != count {
+			t.Errorf("%d buffers: pool capacity %d", count, got)
+		}
+		if got, want := cap(r.next), count-2; got != want {
+			t.Errorf("%d buffers: next capacity %d, want %d", count, got, want)
+		}
+
+		var got bytes.Buffer
+		buf := make([]byte, 1000)
+		for {
+			n, err := r.Read(buf)
+			got.Write(buf[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%d buffers: Read error: %v", count, err)
+			}
+		}
+		if !bytes.Equal(got.Bytes(), payload) {
+			t.Errorf("%d buffers: got %d bytes, want the %d bytes of payload", count, got.Len(), len(payload))
+		}
+		r.Close()
+	}
+}
+
+// Non blocking Reader must return its buffers to the shared pool on Close.
+func TestReaderOptionsSharedPool(t *testing.T) {
+	var allocs int
+	shared := &sync.Pool{New: func() interface{} {
+		allocs++
+		return make([]byte, 128)
+	}}
+
+	r := NewReaderOptions(errCloser{bytes.NewReader([]byte(feed))},
+		WithTimeout(time.Second),
+		WithBufferSize(128),
+		WithSharedPool(shared))
+	if allocs != 3 {
+		t.Errorf("got %d allocations from the pool, want 3", allocs)
+	}
+	if got := r.BufferSize(); got != 128 {
+		t.Errorf("BufferSize = %d, want 128", got)
+	}
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	r.Close()
+
+	// pool may drop values any time, which makes New fill in
+	for i := 0; i < 3; i++ {
+		if buf := shared.Get().([]byte); cap(buf) != 128 {
+			t.Errorf("got buffer capacity %d from pool, want 128", cap(buf))
+		}
+	}
+}
+
+// Non blocking Reader must stop reading ahead at the limit.
+func TestReaderOptionsMaxBuffered(t *testing.T) {
+	source := &countSource{ReadCloser: ioutil.NopCloser(zeroReader{})}
+	r := NewReaderOptions(source,
+		WithTimeout(time.Second),
+		WithBufferSize(64),
+		WithMaxBuffered(100))
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Buffered(); got != 100 {
+		t.Errorf("got %d bytes buffered, want 100", got)
+	}
+	reads := atomic.LoadInt32(&source.reads)
+	time.Sleep(9 * time.Millisecond)
+	if got := atomic.LoadInt32(&source.reads); got != reads {
+		t.Errorf("source reads advanced from %d to %d at the limit", reads, got)
+	}
+
+	buf := make([]byte, 50)
+	if n, err := r.Read(buf); n != 50 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (50, <nil>)", n, err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	if got := atomic.LoadInt32(&source.reads); got <= reads {
+		t.Errorf("source reads stuck at %d after consumption", got)
+	}
+	if got := r.Buffered(); got != 100 {
+		t.Errorf("got %d bytes buffered after consumption, want 100", got)
+	}
+	if got := r.HighWaterMark(); got != 100 {
+		t.Errorf("got high water mark %d, want 100", got)
+	}
+}
+
+// Non blocking Reader must read ahead more on request, until consumed.
+func TestReaderOptionsRequestReadahead(t *testing.T) {
+	r := NewReaderOptions(ioutil.NopCloser(zeroReader{}),
+		WithTimeout(time.Second),
+		WithBufferSize(64),
+		WithBufferCount(5),
+		WithMaxBuffered(100))
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if configured, effective := r.Depth(); configured != 5 || effective != 2 {
+		t.Errorf("got depth (%d, %d), want (5, 2)", configured, effective)
+	}
+	r.RequestReadahead(50)
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Buffered(); got != 150 {
+		t.Errorf("got %d bytes buffered on request, want 150", got)
+	}
+	if configured, effective := r.Depth(); configured != 5 || effective != 3 {
+		t.Errorf("got depth (%d, %d) on request, want (5, 3)", configured, effective)
+	}
+
+	// consumption decays the raise
+	if _, err := io.ReadFull(r, make([]byte, 60)); err != nil {
+		t.Fatal("read error:", err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Buffered(); got != 100 {
+		t.Errorf("got %d bytes buffered after consumption, want 100", got)
+	}
+	if configured, effective := r.Depth(); configured != 5 || effective != 2 {
+		t.Errorf("got depth (%d, %d) after consumption, want (5, 2)", configured, effective)
+	}
+
+	// no byte limit
+	r = NewReaderOptions(ioutil.NopCloser(zeroReader{}))
+	defer r.Close()
+	if configured, effective := r.Depth(); configured != 3 || effective != 3 {
+		t.Errorf("got depth (%d, %d) without limit, want (3, 3)", configured, effective)
+	}
+}
+
+// Non blocking Reader must hold buffers only while data is in flight.
+func TestReaderOptionsBuffersOnDemand(t *testing.T) {
+	shared := &sync.Pool{New: func() interface{} {
+		return make([]byte, 128)
+	}}
+	// count buffers with memory in the pool
+	held := func(r *Reader) int {
+		var n int
+		for i := len(r.pool); i > 0; i-- {
+			buf := <-r.pool
+			if cap(buf) != 0 {
+				n++
+			}
+			r.pool <- buf
+		}
+		return n
+	}
+
+	pr, pw := io.Pipe()
+	r := NewReaderOptions(pr,
+		WithTimeout(time.Second),
+		WithBufferSize(128),
+		WithSharedPool(shared),
+		WithBuffersOnDemand())
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if n := held(r); n != 0 || cap(r.buf) != 0 {
+		t.Errorf("idle: got %d buffers in pool and current capacity %d, want none", n, cap(r.buf))
+	}
+
+	payload := make([]byte, 100*128)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	go func() {
+		pw.Write(payload)
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal("read error:", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("payload mismatch")
+	}
+
+	time.Sleep(9 * time.Millisecond)
+	if n := held(r); n != 0 || cap(r.buf) != 0 {
+		t.Errorf("consumed: got %d buffers in pool and current capacity %d, want none", n, cap(r.buf))
+	}
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil || string(buf) != feed {
+		t.Errorf("Read = (%d, %v) %q, want (%d, <nil>) %q", n, err, buf[:n], len(feed), feed)
+	}
+}
+
+// BudgetLimiter permits budget bytes in bursts of 100, and then it blocks.
+type budgetLimiter struct {
+	budget int64
+}
+
+func (l *budgetLimiter) Burst() int { return 100 }
+
+func (l *budgetLimiter) WaitN(ctx context.Context, n int) error {
+	if atomic.AddInt64(&l.budget, -int64(n)) >= 0 {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Non blocking Reader must pace reads from source with the limiter.
+func TestReaderOptionsRateLimit(t *testing.T) {
+	source := &maxReadSource{ReadCloser: ioutil.NopCloser(zeroReader{})}
+	r := NewReaderOptions(source,
+		WithTimeout(time.Second),
+		WithBufferCount(10),
+		WithRateLimit(&budgetLimiter{budget: 300}))
+
+	time.Sleep(9 * time.Millisecond)
+	// budget plus the read which exceeds it
+	if got := r.Buffered(); got != 400 {
+		t.Errorf("got %d bytes buffered, want 400", got)
+	}
+	if err := r.CloseWithTimeout(time.Second); err != nil {
+		t.Error("Close error:", err)
+	}
+	if source.max > 100 {
+		t.Errorf("source read of %d bytes exceeds the burst", source.max)
+	}
+
+	r.Reset(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+	if r.limiter == nil {
+		t.Error("rate limit lost on Reset")
+	}
+}
+
+// Non blocking Reader must resume reading ahead at the low watermark only.
+func TestReaderOptionsWatermarks(t *testing.T) {
+	source := &countSource{ReadCloser: ioutil.NopCloser(zeroReader{})}
+	r := NewReaderOptions(source,
+		WithTimeout(time.Second),
+		WithBufferSize(64),
+		WithBufferCount(10),
+		WithWatermarks(100, 40))
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Buffered(); got != 100 {
+		t.Errorf("got %d bytes buffered, want 100", got)
+	}
+	reads := atomic.LoadInt32(&source.reads)
+
+	buf := make([]byte, 50)
+	if n, err := r.Read(buf); n != 50 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (50, <nil>)", n, err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	if got := atomic.LoadInt32(&source.reads); got != reads {
+		t.Errorf("source reads advanced from %d to %d above the low watermark", reads, got)
+	}
+
+	if n, err := r.Read(buf[:10]); n != 10 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (10, <nil>)", n, err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	if got := atomic.LoadInt32(&source.reads); got <= reads {
+		t.Errorf("source reads stuck at %d at the low watermark", got)
+	}
+	if got := r.Buffered(); got != 100 {
+		t.Errorf("got %d bytes buffered after resume, want 100", got)
+	}
+}
+
+// TempErr is a net.Error with Temporary.
+type tempErr struct{}
+
+func (tempErr) Error() string   { return "temporary error test" }
+func (tempErr) Timeout() bool   { return false }
+func (tempErr) Temporary() bool { return true }
+
+// FlakySource fails the first reads with tempErr, as many as fails.
+type flakySource struct {
+	fails int
+	io.ReadCloser
+}
+
+func (s *flakySource) Read(p []byte) (int, error) {
+	if s.fails > 0 {
+		s.fails--
+		return 0, tempErr{}
+	}
+	return s.ReadCloser.Read(p)
+}
+
+// Non blocking Reader must retry temporary source errors within the limit.
+func TestReaderOptionsRetry(t *testing.T) {
+	source := &flakySource{fails: 3, ReadCloser: ioutil.NopCloser(strings.NewReader(feed))}
+	r := NewReaderOptions(source,
+		WithTimeout(time.Second),
+		WithRetry(3, time.Millisecond))
+	defer r.Close()
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	source = &flakySource{fails: 3, ReadCloser: ioutil.NopCloser(strings.NewReader(feed))}
+	r2 := NewReaderOptions(source,
+		WithTimeout(time.Second),
+		WithRetry(2, time.Millisecond))
+	defer r2.Close()
+	if n, err := r2.Read(buf); n != 0 || err != (tempErr{}) {
+		t.Errorf("Read past retry limit = (%d, %v), want (0, %v)", n, err, tempErr{})
+	}
+}
+
+// Non blocking Reader must not retry the interrupt of ExportState.
+func TestReaderOptionsRetryExportState(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	r := NewReaderOptions(conn,
+		WithTimeout(time.Second),
+		WithRetry(-1, time.Millisecond))
+	defer r.Close()
+
+	go peer.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		state, err := r.ExportState()
+		if err != nil || string(state) != feed {
+			t.Errorf("ExportState = (%q, %v), want (%q, <nil>)", state, err, feed)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ExportState did not return with WithRetry")
+	}
+}
@@ -0,0 +1,239 @@
+package nbio
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxDatagramSize covers any UDP payload.
+const maxDatagramSize = 1<<16 - 1
+
+// PacketConn is a net.PacketConn with non blocking reads and writes, as
+// returned by NewPacketConn. Addressing passes through to the connection
+// as is.
+type PacketConn struct {
+	isClosed int32 // Close flag
+
+	net.PacketConn // connection for all but I/O
+
+	timeout time.Duration
+	timer   *time.Timer // lazy init, reusable, disarmed between reads
+
+	next   chan datagram // received, in order of arrival
+	pool   chan []byte   // buffer recycling
+	closed chan struct{} // signals Close
+
+	failed atomic.Value // errorBox with sticky error of the connection
+
+	readDeadline  atomic.Value // time.Time from SetReadDeadline
+	writeDeadline atomic.Value // time.Time from SetWriteDeadline
+
+	closeOnce sync.Once
+	closeErr  error // Close result
+}
+
+// Datagram is a message with its origin.
+type datagram struct {
+	buf  []byte
+	addr net.Addr
+}
+
+// NewPacketConn returns a new wrapper whose ReadFrom function gives a time
+// out (with ErrNoData) when no datagram arrives within timeout, like a
+// Reader from NewReader does, yet each read returns one datagram exactly,
+// with its source address. Datagrams which exceed the buffer of a read
+// are truncated, like a net.PacketConn does. Writes wait at most timeout
+// too, with ErrWriteBufferFull. A timeout of zero polls, while a negative
+// timeout waits without limit.
+//
+// Errors of the connection are sticky, like they are on a Reader. Reads
+// must not be concurrent with each other, and neither may writes.
+func NewPacketConn(conn net.PacketConn, timeout time.Duration) *PacketConn {
+	c := &PacketConn{
+		PacketConn: conn,
+		timeout:    timeout,
+		next:       make(chan datagram, 3),
+		pool:       make(chan []byte, 3),
+		closed:     make(chan struct{}),
+	}
+	c.readDeadline.Store(time.Time{})
+	c.writeDeadline.Store(time.Time{})
+
+	// 3 read buffers cycle through next and pool
+	mem := make([]byte, 3*maxDatagramSize)
+	for i := 0; i < 3; i++ {
+		c.pool <- mem[i*maxDatagramSize : (i+1)*maxDatagramSize : (i+1)*maxDatagramSize]
+	}
+	go c.readRoutine()
+	return c
+}
+
+// readRoutine passes each datagram to next until the connection fails.
+func (c *PacketConn) readRoutine() {
+	defer close(c.next)
+
+	for {
+		var buf []byte
+		select {
+		case buf = <-c.pool:
+		case <-c.closed:
+			c.failed.Store(errorBox{ErrClosed})
+			return
+		}
+
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err == nil {
+			// empty datagrams are valid
+			c.next <- datagram{buf[:n], addr}
+			continue
+		}
+		if n != 0 {
+			c.next <- datagram{buf[:n], addr}
+		} else {
+			c.pool <- buf
+		}
+		c.failed.Store(errorBox{err})
+		return
+	}
+}
+
+// ReadFrom implements the net.PacketConn interface. A read deadline in
+// effect applies on top of the timeout, with os.ErrDeadlineExceeded, like
+// a net.PacketConn does, and the PacketConn remains usable.
+func (c *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	if atomic.LoadInt32(&c.isClosed) != 0 {
+		return 0, nil, ErrClosed
+	}
+
+	wait, timeoutErr := c.timeout, ErrNoData
+	if deadline := c.readDeadline.Load().(time.Time); !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		if wait < 0 || d < wait {
+			wait, timeoutErr = d, os.ErrDeadlineExceeded
+		}
+	}
+
+	var d datagram
+	var ok bool
+	select {
+	case d, ok = <-c.next:
+	default:
+		if wait == 0 {
+			return 0, nil, timeoutErr
+		}
+		var expire <-chan time.Time // nil blocks
+		if wait > 0 {
+			expire = c.resetTimer(wait)
+		}
+		select {
+		case d, ok = <-c.next:
+			if expire != nil {
+				c.stopTimer()
+			}
+		case <-expire:
+			return 0, nil, timeoutErr
+		}
+	}
+	if !ok {
+		box, _ := c.failed.Load().(errorBox)
+		return 0, nil, box.err
+	}
+
+	n = copy(p, d.buf)
+	c.pool <- d.buf[:cap(d.buf)]
+	return n, d.addr, nil
+}
+
+// WriteTo implements the net.PacketConn interface. A write which does not
+// complete within the timeout fails with ErrWriteBufferFull. A write
+// deadline in effect applies on top of the timeout, with
+// os.ErrDeadlineExceeded.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if atomic.LoadInt32(&c.isClosed) != 0 {
+		return 0, ErrClosed
+	}
+
+	timeoutErr := ErrWriteBufferFull
+	var limit time.Time
+	if c.timeout >= 0 {
+		limit = time.Now().Add(c.timeout)
+	}
+	if deadline := c.writeDeadline.Load().(time.Time); !deadline.IsZero() && (limit.IsZero() || deadline.Before(limit)) {
+		limit, timeoutErr = deadline, os.ErrDeadlineExceeded
+	}
+	if err := c.PacketConn.SetWriteDeadline(limit); err != nil {
+		return 0, err
+	}
+
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		err = timeoutErr
+	}
+	return n, err
+}
+
+// resetTimer arms the timer for d, regardless of its prior state.
+func (c *PacketConn) resetTimer(d time.Duration) <-chan time.Time {
+	if c.timer == nil {
+		c.timer = time.NewTimer(d)
+	} else {
+		c.stopTimer()
+		c.timer.Reset(d)
+	}
+	return c.timer.C
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (c *PacketConn) stopTimer() {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+			// received already
+		}
+	}
+}
+
+// Close implements the net.PacketConn interface. Any read or write
+// afterwards fails with ErrClosed. The read routine terminates before
+// Close returns.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.isClosed, 1)
+		close(c.closed)
+		c.closeErr = c.PacketConn.Close()
+
+		// flush to kill Go routine
+		for d := range c.next {
+			c.pool <- d.buf[:cap(d.buf)]
+		}
+	})
+	return c.closeErr
+}
+
+// SetDeadline implements the net.PacketConn interface. Neither deadline
+// reaches the connection as is, as the read routine must not fail on it.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements the net.PacketConn interface. The zero value
+// restores reads with just the timeout.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(t)
+	return nil
+}
+
+// SetWriteDeadline implements the net.PacketConn interface. The zero value
+// restores writes with just the timeout.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return nil
+}
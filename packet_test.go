@@ -0,0 +1,80 @@
+package nbio
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Non blocking PacketConn must deliver datagrams one by one, with their
+// source address.
+func TestPacketConn(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no UDP on loopback:", err)
+	}
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	c := NewPacketConn(conn, 9*time.Millisecond)
+	defer c.Close()
+	var _ net.PacketConn = c
+
+	buf := make([]byte, 64)
+	if n, addr, err := c.ReadFrom(buf); n != 0 || err != ErrNoData {
+		t.Fatalf("ReadFrom = (%d, %v, %v), want (0, <nil>, %v)", n, addr, err, ErrNoData)
+	}
+
+	for _, s := range []string{"Hello ", "World!", ""} {
+		if _, err := peer.WriteTo([]byte(s), c.LocalAddr()); err != nil {
+			t.Fatal("peer write error:", err)
+		}
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []string{"Hello ", "World!", ""} {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			t.Fatal("ReadFrom error:", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("got datagram %q, want %q", got, want)
+		}
+		if addr.String() != peer.LocalAddr().String() {
+			t.Errorf("got address %v, want %v", addr, peer.LocalAddr())
+		}
+	}
+
+	// truncation
+	peer.WriteTo([]byte(feed), c.LocalAddr())
+	peer.WriteTo([]byte("next"), c.LocalAddr())
+	if n, _, err := c.ReadFrom(buf[:5]); n != 5 || err != nil || string(buf[:n]) != "Hello" {
+		t.Errorf("short ReadFrom = (%d, %v) %q, want (5, <nil>) \"Hello\"", n, err, buf[:n])
+	}
+	if n, _, err := c.ReadFrom(buf); n != 4 || err != nil || string(buf[:n]) != "next" {
+		t.Errorf("ReadFrom after truncation = (%d, %v) %q, want (4, <nil>) \"next\"", n, err, buf[:n])
+	}
+
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	if n, _, err := c.ReadFrom(buf); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrom after deadline = (%d, %v), want (0, %v)", n, err, os.ErrDeadlineExceeded)
+	}
+
+	if n, err := c.WriteTo([]byte(feed), peer.LocalAddr()); n != len(feed) || err != nil {
+		t.Errorf("WriteTo = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := peer.ReadFrom(buf); n != len(feed) || err != nil {
+		t.Errorf("peer read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+	if n, _, err := c.ReadFrom(buf); n != 0 || err != ErrClosed {
+		t.Errorf("ReadFrom after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+}
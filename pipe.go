@@ -0,0 +1,243 @@
+package nbio
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Pipe creates a synchronous in-memory pipe with non blocking semantics,
+// as an alternative to io.Pipe. Reads give a time out (with ErrNoData)
+// when no data arrives within timeout, and writes give a time out (with
+// ErrWriteBufferFull) when the buffer did not free up in time. A timeout of
+// zero makes reads and writes wait for nothing, while a negative timeout
+// makes them wait without limit. The buffer holds bufSize bytes, with a
+// minimum of 64.
+func Pipe(timeout time.Duration, bufSize int) (*PipeReader, *PipeWriter) {
+	if bufSize < minBufferSize {
+		bufSize = minBufferSize
+	}
+	p := &pipe{
+		buf:      make([]byte, bufSize),
+		timeout:  timeout,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+	return &PipeReader{pipeEnd{p: p}}, &PipeWriter{pipeEnd{p: p}}
+}
+
+// Pipe is the state shared by both ends.
+type pipe struct {
+	mu  sync.Mutex // guards all but the channels
+	buf []byte     // ring
+	off int        // read position in buf
+	n   int        // number of bytes pending from off
+
+	rerr error // reader end closed, if set
+	werr error // writer end closed, if set
+
+	readable chan struct{} // signals data or werr
+	writable chan struct{} // signals room or rerr
+
+	// maximum amount of time to wait on the other end
+	timeout time.Duration
+}
+
+// Take moves data from the ring into p.
+func (pipe *pipe) take(p []byte) int {
+	end := pipe.off + pipe.n
+	if end > len(pipe.buf) {
+		end = len(pipe.buf)
+	}
+	n := copy(p, pipe.buf[pipe.off:end])
+	if n < len(p) {
+		// wrap around
+		n += copy(p[n:], pipe.buf[:pipe.n-n])
+	}
+	pipe.off = (pipe.off + n) % len(pipe.buf)
+	pipe.n -= n
+	return n
+}
+
+// Put moves data from p into the ring, as far as room permits.
+func (pipe *pipe) put(p []byte) int {
+	start := (pipe.off + pipe.n) % len(pipe.buf)
+	end := start + len(pipe.buf) - pipe.n
+	if end > len(pipe.buf) {
+		end = len(pipe.buf)
+	}
+	n := copy(pipe.buf[start:end], p)
+	if n < len(p) && pipe.n+n < len(pipe.buf) {
+		// wrap around
+		n += copy(pipe.buf[:pipe.off], p[n:])
+	}
+	pipe.n += n
+	return n
+}
+
+// Signal does a non blocking send on ch.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+		// pending already
+	}
+}
+
+// PipeEnd is one half of a pipe.
+type pipeEnd struct {
+	p     *pipe
+	timer *time.Timer // lazy init, reusable
+}
+
+// wait blocks until sig, or until expiry. The return is false on expiry.
+func (e *pipeEnd) wait(sig <-chan struct{}, expire *<-chan time.Time) bool {
+	if e.p.timeout == 0 {
+		return false
+	}
+	if *expire == nil && e.p.timeout > 0 {
+		*expire = e.resetTimer()
+	}
+	select {
+	case <-sig:
+		return true
+	case <-*expire:
+		return false
+	}
+}
+
+// resetTimer arms the timer for the timeout, regardless of its prior
+// state.
+func (e *pipeEnd) resetTimer() <-chan time.Time {
+	if e.timer == nil {
+		e.timer = time.NewTimer(e.p.timeout)
+	} else {
+		e.stopTimer()
+		e.timer.Reset(e.p.timeout)
+	}
+	return e.timer.C
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (e *pipeEnd) stopTimer() {
+	if e.timer != nil && !e.timer.Stop() {
+		select {
+		case <-e.timer.C:
+		default:
+			// received already
+		}
+	}
+}
+
+// PipeReader is the read half of a Pipe.
+type PipeReader struct{ pipeEnd }
+
+// Read implements the io.Reader interface. Reads get the data pending, up
+// to len(p), without waiting for more. Once the writer end is closed, and
+// the data pending is consumed, reads fail with io.EOF, or with the error
+// passed to CloseWithError. Read must not be called concurrently with
+// itself.
+func (r *PipeReader) Read(p []byte) (int, error) {
+	pipe := r.p
+	var expire <-chan time.Time // lazy init; nil blocks
+	for {
+		pipe.mu.Lock()
+		if pipe.rerr != nil {
+			pipe.mu.Unlock()
+			r.stopTimer()
+			return 0, ErrClosed
+		}
+		if pipe.n != 0 {
+			n := pipe.take(p)
+			pipe.mu.Unlock()
+			signal(pipe.writable)
+			r.stopTimer()
+			return n, nil
+		}
+		if err := pipe.werr; err != nil {
+			pipe.mu.Unlock()
+			r.stopTimer()
+			return 0, err
+		}
+		pipe.mu.Unlock()
+
+		if !r.wait(pipe.readable, &expire) {
+			return 0, ErrNoData
+		}
+	}
+}
+
+// Close closes the reader end. Writes fail with io.ErrClosedPipe from then
+// on, and so do reads with ErrClosed.
+func (r *PipeReader) Close() error {
+	r.p.mu.Lock()
+	r.p.rerr = ErrClosed
+	r.p.mu.Unlock()
+	signal(r.p.readable)
+	signal(r.p.writable)
+	return nil
+}
+
+// PipeWriter is the write half of a Pipe.
+type PipeWriter struct{ pipeEnd }
+
+// Write implements the io.Writer interface. The return counts the bytes
+// accepted for the reader end. A Write which could not pass all of p
+// within the timeout fails with ErrWriteBufferFull, in which case the
+// remainder is not written at all. Write must not be called concurrently
+// with itself.
+func (w *PipeWriter) Write(p []byte) (n int, err error) {
+	pipe := w.p
+	var expire <-chan time.Time // lazy init; nil blocks
+	for {
+		pipe.mu.Lock()
+		if pipe.werr != nil {
+			pipe.mu.Unlock()
+			w.stopTimer()
+			return n, ErrWriterClosed
+		}
+		if pipe.rerr != nil {
+			pipe.mu.Unlock()
+			w.stopTimer()
+			return n, io.ErrClosedPipe
+		}
+		did := pipe.put(p)
+		pipe.mu.Unlock()
+		if did != 0 {
+			signal(pipe.readable)
+			n += did
+			p = p[did:]
+		}
+		if len(p) == 0 {
+			w.stopTimer()
+			return n, nil
+		}
+
+		if !w.wait(pipe.writable, &expire) {
+			return n, ErrWriteBufferFull
+		}
+	}
+}
+
+// Close closes the writer end. Reads get the data pending, followed by
+// io.EOF.
+func (w *PipeWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError closes the writer end. Reads get the data pending,
+// followed by err, or by io.EOF when err is nil. Writes fail with
+// ErrWriterClosed from then on.
+func (w *PipeWriter) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	w.p.mu.Lock()
+	if w.p.werr == nil {
+		w.p.werr = err
+	}
+	w.p.mu.Unlock()
+	signal(w.p.readable)
+	signal(w.p.writable)
+	return nil
+}
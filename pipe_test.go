@@ -0,0 +1,105 @@
+package nbio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// Non blocking Pipe must time out on both ends.
+func TestPipe(t *testing.T) {
+	r, w := Pipe(9*time.Millisecond, 64)
+
+	buf := make([]byte, 100)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("idle: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	if n, err := w.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Fatalf("Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	} else if got := string(buf[:n]); got != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+
+	if n, err := w.Write(buf); n != 64 || err != ErrWriteBufferFull {
+		t.Errorf("Write on full buffer = (%d, %v), want (64, %v)", n, err, ErrWriteBufferFull)
+	}
+
+	w.Close()
+	if n, err := r.Read(buf); n != 64 || err != nil {
+		t.Errorf("Read after writer close = (%d, %v), want (64, <nil>)", n, err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read %d at end = (%d, %v), want (0, %v)", i, n, err, io.EOF)
+		}
+	}
+	if n, err := w.Write(buf); n != 0 || err != ErrWriterClosed {
+		t.Errorf("Write after Close = (%d, %v), want (0, %v)", n, err, ErrWriterClosed)
+	}
+}
+
+// Non blocking Pipe must fail writes once the reader end is closed.
+func TestPipeReaderClose(t *testing.T) {
+	r, w := Pipe(-1, 64)
+
+	done := make(chan error)
+	go func() {
+		// exceeds the buffer
+		_, err := w.Write(make([]byte, 100))
+		done <- err
+	}()
+	time.Sleep(9 * time.Millisecond)
+	r.Close()
+
+	if err := <-done; err != io.ErrClosedPipe {
+		t.Errorf("pending Write got error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != ErrClosed {
+		t.Errorf("Read after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+}
+
+// Non blocking Pipe must pass data in order while the ring wraps around.
+func TestPipeStream(t *testing.T) {
+	r, w := Pipe(time.Second, 100)
+
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	go func() {
+		for p := payload; len(p) != 0; {
+			n := 77
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			p = p[n:]
+		}
+		w.Close()
+	}()
+
+	var got bytes.Buffer
+	buf := make([]byte, 33)
+	for {
+		n, err := r.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", got.Len(), len(payload))
+	}
+}
@@ -0,0 +1,91 @@
+package nbio
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// PollReader is a non blocking reader without read routine, as returned
+// by NewPollReader.
+type PollReader struct {
+	r *Reader
+
+	err error // sticky error of Poll
+
+	// Poller hooks, if any
+	starved    int32  // Poll failed with ErrBufferFull
+	rearm      func() // resumes polls after starvation
+	unregister func() // ends polls on Close
+}
+
+// NewPollReader returns a new non blocking wrapper which reads from
+// source only on Poll. The caller is responsible for calling Poll each
+// time source is readable, e.g., as reported by epoll or kqueue. Source
+// must not block, as is common for descriptors in non blocking mode.
+// Read gives a time out (with ErrNoData) when no Poll delivers in time.
+// Otherwise, Read has the same semantics as with NewReader.
+func NewPollReader(source io.ReadCloser, timeout time.Duration) *PollReader {
+	r := newReader(source, timeout)
+	// all buffers can be pending, as reads recycle the current one
+	r.next = make(chan []byte, cap(r.pool))
+	r.startOnce.Do(func() {
+		// Poll reads instead
+	})
+	return &PollReader{r: r}
+}
+
+// Poll does one read on the source, and it returns the number of bytes
+// read. Poll fails with ErrBufferFull when all buffers are pending, in
+// which case no read is done. Read frees buffers as it consumes them.
+// Errors from source are sticky, just like they are for Read. Poll must
+// not be called concurrently with itself.
+func (p *PollReader) Poll() (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	var buf []byte
+	select {
+	case buf = <-p.r.pool:
+		buf = buf[:cap(buf)]
+	default:
+		return 0, ErrBufferFull
+	}
+
+	n, err := p.r.r.Read(buf)
+	if n != 0 {
+		p.r.queued(n)
+		atomic.AddUint64(&p.r.stats.handoffs, 1)
+		p.r.next <- buf[:n]
+	} else {
+		p.r.pool <- buf
+	}
+	if err != nil {
+		p.err = err
+		p.r.failed.Store(errorBox{err})
+		p.r.err <- err
+		close(p.r.next)
+	}
+	return n, err
+}
+
+// Read implements the io.Reader interface. Any goroutine may call Poll
+// in the mean time.
+func (p *PollReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if p.rearm != nil && atomic.CompareAndSwapInt32(&p.starved, 1, 0) {
+		p.rearm()
+	}
+	return n, err
+}
+
+// Close closes the source. The buffers are released with the PollReader.
+// Any read afterwards fails with ErrClosed.
+func (p *PollReader) Close() error {
+	atomic.StoreInt32(&p.r.isClosed, 1)
+	if p.unregister != nil {
+		p.unregister()
+	}
+	return p.r.r.Close()
+}
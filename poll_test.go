@@ -0,0 +1,106 @@
+package nbio
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Poll Reader must deliver data from Poll only.
+func TestPollRead(t *testing.T) {
+	r := NewPollReader(errCloser{strings.NewReader(feed)}, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("no poll: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	if n, err := r.Poll(); n != len(feed) || err != nil {
+		t.Fatalf("Poll = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("polled: Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	} else if got := string(buf); got != feed {
+		t.Errorf("polled: got %q, want %q", got, feed)
+	}
+
+	if n, err := r.Poll(); n != 0 || err != io.EOF {
+		t.Errorf("Poll at end = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if n, err := r.Poll(); n != 0 || err != io.EOF {
+		t.Errorf("Poll after end = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+}
+
+// Poll Reader must refuse reading without free buffer.
+func TestPollBufferFull(t *testing.T) {
+	r := NewPollReader(errCloser{strings.NewReader(strings.Repeat(feed, 1000))}, 9*time.Millisecond)
+	defer r.Close()
+
+	var polled int
+	for {
+		n, err := r.Poll()
+		if err == ErrBufferFull {
+			break
+		}
+		if err != nil {
+			t.Fatal("Poll error:", err)
+		}
+		polled += n
+	}
+	if polled == 0 {
+		t.Fatal("no data polled before ErrBufferFull")
+	}
+
+	buf := make([]byte, polled)
+	var n int
+	for n < polled {
+		did, err := r.Read(buf[n:])
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+		n += did
+	}
+	if got, want := string(buf), strings.Repeat(feed, 1000)[:polled]; got != want {
+		t.Errorf("got %d bytes different from feed", len(got))
+	}
+
+	if _, err := r.Poll(); err != nil {
+		t.Errorf("Poll after Read got error %v", err)
+	}
+}
+
+// Poll Reader must not block on Poll once Read recycled the current buffer.
+func TestPollAfterRecycle(t *testing.T) {
+	r := NewPollReader(errCloser{strings.NewReader(strings.Repeat(feed, 1000))}, 9*time.Millisecond)
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.Poll(); err != nil {
+			t.Error("Poll error:", err)
+			return
+		}
+		buf := make([]byte, len(feed)*1000)
+		if _, err := r.Read(buf); err != nil {
+			t.Error("Read error:", err)
+			return
+		}
+		for i := 0; i < 4; i++ {
+			if _, err := r.Poll(); err != nil && err != ErrBufferFull {
+				t.Errorf("Poll %d error: %v", i, err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Poll blocked after Read recycled all buffers")
+	}
+}
@@ -0,0 +1,167 @@
+package nbio
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// PollSource is a source with access to its file descriptor, such as
+// net.TCPConn, net.UnixConn, or os.File.
+type PollSource interface {
+	io.ReadCloser
+	syscall.Conn
+}
+
+// Poller multiplexes the readiness of many sources on one event loop,
+// with epoll on Linux, and with kqueue on BSD and macOS. Each source
+// registered gets a PollReader, which the event loop polls whenever the
+// source is readable. Thus sources do not need a read routine each.
+type Poller struct {
+	q eventQueue // readiness notification
+
+	mu     sync.Mutex          // guards regs
+	regs   map[int]*PollReader // registrations per file descriptor
+	closed int32               // Close flag
+	done   chan struct{}       // signals event loop termination
+}
+
+// EventQueue is the interface to the readiness notification of the OS.
+// Registrations are for one event each, i.e., file descriptors need a
+// rearm after each event.
+type eventQueue interface {
+	add(fd int) error
+	rearm(fd int) error
+	remove(fd int) error
+	// wait blocks until readiness, or until wake, and it returns the
+	// file descriptors ready, if any
+	wait(ready []int) (n int, err error)
+	wake() error
+	close() error
+}
+
+// NewPoller returns a new Poller with its event loop running. Platforms
+// without epoll or kqueue fail with ErrUnsupported. This includes Windows,
+// as its I/O completion ports report completed reads, not readiness. Use
+// NewDeadlineReader there for reads without routine.
+func NewPoller() (*Poller, error) {
+	q, err := openEventQueue()
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{
+		q:    q,
+		regs: make(map[int]*PollReader),
+		done: make(chan struct{}),
+	}
+	go p.eventLoop()
+	return p, nil
+}
+
+// Register returns a new non blocking wrapper for source, with the same
+// Read semantics as a PollReader, and with the Poller calling Poll. The
+// file descriptor of source must be in non blocking mode, which is the
+// default for network connections and for pipes from os.Pipe. The Reader
+// reads the file descriptor directly, i.e., the read methods of source
+// are not used. Close on the Reader ends the registration, and it closes
+// source.
+func (p *Poller) Register(source PollSource, timeout time.Duration) (*PollReader, error) {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return nil, ErrClosed
+	}
+	raw, err := source.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	if err := raw.Control(func(u uintptr) { fd = int(u) }); err != nil {
+		return nil, err
+	}
+
+	r := NewPollReader(&rawSource{source, raw}, timeout)
+	r.rearm = func() { p.rearm(fd, r) }
+	r.unregister = func() { p.unregister(fd, r) }
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.q.add(fd); err != nil {
+		return nil, err
+	}
+	p.regs[fd] = r
+	return r, nil
+}
+
+// EventLoop polls file descriptors as they become readable.
+func (p *Poller) eventLoop() {
+	defer close(p.done)
+
+	ready := make([]int, 128)
+	for {
+		n, err := p.q.wait(ready)
+		if atomic.LoadInt32(&p.closed) != 0 {
+			return
+		}
+		if err != nil {
+			continue // interrupted
+		}
+
+		for _, fd := range ready[:n] {
+			p.mu.Lock()
+			r := p.regs[fd]
+			p.mu.Unlock()
+			if r == nil {
+				continue // unregistered in the mean time
+			}
+
+			_, err := r.Poll()
+			switch err {
+			case nil:
+				p.rearm(fd, r)
+			case ErrBufferFull:
+				// Read rearms once it consumed
+				atomic.StoreInt32(&r.starved, 1)
+				if len(r.r.pool) != 0 && atomic.CompareAndSwapInt32(&r.starved, 1, 0) {
+					// consumed in the mean time
+					p.rearm(fd, r)
+				}
+			default:
+				// sticky error; no more polls
+				p.unregister(fd, r)
+			}
+		}
+	}
+}
+
+// Rearm enables the next event, if r is still registered.
+func (p *Poller) rearm(fd int, r *PollReader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.regs[fd] == r {
+		p.q.rearm(fd)
+	}
+}
+
+// Unregister ends the events of r, if any.
+func (p *Poller) unregister(fd int, r *PollReader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.regs[fd] == r {
+		delete(p.regs, fd)
+		p.q.remove(fd)
+	}
+}
+
+// Close stops the event loop. Readers registered remain open, yet they
+// get no more data. Close does not close any of the sources.
+func (p *Poller) Close() error {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return nil
+	}
+	if err := p.q.wake(); err != nil {
+		return err
+	}
+	<-p.done
+	return p.q.close()
+}
@@ -0,0 +1,85 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package nbio
+
+import "syscall"
+
+// Kqueue is the eventQueue of BSD and macOS.
+type kqueue struct {
+	fd     int
+	wakeup wakePipe
+	events []syscall.Kevent_t
+}
+
+func openEventQueue() (eventQueue, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	q := &kqueue{fd: fd}
+	if err := q.wakeup.open(); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// level triggered; drained never
+	if err := q.ctl(q.wakeup[0], syscall.EV_ADD); err != nil {
+		q.close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *kqueue) add(fd int) error {
+	return q.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (q *kqueue) rearm(fd int) error {
+	return q.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (q *kqueue) remove(fd int) error {
+	err := q.ctl(fd, syscall.EV_DELETE)
+	if err == syscall.ENOENT {
+		return nil // oneshot fired already
+	}
+	return err
+}
+
+func (q *kqueue) ctl(fd, flags int) error {
+	changes := make([]syscall.Kevent_t, 1)
+	syscall.SetKevent(&changes[0], fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(q.fd, changes, nil, nil)
+	return err
+}
+
+func (q *kqueue) wait(ready []int) (int, error) {
+	if len(q.events) != len(ready) {
+		q.events = make([]syscall.Kevent_t, len(ready))
+	}
+	n, err := syscall.Kevent(q.fd, nil, q.events, nil)
+	if err != nil {
+		return 0, err
+	}
+	var i int
+	for _, ev := range q.events[:n] {
+		if int(ev.Ident) != q.wakeup[0] {
+			ready[i] = int(ev.Ident)
+			i++
+		}
+	}
+	return i, nil
+}
+
+func (q *kqueue) wake() error {
+	return q.wakeup.wake()
+}
+
+func (q *kqueue) close() error {
+	err := q.wakeup.close()
+	if err2 := syscall.Close(q.fd); err == nil {
+		err = err2
+	}
+	return err
+}
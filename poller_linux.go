@@ -0,0 +1,76 @@
+package nbio
+
+import "syscall"
+
+// Epoll is the eventQueue of Linux.
+type epoll struct {
+	fd     int
+	wakeup wakePipe
+	events []syscall.EpollEvent
+}
+
+func openEventQueue() (eventQueue, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	q := &epoll{fd: fd}
+	if err := q.wakeup.open(); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// level triggered; drained never
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(q.wakeup[0])}
+	if err := syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, q.wakeup[0], &ev); err != nil {
+		q.close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *epoll) add(fd int) error {
+	return q.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+func (q *epoll) rearm(fd int) error {
+	return q.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (q *epoll) ctl(op, fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	return syscall.EpollCtl(q.fd, op, fd, &ev)
+}
+
+func (q *epoll) remove(fd int) error {
+	return syscall.EpollCtl(q.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (q *epoll) wait(ready []int) (int, error) {
+	if len(q.events) != len(ready) {
+		q.events = make([]syscall.EpollEvent, len(ready))
+	}
+	n, err := syscall.EpollWait(q.fd, q.events, -1)
+	if err != nil {
+		return 0, err
+	}
+	var i int
+	for _, ev := range q.events[:n] {
+		if int(ev.Fd) != q.wakeup[0] {
+			ready[i] = int(ev.Fd)
+			i++
+		}
+	}
+	return i, nil
+}
+
+func (q *epoll) wake() error {
+	return q.wakeup.wake()
+}
+
+func (q *epoll) close() error {
+	err := q.wakeup.close()
+	if err2 := syscall.Close(q.fd); err == nil {
+		err = err2
+	}
+	return err
+}
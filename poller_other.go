@@ -0,0 +1,21 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package nbio
+
+import "syscall"
+
+func openEventQueue() (eventQueue, error) {
+	return nil, ErrUnsupported
+}
+
+// RawSource is not in use without event queue.
+type rawSource struct {
+	PollSource
+	raw syscall.RawConn
+}
+
+// SetNonblock is not supported without event queue.
+func setNonblock(raw syscall.RawConn) error {
+	return ErrUnsupported
+}
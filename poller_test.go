@@ -0,0 +1,116 @@
+package nbio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Poller must deliver the data of many sources without read routines.
+func TestPoller(t *testing.T) {
+	p, err := NewPoller()
+	if err == ErrUnsupported {
+		t.Skip("no poller on this platform")
+	}
+	if err != nil {
+		t.Fatal("NewPoller error:", err)
+	}
+	defer p.Close()
+
+	const sourceCount = 50
+	var readers [sourceCount]*PollReader
+	var writers [sourceCount]*os.File
+	for i := range readers {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal("pipe error:", err)
+		}
+		defer pw.Close()
+		writers[i] = pw
+		readers[i], err = p.Register(pr, time.Second)
+		if err != nil {
+			t.Fatal("Register error:", err)
+		}
+		defer readers[i].Close()
+	}
+
+	buf := make([]byte, len(feed))
+	if n, err := readers[0].r.read(buf, 9*time.Millisecond, ErrNoData); n != 0 || err != ErrNoData {
+		t.Errorf("idle: read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+
+	for i := len(writers) - 1; i >= 0; i-- {
+		if _, err := writers[i].Write([]byte(feed)); err != nil {
+			t.Fatal("pipe write error:", err)
+		}
+	}
+	for i, r := range readers {
+		if n, err := r.Read(buf); n != len(feed) || err != nil {
+			t.Errorf("source %d: Read = (%d, %v), want (%d, <nil>)", i, n, err, len(feed))
+		} else if got := string(buf); got != feed {
+			t.Errorf("source %d: got %q, want %q", i, got, feed)
+		}
+	}
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q present in:\n%s", readRoutineStackEl, dump)
+	}
+
+	writers[1].Close()
+	for i := 0; i < 2; i++ {
+		if n, err := readers[1].Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read %d after pipe close = (%d, %v), want (0, %v)", i, n, err, io.EOF)
+		}
+	}
+}
+
+// Poller must resume a source once reads free the buffers.
+func TestPollerBufferFull(t *testing.T) {
+	p, err := NewPoller()
+	if err == ErrUnsupported {
+		t.Skip("no poller on this platform")
+	}
+	if err != nil {
+		t.Fatal("NewPoller error:", err)
+	}
+	defer p.Close()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal("pipe error:", err)
+	}
+	r, err := p.Register(pr, time.Second)
+	if err != nil {
+		t.Fatal("Register error:", err)
+	}
+	defer r.Close()
+
+	// more than the buffers hold
+	payload := make([]byte, 10*bufferSize)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	go func() {
+		pw.Write(payload)
+		pw.Close()
+	}()
+	time.Sleep(9 * time.Millisecond)
+
+	var got bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := r.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", got.Len(), len(payload))
+	}
+}
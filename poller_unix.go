@@ -0,0 +1,80 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package nbio
+
+import (
+	"io"
+	"syscall"
+)
+
+// RawSource reads the file descriptor of a PollSource directly, without
+// any wait.
+type rawSource struct {
+	PollSource // for Close
+	raw        syscall.RawConn
+}
+
+func (s *rawSource) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cerr := s.raw.Control(func(fd uintptr) {
+		n, err = syscall.Read(int(fd), p)
+	}); cerr != nil {
+		return 0, cerr
+	}
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return 0, nil // spurious readiness
+	case err != nil:
+		return 0, err
+	case n == 0:
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// SetNonblock puts the file descriptor of raw in non blocking mode.
+func setNonblock(raw syscall.RawConn) error {
+	var err error
+	if cerr := raw.Control(func(fd uintptr) {
+		err = syscall.SetNonblock(int(fd), true)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// WakePipe interrupts an event queue wait.
+type wakePipe [2]int
+
+func (w *wakePipe) open() error {
+	if err := syscall.Pipe(w[:]); err != nil {
+		return err
+	}
+	for _, fd := range w {
+		syscall.CloseOnExec(fd)
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			w.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wakePipe) wake() error {
+	_, err := syscall.Write(w[1], []byte{0})
+	if err == syscall.EAGAIN {
+		return nil // pending already
+	}
+	return err
+}
+
+func (w *wakePipe) close() error {
+	err := syscall.Close(w[0])
+	if err2 := syscall.Close(w[1]); err == nil {
+		err = err2
+	}
+	return err
+}
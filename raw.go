@@ -0,0 +1,63 @@
+package nbio
+
+import "sync/atomic"
+
+// RawReader is a non blocking reader of a file descriptor, without read
+// routine, as returned by NewRawReader.
+type RawReader struct {
+	isClosed int32 // Close flag
+
+	source *rawSource
+	err    error // sticky error of source
+}
+
+// NewRawReader returns a new non blocking wrapper which reads the file
+// descriptor of source directly, i.e., the read methods of source are not
+// used. The descriptor is put in non blocking mode, if it was not already,
+// which affects any other user of the descriptor too. Read never waits.
+// A read without data ready fails with ErrNoData right away, as a Reader
+// from NewReader does with a timeout of zero. This suits pipes, FIFOs,
+// character devices and unix sockets alike. Platforms without non blocking
+// descriptors fail with ErrUnsupported.
+//
+// Errors of the descriptor are sticky, as with NewReader.
+func NewRawReader(source PollSource) (*RawReader, error) {
+	raw, err := source.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := setNonblock(raw); err != nil {
+		return nil, err
+	}
+	return &RawReader{source: &rawSource{source, raw}}, nil
+}
+
+// Read implements the io.Reader interface. Read must not be called
+// concurrently with itself.
+func (r *RawReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := r.source.Read(p)
+	switch {
+	case err != nil:
+		r.err = err
+		return 0, err
+	case n == 0:
+		return 0, ErrNoData
+	}
+	return n, nil
+}
+
+// Close closes the source. Any read afterwards fails with ErrClosed.
+func (r *RawReader) Close() error {
+	atomic.StoreInt32(&r.isClosed, 1)
+	return r.source.Close()
+}
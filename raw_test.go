@@ -0,0 +1,47 @@
+package nbio
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// Raw Reader must read the descriptor without wait.
+func TestRawReader(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal("pipe error:", err)
+	}
+	defer pw.Close()
+	r, err := NewRawReader(pr)
+	if err == ErrUnsupported {
+		pr.Close()
+		t.Skip("no non blocking descriptors on this platform")
+	}
+	if err != nil {
+		t.Fatal("NewRawReader error:", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("idle: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if _, err := pw.Write([]byte(feed)); err != nil {
+		t.Fatal("pipe write error:", err)
+	}
+	if n, err := r.Read(buf); string(buf[:n]) != feed || err != nil {
+		t.Errorf("Read = (%d, %v) %q, want (%d, <nil>) %q", n, err, buf[:n], len(feed), feed)
+	}
+
+	pw.Close()
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read %d after pipe close = (%d, %v), want (0, %v)", i, n, err, io.EOF)
+		}
+	}
+	r.Close()
+	if n, err := r.Read(buf); n != 0 || err != ErrClosed {
+		t.Errorf("Read after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+}
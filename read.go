@@ -1,33 +1,259 @@
 package nbio
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-var ErrNoData = errors.New("no data available at the moment")
+// ErrNoData signals a timeout. The error satisfies net.Error, with both
+// Timeout and Temporary true, and errors.Is matches os.ErrDeadlineExceeded,
+// for use in deadline-aware code.
+var ErrNoData error = &timeoutError{"no data available at the moment"}
+
+// TimeoutError is a net.Error, which matches os.ErrDeadlineExceeded.
+type timeoutError struct{ msg string }
+
+func (e *timeoutError) Error() string   { return e.msg }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// Is supports errors.Is.
+func (e *timeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
+
+// ErrBufferFull signals that all buffers are occupied.
+var ErrBufferFull = errors.New("buffer full")
+
+// ErrBadMagic signals a stream which does not start with the expected
+// magic bytes.
+var ErrBadMagic = errors.New("stream does not start with the magic bytes")
+
+// ErrTooManyTimeouts signals a source which remained silent for too long.
+var ErrTooManyTimeouts = errors.New("too many consecutive timeouts")
+
+// ErrDeadlineExceeded signals an expired deadline. Unlike ErrNoData, the
+// error is not an invitation to try again.
+var ErrDeadlineExceeded = errors.New("deadline exceeded")
+
+// ErrSessionExpired signals reads past the SetSessionDeadline.
+var ErrSessionExpired = errors.New("session deadline exceeded")
+
+// ErrClosed signals use of a Reader after Close.
+var ErrClosed = errors.New("read on closed reader")
+
+// ErrCloseTimeout signals a read routine which did not terminate in time.
+var ErrCloseTimeout = errors.New("close timeout; read routine still blocked on source")
+
+// ErrConcurrentRead signals a read while another read is in progress.
+var ErrConcurrentRead = errors.New("concurrent read on reader")
+
+// ErrReadCanceled signals a Read wait which ended with CancelRead.
+var ErrReadCanceled = errors.New("read canceled")
+
+// ErrInvalidUnreadByte signals an UnreadByte without a byte to unread.
+var ErrInvalidUnreadByte = errors.New("invalid use of UnreadByte")
+
+// ErrUnsupported signals an operation which the source can not support.
+var ErrUnsupported = errors.New("operation not supported by source")
+
+// WaitReason is a likely cause for a Read timeout.
+type WaitReason int
+
+const (
+	// WaitNone is the absence of a timeout.
+	WaitNone WaitReason = iota
+	// WaitIdle is a read routine which was not in a blocking call,
+	// including a routine which did not start yet.
+	WaitIdle
+	// WaitSource is a read routine which was blocked on the source.
+	WaitSource
+	// WaitPool is a read routine which was blocked on a free buffer,
+	// i.e., the consumer holds on to the buffers.
+	WaitPool
+	// WaitCredit is a read routine which was blocked on Grant.
+	WaitCredit
+	// WaitPaused is a read routine which was held by Pause.
+	WaitPaused
+	// WaitRoutine is a read routine which waited for a slot of
+	// WithRoutines.
+	WaitRoutine
+)
+
+// String returns a description.
+func (w WaitReason) String() string {
+	switch w {
+	case WaitNone:
+		return "no wait"
+	case WaitIdle:
+		return "idle"
+	case WaitSource:
+		return "source starved"
+	case WaitPool:
+		return "pool blocked"
+	case WaitCredit:
+		return "credit exhausted"
+	case WaitPaused:
+		return "paused"
+	case WaitRoutine:
+		return "routine budget"
+	}
+	return fmt.Sprintf("wait reason %d", int(w))
+}
+
+// BufferSize is the capacity of each read buffer by default.
+const bufferSize = 2048
+
+// MinBufferSize is the lower bound for NewReaderSize.
+const minBufferSize = 64
+
+// EmptyBuf has no capacity, which keeps it out of the pool.
+var emptyBuf = []byte{}
+
+// Reader is a non blocking wrapper, as returned by NewReader.
+type Reader struct {
+	// atomic access first for alignment
+	dropped   uint64 // PushToLossy count
+	stats     stats  // counters for Stats
+	bufSize   int64  // buffer capacity target
+	buffered  int64  // bytes read from source, yet not delivered
+	highWater int64  // peak of buffered
+	boost     int64  // read-ahead raise from RequestReadahead
+	pos       int64  // offset of the consumer
+	credit    int64  // bytes the read routine may read, if credited
+	waitState int32  // WaitReason of the read routine
+	isClosed  int32  // Close flag
+	blocking  int32  // SetBlocking flag
+	paused    int32  // Pause flag
+	stopping  int32  // StopReading flag
+	draining  int32  // CloseRead flag
+	halting   int32  // stop flag; source interrupted with a deadline
+	reading   int32  // read in progress flag
+
+	// auto-size bounds, if any
+	sizeMin, sizeMax int
+	// approximate moving average of source reads
+	readAvg int
+
+	magic     []byte // pending stream start verification
+	keepMagic bool   // whether to deliver the magic bytes
 
-type reader struct {
 	r     io.ReadCloser // source
-	timer *time.Timer   // lazy init, reusable
+	clock Clock         // time of WithClock
+	timer Timer         // lazy init, reusable, disarmed between reads
 
 	// maximum amount of time to wait for data
 	timeout time.Duration
+	// optional end of life for the Reader
+	deadline time.Time
+	session  time.Time       // optional end of reads from SetSessionDeadline
+	ctx      context.Context // optional cancellation
+	waitCtx  context.Context // cancellation of ReadContext, if any
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	closeErr  error         // source Close result
+	closed    chan struct{} // signals Close
+
+	buf   []byte // current buffer
+	i     int    // position in current buffer
+	loose bool   // current buffer is not from the pool
+	seq   uint64 // source read number of current buffer
+	sent  uint64 // source read number of the read routine
+
+	latency atomic.Value // *latencyRing for RecordLatency
+	idle    atomic.Value // *idleWatch for OnIdle
+	trace   atomic.Value // *ReaderTrace for SetTrace
+	tee     atomic.Value // *Writer for Tee
+
+	next   chan []byte        // following buffer
+	pause  chan chan struct{} // halts the read routine until close
+	pool   chan []byte        // buffer recycling
+	err    chan error         // sticky error store
+	ready  chan struct{}      // Ready signal
+	resume chan struct{}      // signals Resume
+	cancel chan struct{}      // signals CancelRead
+
+	shared   *sync.Pool // optional origin of buffers, from WithSharedPool
+	onDemand bool       // WithBuffersOnDemand in effect
 
-	buf []byte // current buffer
-	i   int    // position in current buffer
+	failed   atomic.Value // errorBox with sticky error copy for PeekErr
+	surfaced bool         // whether Read returned the sticky error
+	mapErr   atomic.Value // func(error) error for MapError
+	inject   error        // pending InjectErr
+	fatal    error        // terminal error from the Read side
 
-	next chan []byte // following buffer
-	pool chan []byte // buffer recycling
-	err  chan error  // sticky error store
+	observer Observer          // optional event hooks
+	onActive func(active bool) // optional transition hook
+	active   bool              // whether data was delivered last
 
-	// 3 read buffers cycle through next and pool
-	buf1, buf2, buf3 [2048]byte
+	lastWait WaitReason // sample of the last Read
+
+	timeouts    int // consecutive ErrNoData count
+	maxTimeouts int // optional limit on timeouts
+
+	limiter Limiter // optional pacing of source reads
+
+	routines *Routines // optional budget from WithRoutines
+	priority int       // claim on routines
+
+	queueEvery time.Duration // optional WithQueueSampling interval
+	queueLast  time.Time     // last sample, owned by the read routine
+
+	retryMax  int           // optional retries of temporary errors
+	retryWait time.Duration // initial delay between retries
+
+	maxBuffered int64         // optional read-ahead limit in bytes
+	lowBuffered int64         // resume level after maxBuffered
+	drained     chan struct{} // signals consumption, with maxBuffered
+	budget      int           // optional bound on buffers from NewReaderBudget
+
+	credited  bool          // whether reads need credit
+	creditSig chan struct{} // signals Grant
+	creditLow atomic.Value  // creditHook for OnCreditLow
+	lowFired  bool          // whether creditLow fired since credit was up
+
+	boundaries  bool // no merge of source reads
+	eofWithData bool // io.EOF along with the last data
+	streaming   bool // any source error along with the last data
+	detached    bool // Close does not wait for the read routine
+
+	cp checkpoints // Checkpoint state
+
+	srcPos bool // whether pos is the offset in source, since Seek
+
+	blocksDone int // ReadBlocks progress in blocks
+	blockOff   int // ReadBlocks progress in the current block
+
+	heartbeat   []byte        // optional idle payload
+	hbInterval  time.Duration // idle time until heartbeat
+	hbLast      time.Time     // latest delivery of data or heartbeat
+	hbDelivered bool          // whether the last Read was a heartbeat
+
+	last    byte    // final byte of the last read
+	lastOK  bool    // whether last applies
+	stash   byte    // byte from UnreadByte
+	stashed bool    // whether stash applies
+	one     [1]byte // ReadByte buffer
 }
 
 // NewReader returns a new non blocking wrapper whose Read function
-// gives a time out (with ErrNoData) when applicable.
+// gives a time out (with ErrNoData) when applicable. A timeout of zero
+// makes Read poll, i.e., reads without data ready fail with ErrNoData
+// right away, without any timer. A negative timeout makes Read wait
+// without limit, like SetBlocking does.
 //
 // Errors of the underlying reader are sticky. Once Read returns an
 // error other than ErrNoData then all successisive calls will fail
@@ -35,108 +261,2755 @@ type reader struct {
 // or an error and never both. Because of the error persistence the
 // implementation stops reading from source on the first error thus
 // it is safe to create a new reader for recoverable situations.
-func NewReader(source io.ReadCloser, timeout time.Duration) io.ReadCloser {
-	r := &reader{
-		r:       source,
-		timeout: timeout,
-		next:    make(chan []byte, 1),
-		pool:    make(chan []byte, 2),
-		err:     make(chan error, 1),
-	}
-	r.buf = r.buf1[:0]
-	r.pool <- r.buf3[:]
-
-	// read pool and feed next until source error
+// Source errors pass as is, which keeps them apart from ErrNoData, with
+// errors.Is and errors.As on the original. A source which gives ErrNoData
+// itself, such as a nested Reader, counts as a read without data instead.
+//
+// Read, and the other reads, must not be called concurrently with each
+// other. Read, and the reads built on it, such as ReadByte and TryRead,
+// detect such misuse with ErrConcurrentRead, without any effect on the
+// read in progress. Close, CancelRead, StopReading, PeekErr and Stats are
+// safe for use from any goroutine, including during a read.
+//
+// The return was an io.ReadCloser in earlier versions. *Reader satisfies
+// the interface, yet function values of the former signature, i.e.,
+// func(io.ReadCloser, time.Duration) io.ReadCloser, need an adapter.
+func NewReader(source io.ReadCloser, timeout time.Duration) *Reader {
+	r := newReader(source, timeout)
+	r.Start()
+	return r
+}
+
+// NewReaderBudget is like NewReader, yet it fails when the buffers of the
+// Reader would exceed totalBytes in size. A Reader allocates its buffers
+// once, on construction, and when the buffers are all pending, then the
+// read routine waits for Read to recycle rather than to allocate more.
+// The data which ScanAhead, Peek, ReadSlice and SetSource join beyond the
+// buffers counts to totalBytes too, and they fail with ErrBufferFull
+// instead of allocating past it. Reset keeps the bound, yet buffers on
+// Lease which are not released before Reset are not accounted for.
+func NewReaderBudget(source io.ReadCloser, timeout time.Duration, totalBytes int) (*Reader, error) {
+	if size := 3 * bufferSize; totalBytes < size {
+		return nil, fmt.Errorf("budget of %d bytes is less than the %d bytes of buffers", totalBytes, size)
+	}
+	r := newReader(source, timeout)
+	r.budget = totalBytes
+	r.Start()
+	return r, nil
+}
+
+// WithinBudget returns whether a new buffer of n bytes fits the budget,
+// next to the buffers of the pool and the current buffer, if loose.
+func (r *Reader) withinBudget(n int) bool {
+	if r.budget == 0 {
+		return true // no bound
+	}
+	inUse := cap(r.pool) * r.BufferSize()
+	if r.loose {
+		inUse += cap(r.buf)
+	}
+	return inUse+n <= r.budget
+}
+
+// NewReaderDeadline is like NewReader, yet the Reader expires at deadline.
+// All reads fail with ErrDeadlineExceeded from then on, including reads
+// on buffered data, and the read routine stops. Sources with a
+// SetReadDeadline method, such as net.Conn, get the deadline applied,
+// which unblocks the read routine in time. Others stop on the first read
+// which completes after the deadline.
+func NewReaderDeadline(source io.ReadCloser, pollTimeout time.Duration, deadline time.Time) *Reader {
+	if d, ok := source.(interface{ SetReadDeadline(time.Time) error }); ok {
+		d.SetReadDeadline(deadline)
+	}
+	r := newReader(source, pollTimeout)
+	r.deadline = deadline
+	r.Start()
+	return r
+}
+
+// NewReaderCtxCancel is like NewReader, yet cancellation of ctx stops the
+// read routine, including one blocked on the source. Sources with a
+// SetReadDeadline method, such as net.Conn, get a deadline in the past.
+// Other sources are closed instead. Reads fail with the error of ctx once
+// the data buffered prior to cancellation is consumed. Close remains
+// required.
+func NewReaderCtxCancel(ctx context.Context, source io.ReadCloser, timeout time.Duration) *Reader {
+	r := newReader(source, timeout)
+	r.ctx = ctx
+	r.Start()
+
 	go func() {
-		buf := r.buf2[:]
+		select {
+		case <-r.closed:
+			return
+		case <-ctx.Done():
+		}
 
-		for {
-			n, err := r.r.Read(buf)
-			if n != 0 {
-				r.next <- buf[:n]
-				buf = <-r.pool
-				buf = buf[:cap(buf)]
-			}
-			if err != nil {
-				r.err <- err
-				close(r.next)
-				return
-			}
+		if d, ok := source.(interface{ SetReadDeadline(time.Time) error }); ok {
+			d.SetReadDeadline(time.Unix(1, 0))
+		} else {
+			r.closeSource()
 		}
 	}()
 
 	return r
 }
 
-func (r *reader) Close() error {
-	err := r.r.Close()
+// NewAutoSizeReader is like NewReader, yet the read buffers follow the
+// amount of data which source reads return, within the bounds of minSize
+// and maxSize. Buffers grow when source reads fill them entirely, and
+// they shrink towards twice the average read otherwise. Resizes apply to
+// buffers as they return to the read routine, i.e., without any data in
+// them. BufferSize returns the current target.
+func NewAutoSizeReader(source io.ReadCloser, timeout time.Duration, minSize, maxSize int) *Reader {
+	if minSize < 1 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	size := bufferSize
+	if size < minSize {
+		size = minSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
 
-	// flush to kill Go routine
-	for buf := range r.next {
-		r.pool <- buf
+	r := newReader(source, timeout)
+	r.sizeMin, r.sizeMax = minSize, maxSize
+	r.bufSize = int64(size)
+	r.readAvg = size / 2
+	r.Start()
+	return r
+}
+
+// NewReaderMagic is like NewReader, yet the stream must start with magic.
+// Reads fail with ErrBadMagic on mismatch, and reading stops. The magic
+// bytes may span any number of source reads. Deliver sets whether Read
+// returns the magic bytes, or whether the data starts right after them.
+// Delivery of magic bytes waits for the full match, unless the magic
+// exceeds the buffer size.
+func NewReaderMagic(source io.ReadCloser, timeout time.Duration, magic []byte, deliver bool) *Reader {
+	r := newReader(source, timeout)
+	r.magic = append([]byte(nil), magic...)
+	r.keepMagic = deliver
+	r.Start()
+	return r
+}
+
+// NewReaderMaxTimeouts is like NewReader, yet the Reader gives up after
+// maxConsecutive timeouts in a row. Any read after that fails with
+// ErrTooManyTimeouts, and the source is closed to stop the read routine.
+// Each delivery of data resets the count.
+func NewReaderMaxTimeouts(source io.ReadCloser, timeout time.Duration, maxConsecutive int) *Reader {
+	r := newReader(source, timeout)
+	r.maxTimeouts = maxConsecutive
+	r.Start()
+	return r
+}
+
+// NewHeartbeatReader is like NewReader, yet Read returns payload instead
+// of ErrNoData once no data arrived for interval. Data from source always
+// takes priority, and each delivery, heartbeat or not, restarts interval.
+// Heartbeats are indistinguishable from source data, other than by their
+// content or with IsHeartbeat. Payload is cut short when it does not fit
+// the Read buffer.
+func NewHeartbeatReader(source io.ReadCloser, timeout, interval time.Duration, payload []byte) *Reader {
+	r := newReader(source, timeout)
+	r.heartbeat = payload
+	r.hbInterval = interval
+	r.hbLast = r.clock.Now()
+	r.Start()
+	return r
+}
+
+// IsHeartbeat returns whether the last Read delivered the payload from
+// NewHeartbeatReader rather than data from source.
+func (r *Reader) IsHeartbeat() bool {
+	return r.hbDelivered
+}
+
+// NewReaderFromReader is like NewReader, yet for sources without Close.
+// Close terminates the read routine without any effect on source, and it
+// returns without waiting for a pending read on source, as nothing can
+// interrupt such read. The read routine lives on until the pending read
+// returns, if ever, together with its buffers. Reset does wait for the
+// read routine to terminate.
+func NewReaderFromReader(source io.Reader, timeout time.Duration) *Reader {
+	r := newReader(ioutil.NopCloser(source), timeout)
+	r.detached = true
+	r.Start()
+	return r
+}
+
+// NewStreamReader is like NewReader, yet Read returns the final data of
+// source together with the error, i.e., n > 0 along with an error, as
+// permitted by io.Reader. A source read with both data and an error then
+// surfaces in one Read, provided that p has room for all of the data.
+// Errors after a source read without data still come on the call after.
+// This breaks with the either data or error contract of NewReader.
+func NewStreamReader(source io.ReadCloser, timeout time.Duration) *Reader {
+	r := newReader(source, timeout)
+	r.streaming = true
+	r.Start()
+	return r
+}
+
+// NewLazyReader is like NewReader, yet the read routine does not start
+// until the first read, or until Start. No data is read ahead from source
+// until then, which suits sources that are shared or flow controlled.
+func NewLazyReader(source io.ReadCloser, timeout time.Duration) *Reader {
+	return newReader(source, timeout)
+}
+
+// Start launches the read routine, if it did not start already. Readers
+// from NewLazyReader start on their first read otherwise.
+func (r *Reader) Start() {
+	r.startOnce.Do(func() {
+		go r.readRoutine()
+	})
+}
+
+// noRoutine marks the read routine as terminated, for use with startOnce.
+func (r *Reader) noRoutine() {
+	r.err <- ErrClosed
+	close(r.next)
+}
+
+// NewReaderWithPrefix is like NewReader, yet Read delivers prefix before
+// any data from source. Prefix serves the data exported by a previous
+// Reader on the same source, i.e., the return of ExportState.
+func NewReaderWithPrefix(prefix []byte, source io.ReadCloser, timeout time.Duration) *Reader {
+	r := newReader(source, timeout)
+	if len(prefix) != 0 {
+		r.pool <- r.buf[:cap(r.buf)]
+		r.buf = append([]byte(nil), prefix...)
+		r.loose = true
+		r.queued(len(prefix))
+		r.signalReady()
 	}
+	r.Start()
+	return r
+}
 
-	return err
+// NewReaderSize is like NewReader, yet each of the 3 buffers has bufSize
+// bytes of capacity, with a minimum of 64, instead of the default 2048.
+// Larger buffers save on hand-overs for high-throughput sources, while
+// smaller ones save memory on many connections. Source reads never exceed
+// bufSize bytes.
+func NewReaderSize(source io.ReadCloser, timeout time.Duration, bufSize int) *Reader {
+	if bufSize < minBufferSize {
+		bufSize = minBufferSize
+	}
+	r := newReaderSize(source, timeout, bufSize)
+	r.Start()
+	return r
 }
 
-func (r *reader) Read(p []byte) (int, error) {
-	if r.timer == nil {
-		r.timer = time.NewTimer(r.timeout)
-	} else {
-		r.timer.Reset(r.timeout)
+// newReader returns a new Reader without read routine.
+func newReader(source io.ReadCloser, timeout time.Duration) *Reader {
+	return newReaderSize(source, timeout, bufferSize)
+}
+
+// newReaderSize returns a new Reader without read routine, with buffers
+// of size bytes.
+func newReaderSize(source io.ReadCloser, timeout time.Duration, size int) *Reader {
+	return newReaderBuffers(source, timeout, size, 3, nil)
+}
+
+// newReaderBuffers returns a new Reader without read routine, with count
+// buffers of size bytes. The buffers come from shared, if not nil.
+func newReaderBuffers(source io.ReadCloser, timeout time.Duration, size, count int, shared *sync.Pool) *Reader {
+	r := &Reader{
+		r:         source,
+		clock:     stdClock{},
+		timeout:   timeout,
+		pool:      make(chan []byte, count),
+		shared:    shared,
+		err:       make(chan error, 1),
+		ready:     make(chan struct{}, 1),
+		resume:    make(chan struct{}, 1),
+		cancel:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		pause:     make(chan chan struct{}),
+		bufSize:   int64(size),
+		waitState: int32(WaitIdle),
 	}
+	r.next = r.makeNext()
+	watchLeak(r)
 
-	// ensure data or timeout
-	buf := r.buf
-	for buf != nil && r.i >= len(buf) {
-		select {
-		case <-r.timer.C:
-			return 0, ErrNoData
+	// read buffers cycle through next and pool
+	var mem []byte
+	if shared == nil {
+		mem = make([]byte, count*size)
+	}
+	for i := 0; i < count; i++ {
+		var buf []byte
+		if shared == nil {
+			buf = mem[i*size : (i+1)*size : (i+1)*size]
+		} else {
+			buf = sharedBuf(shared, size)
+		}
+		if i == 0 {
+			r.buf = buf[:0]
+		} else {
+			r.pool <- buf
+		}
+	}
+	return r
+}
 
-		case buf = <-r.next:
-			r.pool <- r.buf
-			r.buf = buf
-			r.i = 0
+// SharedBuf returns a buffer of size bytes from p.
+func sharedBuf(p *sync.Pool, size int) []byte {
+	if buf, ok := p.Get().([]byte); ok && cap(buf) == size {
+		return buf[:size]
+	}
+	// empty pool, or other size
+	return make([]byte, size)
+}
+
+// MakeNext returns a channel for all buffers but the one of the read
+// routine and the current one of the consumer.
+func (r *Reader) makeNext() chan []byte {
+	return make(chan []byte, cap(r.pool)-2)
+}
+
+// Reset makes the Reader read from source instead, as if it was new from
+// NewReader, yet with reuse of its buffers, such that a Reader can be
+// pooled, e.g., with sync.Pool. The former source is closed, like Close
+// does, when not closed already. The read routine terminates before reuse.
+// Leased buffers which are not released yet are replaced by new ones.
+func (r *Reader) Reset(source io.ReadCloser, timeout time.Duration) {
+	r.close(true)
+
+	// recover buffers
+	size := int(atomic.LoadInt64(&r.bufSize))
+	var bufs [][]byte
+	if cap(r.buf) == size && !r.loose {
+		bufs = append(bufs, r.buf[:0])
+	}
+	for len(r.pool) != 0 {
+		if buf := <-r.pool; cap(buf) == size {
+			bufs = append(bufs, buf[:cap(buf)])
+		}
+	}
+	count, shared := cap(r.pool), r.shared
+	maxBuffered, lowBuffered := int(r.maxBuffered), int(r.lowBuffered)
+	onDemand, limiter, clock := r.onDemand, r.limiter, r.clock
+	routines, priority := r.routines, r.priority
+	queueEvery := r.queueEvery
+	retryMax, retryWait := r.retryMax, r.retryWait
+	budget := r.budget
+	if len(bufs) != count {
+		// lease pending, auto-size or shared pool
+		// r keeps its own LeakWarnings finalizer, if any
+		*r = *unwatchLeak(newReaderBuffers(source, timeout, size, count, shared))
+		r.limitBuffered(maxBuffered, lowBuffered)
+		r.limiter = limiter
+		r.clock = clock
+		r.routines, r.priority = routines, priority
+		r.queueEvery = queueEvery
+		r.retryMax, r.retryWait = retryMax, retryWait
+		r.budget = budget
+		if onDemand {
+			r.buffersOnDemand()
 		}
+		r.Start()
+		return
 	}
 
-	if !r.timer.Stop() {
-		<-r.timer.C
+	pool, pause, errs, timer := r.pool, r.pause, r.err, r.timer
+	*r = Reader{
+		r:         source,
+		clock:     clock,
+		timer:     timer,
+		timeout:   timeout,
+		limiter:   limiter,
+		routines:  routines,
+		priority:  priority,
+		retryMax:  retryMax,
+		retryWait: retryWait,
+		budget:    budget,
+		pool:      pool,
+		shared:    shared,
+		err:       errs,
+		ready:     make(chan struct{}, 1),
+		resume:    make(chan struct{}, 1),
+		cancel:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		pause:     pause,
+		bufSize:   int64(size),
+		waitState: int32(WaitIdle),
+	}
+	// drain sticky error
+	select {
+	case <-r.err:
+	default:
+	}
+	r.next = r.makeNext()
+	r.buf = bufs[0][:0]
+	for _, buf := range bufs[1:] {
+		r.pool <- buf
 	}
+	r.limitBuffered(maxBuffered, lowBuffered)
+	r.queueEvery = queueEvery
+	r.Start()
+}
 
-	if buf == nil {
-		// an error occured
-		err := <-r.err
-		r.err <- err
-		return 0, err
+// BuffersOnDemand applies WithBuffersOnDemand. The pool gets placeholders
+// instead of buffers, which the read routine replaces with buffers from
+// the shared pool, and consumption returns the buffers to the shared pool.
+func (r *Reader) buffersOnDemand() {
+	if r.shared == nil {
+		return
+	}
+	r.onDemand = true
+	if cap(r.buf) != 0 {
+		r.shared.Put(r.buf[:cap(r.buf)])
+	}
+	r.buf = emptyBuf
+	for i := len(r.pool); i > 0; i-- {
+		if buf := <-r.pool; cap(buf) != 0 {
+			r.shared.Put(buf[:cap(buf)])
+		}
+	}
+	for len(r.pool) < cap(r.pool) {
+		r.pool <- emptyBuf
+	}
+}
+
+// limitBuffered applies a WithMaxBuffered of n bytes, with zero for none.
+// The read routine resumes at low bytes or less, with any consumption for
+// a low out of range.
+func (r *Reader) limitBuffered(n, low int) {
+	if n <= 0 {
+		return
 	}
+	if low < 0 || low >= n {
+		low = n - 1
+	}
+	r.maxBuffered = int64(n)
+	r.lowBuffered = int64(low)
+	r.drained = make(chan struct{}, 1)
+}
 
-	var n int
+// RequestReadahead raises the read-ahead limit of WithMaxBuffered by n
+// bytes, in anticipation of a burst, e.g., when protocol state announces a
+// large payload. The raise decays as reads consume data, i.e., the limit
+// is back to normal once n more bytes were delivered. Requests add up. The
+// call is a hint only, without any guarantee on the amount read ahead, as
+// the buffers remain a bound, and as the read routine reads whatever source
+// gives. Readers without WithMaxBuffered read ahead as far as the buffers
+// allow already, which makes the call a no-op. RequestReadahead is safe for
+// use from any goroutine.
+func (r *Reader) RequestReadahead(n int) {
+	if n <= 0 || r.maxBuffered == 0 {
+		return
+	}
+	atomic.AddInt64(&r.boost, int64(n))
+	select {
+	case r.drained <- struct{}{}:
+	default:
+		// signal pending already
+	}
+}
+
+// decayReadahead takes n bytes consumed off the RequestReadahead raise.
+func (r *Reader) decayReadahead(n int) {
 	for {
-		did := copy(p, buf[r.i:])
-		r.i += did
-		n += did
+		boost := atomic.LoadInt64(&r.boost)
+		if boost == 0 {
+			return
+		}
+		rest := boost - int64(n)
+		if rest < 0 {
+			rest = 0
+		}
+		if atomic.CompareAndSwapInt64(&r.boost, boost, rest) {
+			return
+		}
+	}
+}
 
-		if n >= len(p) {
-			// filled buffer
-			return n, nil
+// readRoutine reads pool and feeds next until source error. The routine
+// takes one buffer from the pool, which it returns on exit.
+func (r *Reader) readRoutine() {
+	buf := r.poolBuf()
+	if rs := r.routines; rs != nil {
+		if err := r.awaitRoutine(); err != nil {
+			r.fail(buf, err)
+			return
 		}
-		p = p[did:]
+		// no access to r after fail
+		defer rs.release()
+	}
+	var held int    // magic bytes pending delivery in buf
+	var empty int   // consecutive reads without data nor error
+	var retries int // consecutive retries of temporary errors
 
+	for {
 		select {
+		case resume := <-r.pause:
+			<-resume
+		case <-r.closed:
+			// source may not interrupt
+			r.fail(buf, ErrClosed)
+			return
 		default:
-			// don't wait for more
-			return n, nil
+		}
+		for atomic.LoadInt32(&r.paused) != 0 && atomic.LoadInt32(&r.stopping) == 0 {
+			atomic.StoreInt32(&r.waitState, int32(WaitPaused))
+			select {
+			case <-r.resume:
+				atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+			case <-r.closed:
+				r.fail(buf, ErrClosed)
+				return
+			}
+		}
+		if atomic.LoadInt32(&r.stopping) != 0 {
+			r.fail(buf, io.EOF)
+			return
+		}
 
-		case buf = <-r.next:
-			r.pool <- r.buf
-			r.buf = buf
-			r.i = 0
+		end := len(buf)
+		if r.maxBuffered != 0 {
+			room, err := r.awaitRoom()
+			if err != nil {
+				r.fail(buf, err)
+				return
+			}
+			if held+room < end {
+				end = held + room
+			}
+		}
+		var credit int
+		if r.credited {
+			var err error
+			credit, err = r.awaitCredit()
+			if err != nil {
+				r.fail(buf, err)
+				return
+			}
+			if held+credit < end {
+				end = held + credit
+			}
+		}
 
-			if buf == nil {
-				// an error occured
-				return n, nil
+		if r.limiter != nil {
+			if burst := r.limiter.Burst(); burst > 0 && end-held > burst {
+				end = held + burst
+			}
+		}
+
+		trace := r.loadTrace()
+		if trace != nil && trace.SourceReadStart != nil {
+			trace.SourceReadStart(r.clock.Now())
+		}
+		atomic.StoreInt32(&r.waitState, int32(WaitSource))
+		n, err := r.r.Read(buf[held:end])
+		atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+		if trace != nil && trace.SourceReadDone != nil {
+			trace.SourceReadDone(r.clock.Now(), n, err)
+		}
+		if err == ErrNoData {
+			// source is a Reader too; no sticky timeouts
+			err = nil
+		}
+		if r.credited {
+			r.spendCredit(credit, n)
+		}
+		if n == 0 && err == nil {
+			empty++
+			r.backoff(empty)
+		} else {
+			empty = 0
+		}
+		if n != 0 && len(r.magic) != 0 {
+			var ok bool
+			n, ok = r.matchMagic(buf[held : held+n])
+			if !ok {
+				n, err = 0, ErrBadMagic
+			} else if r.keepMagic {
+				n += held
+				held = 0
+				if len(r.magic) != 0 && n < len(buf) {
+					// deliver on full match only
+					held, n = n, 0
+				}
+			}
+		}
+		if !r.deadline.IsZero() && !r.clock.Now().Before(r.deadline) {
+			err = ErrDeadlineExceeded
+		}
+		if err != nil && r.ctx != nil && r.ctx.Err() != nil {
+			err = r.ctx.Err()
+		}
+		var retry time.Duration // delay before the next read, if any
+		if err != nil && r.retryable(err, retries) {
+			retries++
+			retry = retryDelay(r.retryWait, retries)
+			err = nil
+		} else if n != 0 {
+			retries = 0
+		}
+		if err != nil {
+			if f, _ := r.mapErr.Load().(func(error) error); f != nil {
+				err = f(err)
+			}
+		}
+
+		if n != 0 {
+			if teeErr := r.teeCopy(buf[:n]); teeErr != nil && err == nil {
+				err = teeErr
+			}
+			if w, _ := r.idle.Load().(*idleWatch); w != nil {
+				w.timer.Reset(w.d)
+			}
+			r.queued(n)
+			r.sent++
+			if l, _ := r.latency.Load().(*latencyRing); l != nil {
+				l.arrive(r.sent, n)
+			}
+			if err != nil && r.streaming {
+				if atomic.LoadInt32(&r.draining) != 0 {
+					err = io.EOF
+				}
+				// error in place before the data
+				r.failed.Store(errorBox{err})
+				r.err <- err
+				r.handoff(buf[:n])
+				r.signalReady()
+				close(r.next)
+				return
+			}
+			r.handoff(buf[:n])
+			r.signalReady()
+			if r.sizeMax != 0 {
+				r.adaptSize(n, len(buf))
+			}
+			buf = r.poolBuf()
+
+			if r.limiter != nil && err == nil {
+				ctx := closeCtx{r.closed}
+				if err = r.limiter.WaitN(ctx, n); err != nil && ctx.Err() != nil {
+					err = ErrClosed
+				}
+			}
+		}
+		if retry != 0 {
+			timer := r.clock.NewTimer(retry)
+			select {
+			case <-timer.C():
+			case <-r.closed:
+				timer.Stop()
+				err = ErrClosed
 			}
 		}
+		if err != nil {
+			r.fail(buf, err)
+			return
+		}
 	}
 }
+
+// Retryable returns whether WithRetry applies to err, after the given
+// number of retries in a row.
+func (r *Reader) retryable(err error, retries int) bool {
+	if r.retryMax == 0 || (r.retryMax > 0 && retries >= r.retryMax) {
+		return false
+	}
+	if atomic.LoadInt32(&r.stopping) != 0 || atomic.LoadInt32(&r.halting) != 0 || atomic.LoadInt32(&r.isClosed) != 0 {
+		// interrupted on purpose
+		return false
+	}
+	return isTemporary(err)
+}
+
+// IsTemporary returns whether err is a net.Error with Timeout or with
+// Temporary.
+func isTemporary(err error) bool {
+	var e net.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Timeout() || e.Temporary()
+}
+
+// RetryDelay returns the wait before the nth retry in a row, which doubles
+// from initial, up to 32 times initial.
+func retryDelay(initial time.Duration, n int) time.Duration {
+	if n > 6 {
+		n = 6
+	}
+	return initial << uint(n-1)
+}
+
+// MaxEmptyBackoff limits the delay after reads without data nor error.
+const maxEmptyBackoff = 10 * time.Millisecond
+
+// CloseCtx is a context which is done once closed is closed.
+type closeCtx struct{ closed <-chan struct{} }
+
+func (closeCtx) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c closeCtx) Done() <-chan struct{}           { return c.closed }
+func (closeCtx) Value(key interface{}) interface{} { return nil }
+
+func (c closeCtx) Err() error {
+	select {
+	case <-c.closed:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// Backoff delays the read routine after the nth read in a row without
+// data nor error, which io.Reader permits, to prevent a busy loop. The
+// first few yield the processor only. The delay doubles from there on,
+// within the timeout.
+func (r *Reader) backoff(n int) {
+	if n <= 3 {
+		runtime.Gosched()
+		return
+	}
+	d := maxEmptyBackoff
+	if n < 12 {
+		d = 50 * time.Microsecond << uint(n-4)
+		if d > maxEmptyBackoff {
+			d = maxEmptyBackoff
+		}
+	}
+	if d > r.timeout {
+		d = r.timeout
+	}
+	if d <= 0 {
+		runtime.Gosched()
+		return
+	}
+	time.Sleep(d)
+}
+
+// handoff passes buf to Read, with a wait for room in next, if needed.
+func (r *Reader) handoff(buf []byte) {
+	atomic.AddUint64(&r.stats.handoffs, 1)
+	if r.queueEvery != 0 {
+		r.sampleQueue()
+	}
+	select {
+	case r.next <- buf:
+	default:
+		atomic.AddUint64(&r.stats.stalls, 1)
+		r.next <- buf
+	}
+}
+
+// fail terminates the read routine with a sticky error.
+func (r *Reader) fail(buf []byte, err error) {
+	if atomic.LoadInt32(&r.draining) != 0 {
+		err = io.EOF
+	}
+	r.failed.Store(errorBox{err})
+	r.pool <- buf
+	r.err <- err
+	r.signalReady()
+	close(r.next) // last, as Reset may reuse r right after
+}
+
+// Ready returns a channel which receives a signal when data arrives, or
+// when the source fails. The signal is edge-triggered, i.e., once received,
+// there is no signal for the data pending. The caller should Read until
+// ErrNoData before waiting on the channel again. Signals may be spurious,
+// as data arrival during such Reads leaves a signal too. Ready starts the
+// read routine, if it did not start already. The channel is the same for
+// the lifetime of the Reader, except for Reset.
+func (r *Reader) Ready() <-chan struct{} {
+	r.Start()
+	return r.ready
+}
+
+// signalReady sends on ready without blocking.
+func (r *Reader) signalReady() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+		// signal pending
+	}
+}
+
+// Pause stops reads from source, once any pending read completes, until
+// Resume. Read still delivers the data buffered, after which it times out
+// with ErrNoData, as usual. A source left unread applies backpressure,
+// e.g., TCP flow control on a connection. Pause and Resume may be called
+// from any goroutine.
+func (r *Reader) Pause() {
+	atomic.StoreInt32(&r.paused, 1)
+}
+
+// Resume continues reads from source after Pause.
+func (r *Reader) Resume() {
+	atomic.StoreInt32(&r.paused, 0)
+	select {
+	case r.resume <- struct{}{}:
+	default:
+		// signal pending already
+	}
+}
+
+// AwaitRoutine waits for a slot of WithRoutines. Close aborts the wait with
+// ErrClosed.
+func (r *Reader) awaitRoutine() error {
+	w := r.routines.join(r.priority)
+	atomic.StoreInt32(&r.waitState, int32(WaitRoutine))
+	defer atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+	for {
+		select {
+		case <-w.granted:
+			return nil
+		case resume := <-r.pause:
+			<-resume
+		case <-r.closed:
+			r.routines.leave(w)
+			return ErrClosed
+		}
+	}
+}
+
+// AwaitRoom returns the number of bytes the read routine may buffer,
+// once there is any, with WithMaxBuffered, plus any RequestReadahead. A
+// full read-ahead waits for the low watermark of WithWatermarks instead. Close aborts the wait with
+// ErrClosed, and StopReading aborts with io.EOF.
+func (r *Reader) awaitRoom() (int, error) {
+	var full bool // reached maxBuffered
+	for {
+		if atomic.LoadInt32(&r.stopping) != 0 {
+			return 0, io.EOF
+		}
+		buffered := atomic.LoadInt64(&r.buffered)
+		boost := atomic.LoadInt64(&r.boost)
+		room := r.maxBuffered + boost - buffered
+		if room > 0 && (!full || buffered <= r.lowBuffered+boost) {
+			return int(room), nil
+		}
+		full = true
+
+		atomic.StoreInt32(&r.waitState, int32(WaitPool))
+		select {
+		case <-r.drained:
+		case resume := <-r.pause:
+			<-resume
+		case <-r.closed:
+			return 0, ErrClosed
+		}
+		atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+	}
+}
+
+// AwaitCredit returns the credit available, once there is any. Close
+// aborts the wait with ErrClosed, and StopReading aborts with io.EOF.
+func (r *Reader) awaitCredit() (int, error) {
+	for {
+		if atomic.LoadInt32(&r.stopping) != 0 {
+			return 0, io.EOF
+		}
+		credit := atomic.LoadInt64(&r.credit)
+		if credit > 0 {
+			if credit > math.MaxInt32 {
+				credit = math.MaxInt32
+			}
+			return int(credit), nil
+		}
+
+		atomic.StoreInt32(&r.waitState, int32(WaitCredit))
+		select {
+		case <-r.creditSig:
+		case <-r.closed:
+			return 0, ErrClosed
+		}
+		atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+	}
+}
+
+// CreditHook is the OnCreditLow configuration.
+type creditHook struct {
+	threshold int
+	f         func(remaining int)
+}
+
+// SpendCredit deducts n bytes read from the credit available before.
+func (r *Reader) spendCredit(available, n int) {
+	remaining := atomic.AddInt64(&r.credit, -int64(n))
+
+	hook, _ := r.creditLow.Load().(creditHook)
+	if hook.f == nil {
+		return
+	}
+	if available >= hook.threshold {
+		r.lowFired = false
+	}
+	if remaining < int64(hook.threshold) && !r.lowFired {
+		r.lowFired = true
+		hook.f(int(remaining))
+	}
+}
+
+// OnCreditLow installs f for a Reader from NewCreditReader, which is
+// called once the credit drops below threshold. The call is made by the
+// read routine, right after the read which uses the credit. F does not
+// fire again until Grant gets the credit back up to threshold, which
+// avoids a callback on each read near the threshold.
+func (r *Reader) OnCreditLow(threshold int, f func(remaining int)) {
+	r.creditLow.Store(creditHook{threshold, f})
+}
+
+// NewCreditReader is like NewReader, yet the read routine reads no more
+// from source than the credit granted, starting with initialCredit bytes.
+// Reading halts once the credit runs out, which leaves source to apply
+// backpressure, until Grant adds more.
+func NewCreditReader(source io.ReadCloser, timeout time.Duration, initialCredit int) *Reader {
+	r := newReader(source, timeout)
+	r.credited = true
+	r.credit = int64(initialCredit)
+	r.creditSig = make(chan struct{}, 1)
+	r.Start()
+	return r
+}
+
+// Grant adds n bytes of credit for a Reader from NewCreditReader, i.e.,
+// the read routine may read n more bytes from source. Grant may be called
+// from any goroutine.
+func (r *Reader) Grant(n int) {
+	atomic.AddInt64(&r.credit, int64(n))
+	select {
+	case r.creditSig <- struct{}{}:
+	default:
+		// signal pending already
+	}
+}
+
+// matchMagic verifies the start of buf against the pending magic bytes.
+// The return is the amount of data left to deliver.
+func (r *Reader) matchMagic(buf []byte) (n int, ok bool) {
+	k := len(r.magic)
+	if k > len(buf) {
+		k = len(buf)
+	}
+	if !bytes.Equal(buf[:k], r.magic[:k]) {
+		r.magic = nil
+		return 0, false
+	}
+	r.magic = r.magic[k:]
+
+	if r.keepMagic {
+		return len(buf), true
+	}
+	return copy(buf, buf[k:]), true
+}
+
+// poolBuf takes a buffer from the pool in full capacity, or in the target
+// size for auto-size Readers.
+func (r *Reader) poolBuf() []byte {
+	var buf []byte
+	select {
+	case buf = <-r.pool:
+	default:
+		atomic.AddUint64(&r.stats.stalls, 1)
+		atomic.StoreInt32(&r.waitState, int32(WaitPool))
+		buf = <-r.pool
+		atomic.StoreInt32(&r.waitState, int32(WaitIdle))
+	}
+	if cap(buf) == 0 {
+		// placeholder of WithBuffersOnDemand
+		return sharedBuf(r.shared, int(atomic.LoadInt64(&r.bufSize)))
+	}
+	if r.sizeMax != 0 {
+		size := int(atomic.LoadInt64(&r.bufSize))
+		if cap(buf) != size {
+			return make([]byte, size)
+		}
+	}
+	return buf[:cap(buf)]
+}
+
+// adaptSize updates the buffer size target with a source read of n bytes
+// into a buffer of size bytes.
+func (r *Reader) adaptSize(n, size int) {
+	r.readAvg = (7*r.readAvg + n) / 8
+
+	target := 2 * r.readAvg
+	if n == size {
+		// source may have had more
+		target = 2 * size
+	}
+	if target < r.sizeMin {
+		target = r.sizeMin
+	}
+	if target > r.sizeMax {
+		target = r.sizeMax
+	}
+	atomic.StoreInt64(&r.bufSize, int64(target))
+}
+
+// BufferSize returns the capacity of the read buffers. The size of
+// auto-size Readers changes over time.
+func (r *Reader) BufferSize() int {
+	return int(atomic.LoadInt64(&r.bufSize))
+}
+
+// Depth returns the number of read buffers configured, and the number of
+// buffers the read-ahead may fill at the moment, as effective. The byte
+// limit of WithMaxBuffered, with any raise from RequestReadahead, lowers
+// the effective depth, relative to the BufferSize in effect. Depth is safe
+// for use from any goroutine.
+func (r *Reader) Depth() (configured, effective int) {
+	configured = cap(r.pool)
+	if r.maxBuffered == 0 {
+		return configured, configured
+	}
+	limit := r.maxBuffered + atomic.LoadInt64(&r.boost)
+	size := atomic.LoadInt64(&r.bufSize)
+	if n := (limit + size - 1) / size; n < int64(configured) {
+		return configured, int(n)
+	}
+	return configured, configured
+}
+
+// ReArmAfterEOF resumes reading from the source after Read returned
+// io.EOF. The method is meant for protocols which end each message with
+// an EOF, while the stream continues with the next message, like some
+// framing layers do. The source must support reads after EOF as such.
+// ReArmAfterEOF fails when Read did not return an error yet, and it
+// fails with the sticky error when the error is other than io.EOF.
+func (r *Reader) ReArmAfterEOF() error {
+	if !r.surfaced {
+		return errors.New("no EOF from Read yet")
+	}
+	err := <-r.err
+	if err != io.EOF {
+		r.err <- err
+		return err
+	}
+	r.rearm()
+	return nil
+}
+
+// ClearErr resumes reading from the source after Read returned an error,
+// i.e., the error is surfaced once, rather than sticky. The method is meant
+// for sources with transient errors, such as a net.Error with Temporary,
+// or a deadline from the source. The source must support reads after the
+// error as such. ClearErr fails when Read did not return an error yet.
+// Errors from the Read side, such as ErrTooManyTimeouts, can not clear.
+// ClearErr must be called from the goroutine which reads.
+func (r *Reader) ClearErr() error {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return ErrClosed
+	}
+	if r.fatal != nil {
+		return r.fatal
+	}
+	if !r.surfaced {
+		return errors.New("no error from Read yet")
+	}
+	<-r.err
+	r.rearm()
+	return nil
+}
+
+// Rearm restarts the read routine after the sticky error is taken.
+func (r *Reader) rearm() {
+	r.failed.Store(errorBox{})
+	r.surfaced = false
+	atomic.StoreInt32(&r.stopping, 0)
+	atomic.StoreInt32(&r.draining, 0)
+	r.buf = emptyBuf
+	r.next = r.makeNext()
+	go r.readRoutine()
+}
+
+// SetSource continues with source after the current one failed, e.g.,
+// to reconnect. Any data which is not consumed yet remains, and it is
+// delivered before the data of source. The sticky error is cleared, and
+// the former source is closed. SetSource fails with ErrClosed after Close,
+// and it fails when the current source did not fail yet, which includes
+// errors from InjectErr, as the read routine is still on the source then.
+// SetSource must be called from the goroutine which reads, and not
+// concurrently with Close.
+func (r *Reader) SetSource(source io.ReadCloser) error {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return ErrClosed
+	}
+	if len(r.err) == 0 {
+		// no sticky error from the read routine, e.g., InjectErr only
+		return errors.New("current source did not fail yet")
+	}
+
+	if r.buf == nil {
+		// next closed and received
+		r.buf, r.i = emptyBuf, 0
+	} else {
+		// buffers in next are final once the routine failed
+		if !r.withinBudget(len(r.buf) - r.i + len(r.next)*r.BufferSize()) {
+			return ErrBufferFull
+		}
+		// read routine closes next promptly
+		var tail [][]byte
+		var size int
+		for buf := range r.next {
+			tail = append(tail, buf)
+			size += len(buf)
+		}
+		if len(tail) != 0 {
+			joined := make([]byte, 0, len(r.buf)-r.i+size)
+			joined = append(joined, r.buf[r.i:]...)
+			for _, buf := range tail {
+				joined = append(joined, buf...)
+				r.pool <- buf[:cap(buf)]
+			}
+			// one source read per buffer; swap counts the last
+			r.delivered(len(tail) - 1)
+			r.swap(joined)
+			r.loose = true
+		}
+	}
+	// drain sticky error
+	select {
+	case <-r.err:
+	default:
+	}
+	r.failed.Store(errorBox{})
+	r.surfaced = false
+	r.fatal, r.inject = nil, nil
+	r.timeouts = 0
+	atomic.StoreInt32(&r.stopping, 0)
+	atomic.StoreInt32(&r.draining, 0)
+
+	r.closeSource()
+	r.r = source
+	r.srcPos = false // offsets of another source
+	r.closeOnce = sync.Once{}
+	r.closeErr = nil
+	r.closed = make(chan struct{})
+	r.next = r.makeNext()
+	go r.readRoutine()
+	return nil
+}
+
+// StopReading ends reads from source, once any pending read completes,
+// without closing source. Reads deliver all data buffered, after which
+// they fail with io.EOF, at which point the read routine terminated, and
+// source is free for other use, e.g., a connection hand-over. Close still
+// applies to source afterwards, if desired. ReArmAfterEOF resumes reads
+// from source. StopReading may be called from any goroutine.
+func (r *Reader) StopReading() error {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return ErrClosed
+	}
+	atomic.StoreInt32(&r.stopping, 1)
+	r.startOnce.Do(func() {
+		// routine never started
+		r.err <- io.EOF
+		close(r.next)
+	})
+
+	// wake any wait of the read routine
+	for _, c := range []chan struct{}{r.resume, r.creditSig, r.drained} {
+		select {
+		case c <- struct{}{}:
+		default:
+			// signal pending already, or no channel
+		}
+	}
+	return nil
+}
+
+// CancelRead makes a Read which waits on data return ErrReadCanceled at
+// once, without any effect on source. Data already buffered has priority
+// over the cancel. When no Read waits at the moment, then the cancel
+// applies to the next Read which does. CancelRead may be called from any
+// goroutine.
+func (r *Reader) CancelRead() {
+	select {
+	case r.cancel <- struct{}{}:
+	default:
+		// cancel pending already
+	}
+}
+
+// CloseRead closes the source, yet unlike Close, reads deliver all data
+// buffered, after which they fail with io.EOF, rather than ErrClosed or
+// the error of source on close. A peer may send a final status before
+// hang-up, for example. Close still applies to release the Reader. The
+// return is the result of Close on source. CloseRead may be called from
+// any goroutine.
+func (r *Reader) CloseRead() error {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return ErrClosed
+	}
+	atomic.StoreInt32(&r.draining, 1)
+	atomic.StoreInt32(&r.stopping, 1)
+	r.startOnce.Do(func() {
+		// routine never started
+		r.err <- io.EOF
+		close(r.next)
+	})
+	return r.closeSource()
+}
+
+// Close closes the source. Any read afterwards fails with ErrClosed.
+// Close may be called from any goroutine, including during a Read, which
+// then fails with either ErrClosed or the error of source on close, as a
+// pipe might give, even when source does not interrupt. Close returns
+// once the read routine terminated. No timer remains armed, as each read
+// disarms its timer before return.
+func (r *Reader) Close() error {
+	return r.close(!r.detached)
+}
+
+// Close terminates the read routine, and it waits for the termination when
+// wait is true. Otherwise, the routine is flushed in the background.
+func (r *Reader) close(wait bool) error {
+	if atomic.SwapInt32(&r.isClosed, 1) == 0 && r.observer != nil {
+		r.observer.Closed()
+	}
+	r.stopIdle()
+	err := r.closeSource()
+	r.startOnce.Do(r.noRoutine)
+
+	if !wait {
+		// flush to kill Go routine eventually
+		go func(next <-chan []byte, pool chan<- []byte) {
+			for buf := range next {
+				pool <- buf
+			}
+		}(r.next, r.pool)
+		return err
+	}
+
+	// flush to kill Go routine
+	for buf := range r.next {
+		r.pool <- buf
+	}
+	r.releaseShared()
+
+	return err
+}
+
+// releaseShared returns the pooled buffers to the WithSharedPool, if any.
+// The current buffer may still be in use by Read, which it keeps.
+func (r *Reader) releaseShared() {
+	if r.shared == nil {
+		return
+	}
+	size := int(atomic.LoadInt64(&r.bufSize))
+	for {
+		select {
+		case buf := <-r.pool:
+			if cap(buf) == size {
+				r.shared.Put(buf[:size])
+			}
+		default:
+			return
+		}
+	}
+}
+
+// CloseWithTimeout is like Close, yet it gives up if the source does not
+// close, or if the read routine does not terminate, within d, in which
+// case the return is ErrCloseTimeout. Some sources block on close, and
+// some do not interrupt a pending read on close. The read routine lives
+// on until the pending read on source returns, if ever, together with its
+// buffers, which leaks when the source blocks for good. Any read afterwards
+// fails with ErrClosed, regardless.
+func (r *Reader) CloseWithTimeout(d time.Duration) error {
+	if atomic.SwapInt32(&r.isClosed, 1) == 0 && r.observer != nil {
+		r.observer.Closed()
+	}
+	r.stopIdle()
+	closeDone := make(chan error, 1)
+	go func() {
+		closeDone <- r.closeSource()
+	}()
+	r.startOnce.Do(r.noRoutine)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var err error
+	next := r.next
+	for closeDone != nil || next != nil {
+		select {
+		case err = <-closeDone:
+			closeDone = nil
+
+		case buf, ok := <-next:
+			if !ok {
+				next = nil
+				continue
+			}
+			r.pool <- buf
+
+		case <-timer.C:
+			// detach; flush to kill Go routine eventually
+			go func(next <-chan []byte) {
+				for buf := range next {
+					r.pool <- buf
+				}
+			}(r.next)
+			return ErrCloseTimeout
+		}
+	}
+	r.releaseShared()
+	return err
+}
+
+// closeSource closes the source once.
+func (r *Reader) closeSource() error {
+	r.closeOnce.Do(func() {
+		r.closeErr = r.r.Close()
+		close(r.closed)
+	})
+	return r.closeErr
+}
+
+// InjectErr makes err the sticky error, for testing purposes. Reads
+// deliver any data buffered at the moment first. Once no data is ready,
+// reads fail with err, regardless of the source state. InjectErr must be
+// called from the goroutine which reads.
+func (r *Reader) InjectErr(err error) {
+	r.inject = err
+	r.failed.Store(errorBox{err})
+}
+
+// MapError installs a translation of source errors, which applies before
+// the error becomes sticky, such that Read returns the mapped error. When
+// f returns nil, then the error is ignored, and reading continues. Such
+// is meant for transient errors only, as reading again must make progress.
+// MapError is safe for use from any goroutine. Errors which occurred
+// prior to the call are not mapped.
+func (r *Reader) MapError(f func(error) error) {
+	r.mapErr.Store(f)
+}
+
+// PeekErr returns the error of the underlying reader, if any, without
+// blocking. The error may show before Read returns it, i.e., while data
+// received prior to the error still drains. PeekErr does not affect the
+// outcome of Read. It is safe for use from any goroutine.
+func (r *Reader) PeekErr() error {
+	box, _ := r.failed.Load().(errorBox)
+	return box.err
+}
+
+// OnIdle installs a hook which is called once no data arrived from source
+// for d, regardless of any use of Read, e.g., to reconnect a dead upstream
+// proactively. The hook fires once per silence, i.e., it fires again only
+// after data arrived in the mean time. The period starts on installation.
+// The hook runs on a goroutine of its own. Close stops the hook. OnIdle
+// may be called from any goroutine, yet only once.
+func (r *Reader) OnIdle(d time.Duration, f func()) {
+	r.idle.Store(&idleWatch{d, time.AfterFunc(d, func() {
+		if atomic.LoadInt32(&r.isClosed) == 0 {
+			f()
+		}
+	})})
+}
+
+// IdleWatch is the OnIdle configuration.
+type idleWatch struct {
+	d     time.Duration
+	timer *time.Timer
+}
+
+// stopIdle ends the OnIdle hook, if any.
+func (r *Reader) stopIdle() {
+	if w, _ := r.idle.Load().(*idleWatch); w != nil {
+		w.timer.Stop()
+	}
+}
+
+// errorBox gives atomic.Value one concrete type for any error.
+type errorBox struct{ err error }
+
+// OnActive installs a hook which is called on each transition between
+// the active state, i.e., Read delivered data, and the idle state, i.e.,
+// Read timed out with ErrNoData. Readers start idle. Repeated reads with
+// the same outcome do not call f again. The hook runs on the goroutine
+// of Read, and it must be installed before use of Read.
+func (r *Reader) OnActive(f func(active bool)) {
+	r.onActive = f
+}
+
+// PreserveBoundaries sets whether Read stops at the end of the data from
+// each read on the source, i.e., data from two source reads never merge
+// into one Read, not even when p has room for both. Records larger than
+// p take multiple Reads, the last of which ends at the record boundary.
+// The mode must be set before use of Read.
+func (r *Reader) PreserveBoundaries(on bool) {
+	r.boundaries = on
+}
+
+// SetReadTimeout sets the maximum amount of time to wait for data on each
+// read that follows. Zero polls, i.e., reads without data ready fail with
+// ErrNoData right away. Negative timeouts block, like SetBlocking does.
+// It must be called from the goroutine which reads.
+func (r *Reader) SetReadTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// SetSessionDeadline limits all reads that follow to t, on top of the
+// timeout of each, e.g., for a handshake which must complete within 5
+// seconds as a whole. Reads fail with ErrSessionExpired once t passes,
+// including reads on buffered data, while the data remains available after
+// the session deadline is lifted. The zero value lifts the session
+// deadline. Unlike NewReaderDeadline, the read routine is not affected. It
+// must be called from the goroutine which reads.
+func (r *Reader) SetSessionDeadline(t time.Time) {
+	r.session = t
+}
+
+// SetBlocking sets whether reads wait for data without timeout. Blocking
+// reads return on data or on error only, including the error caused by
+// Close. The mode applies to any wait which starts after the call, and it
+// may be changed at any time, from any goroutine.
+func (r *Reader) SetBlocking(on bool) {
+	var flag int32
+	if on {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.blocking, flag)
+}
+
+// DeliverEOFWithData sets whether Read returns io.EOF together with the
+// last data from source, rather than on the call after. This breaks with
+// the either data or error contract of NewReader, thus callers must handle
+// n > 0 with io.EOF. Only applies when the read routine got io.EOF before
+// the final Read. The mode must be set before use of Read.
+func (r *Reader) DeliverEOFWithData(on bool) {
+	r.eofWithData = on
+}
+
+// Read implements the io.Reader interface.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	atomic.AddUint64(&r.stats.reads, 1)
+	if !r.deadline.IsZero() {
+		n, err = r.ReadBoth(r.deadline, p)
+	} else {
+		n, err = r.track(r.read(p, r.timeout, ErrNoData))
+	}
+	if r.heartbeat != nil {
+		n, err = r.beat(p, n, err)
+	}
+	return n, err
+}
+
+// ReadByte implements the io.ByteReader interface. The timeout applies
+// like it does for Read, with ErrNoData.
+func (r *Reader) ReadByte() (byte, error) {
+	n, err := r.Read(r.one[:])
+	if n == 0 {
+		return 0, err
+	}
+	return r.one[0], nil
+}
+
+// ReadRune reads a UTF-8 encoded character, like bufio.Reader does. The
+// timeout applies like it does for Read, with ErrNoData, in which case no
+// data is consumed, i.e., a partial encoding remains buffered. Invalid
+// encodings give utf8.RuneError with size 1, as does a partial encoding
+// at the end of data from source.
+func (r *Reader) ReadRune() (ch rune, size int, err error) {
+	var lead [utf8.UTFMax]byte
+	var held int // unread byte, if any
+	if r.stashed {
+		lead[0] = r.stash
+		if utf8.FullRune(lead[:1]) {
+			ch, size = utf8.DecodeRune(lead[:1])
+			_, err = r.ReadByte() // delivers the unread byte
+			return ch, size, err
+		}
+		held = 1
+	}
+
+	var n int // bytes in lead from the buffer
+	_, err = r.ScanAhead(func(unread []byte) (int, bool) {
+		n = copy(lead[held:], unread)
+		return 0, utf8.FullRune(lead[:held+n])
+	})
+	switch {
+	case err == nil:
+		// full encoding
+	case err != r.PeekErr(), r.buf == nil, held+len(r.buf[r.i:]) == 0:
+		// no source error, e.g., ErrNoData, or no data left
+		r.track(0, err)
+		return 0, 0, err
+	default:
+		// partial encoding before the source error
+		n = copy(lead[held:], r.buf[r.i:])
+	}
+
+	ch, size = utf8.DecodeRune(lead[:held+n])
+	if held != 0 {
+		r.stashed = false
+		if r.cp.outstanding != 0 {
+			r.record(lead[:1])
+		}
+		r.consumed(1)
+		r.last, r.lastOK = r.stash, true
+		r.track(1, nil)
+	}
+	if size > held {
+		r.consumeView(r.buf[r.i:r.i+size-held], nil)
+	}
+	return ch, size, nil
+}
+
+// UnreadByte implements the io.ByteScanner interface. The next read gets
+// the final byte of the last read once more. UnreadByte fails with
+// ErrInvalidUnreadByte when the last read had no data, or when the last
+// operation was an UnreadByte already. Reads which do not copy into a
+// buffer, such as WriteTo, Peek and LineReader, do not see the byte.
+func (r *Reader) UnreadByte() error {
+	if !r.lastOK {
+		return ErrInvalidUnreadByte
+	}
+	r.stash, r.stashed, r.lastOK = r.last, true, false
+	r.consumed(-1)
+	return nil
+}
+
+// beat applies the NewHeartbeatReader payload to a Read return.
+func (r *Reader) beat(p []byte, n int, err error) (int, error) {
+	r.hbDelivered = false
+	switch {
+	case n != 0:
+		r.hbLast = r.clock.Now()
+	case err == ErrNoData && r.clock.Now().Sub(r.hbLast) >= r.hbInterval:
+		r.hbLast = r.clock.Now()
+		r.hbDelivered = true
+		return copy(p, r.heartbeat), nil
+	}
+	return n, err
+}
+
+// TryRead is like Read, yet it never waits, regardless of the timeout,
+// i.e., reads without data ready fail with ErrNoData right away, and no
+// timer is involved. Consumers which poll at a fixed rate, such as game
+// loops, save the timer overhead. An expired deadline still fails with
+// ErrDeadlineExceeded. SetBlocking takes precedence, like it does for
+// reads with a zero timeout.
+func (r *Reader) TryRead(p []byte) (int, error) {
+	if !r.deadline.IsZero() && !r.clock.Now().Before(r.deadline) {
+		return 0, ErrDeadlineExceeded
+	}
+	return r.track(r.read(p, 0, ErrNoData))
+}
+
+// ReadBoth is like Read, with an absolute deadline on top of the timeout.
+// The timeout gives ErrNoData, as usual, while the deadline gives
+// ErrDeadlineExceeded, whichever expires first. The reader remains usable
+// either way. A deadline in the past fails without delivering any data.
+func (r *Reader) ReadBoth(deadline time.Time, p []byte) (int, error) {
+	if !r.deadline.IsZero() && r.deadline.Before(deadline) {
+		deadline = r.deadline
+	}
+	wait := deadline.Sub(r.clock.Now())
+	if wait <= 0 {
+		return 0, ErrDeadlineExceeded
+	}
+	if r.timeout < 0 || wait < r.timeout {
+		return r.track(r.read(p, wait, ErrDeadlineExceeded))
+	}
+	return r.track(r.read(p, r.timeout, ErrNoData))
+}
+
+// LastWaitReason returns the likely cause of the timeout on the last Read,
+// if any. The value is a best-effort sample of the read routine state at
+// the moment of expiry, for debugging purposes only.
+func (r *Reader) LastWaitReason() WaitReason {
+	return r.lastWait
+}
+
+// WriteTo implements the io.WriterTo interface, as used by io.Copy. Each
+// buffer goes to w as is, without the copy of Read. WriteTo returns nil
+// on io.EOF. Timeouts give ErrNoData, like Read, after which WriteTo may
+// be called again.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		if err := r.await(r.timeout, ErrNoData); err != nil {
+			r.track(0, err)
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+
+		done, err := r.writeBuf(w)
+		n += int64(done)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// WriteBuf passes the unread data of the current buffer to w.
+func (r *Reader) writeBuf(w io.Writer) (int, error) {
+	chunk := r.buf[r.i:]
+	done, err := w.Write(chunk)
+	if r.cp.outstanding != 0 {
+		r.record(chunk[:done])
+	}
+	r.i += done
+	r.consumed(done)
+	r.track(done, nil)
+	if err == nil && done < len(chunk) {
+		err = io.ErrShortWrite
+	}
+	return done, err
+}
+
+// ReadIntoMmap reads into region, starting at offset, with the same
+// semantics as Read. Region is meant for memory-mapped files, though any
+// slice works. Data is copied at byte granularity, thus offset needs no
+// alignment. No reference to region is retained after the call, yet the
+// mapping must remain valid for the duration of the call.
+func (r *Reader) ReadIntoMmap(region []byte, offset int) (int, error) {
+	if offset < 0 || offset > len(region) {
+		return 0, fmt.Errorf("offset %d out of region bounds [0, %d]", offset, len(region))
+	}
+	return r.Read(region[offset:])
+}
+
+// ReadSeq is like StepRead, without the discard, as it reads data from
+// one source read at most. The sequence number counts the reads on source
+// which delivered data, starting with one. Data from NewReaderWithPrefix
+// has sequence zero. Numbers continue after ReArmAfterEOF, and after Seek,
+// with a gap for any data discarded by Seek. ScanAhead and SetSource merge
+// source reads, which then report with the number of the latest read merged.
+func (r *Reader) ReadSeq(p []byte) (n int, seq uint64, err error) {
+	boundaries := r.boundaries
+	r.boundaries = true
+	n, err = r.track(r.read(p, r.timeout, ErrNoData))
+	r.boundaries = boundaries
+	return n, r.seq, err
+}
+
+// ReadTimed is like Read, yet it also reports the amount of time the call
+// took, which is about the timeout on ErrNoData, and close to zero when
+// data was ready. The duration helps to tune the timeout.
+func (r *Reader) ReadTimed(p []byte) (n int, waited time.Duration, err error) {
+	start := r.clock.Now()
+	n, err = r.Read(p)
+	return n, r.clock.Now().Sub(start), err
+}
+
+// ReadContext is like Read, yet it also stops waiting for data once ctx is
+// done, with the error of ctx. Data ready is delivered regardless, i.e.,
+// the error of ctx applies only when a read has to wait.
+func (r *Reader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	r.waitCtx = ctx
+	n, err := r.track(r.read(p, r.timeout, ErrNoData))
+	r.waitCtx = nil
+	return n, err
+}
+
+// ReadAtLeast reads into p until it has at least min bytes, like
+// io.ReadAtLeast does. The timeout covers the call as a whole, i.e., it
+// does not restart per source read. ReadAtLeast fails with ErrNoData when
+// the timeout expires before min is reached, in which case the partial
+// data remains in p, and n counts the bytes. Source errors are returned
+// as is, including io.EOF, together with any data before the error.
+func (r *Reader) ReadAtLeast(p []byte, min int) (n int, err error) {
+	if len(p) < min {
+		return 0, io.ErrShortBuffer
+	}
+
+	timeout, timeoutErr := r.timeout, ErrNoData
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = r.clock.Now().Add(timeout)
+	}
+	if !r.deadline.IsZero() && (deadline.IsZero() || r.deadline.Before(deadline)) {
+		deadline, timeoutErr = r.deadline, ErrDeadlineExceeded
+	}
+
+	for n < min {
+		if !deadline.IsZero() {
+			timeout = deadline.Sub(r.clock.Now())
+			if timeout <= 0 {
+				if timeoutErr == ErrDeadlineExceeded {
+					return n, timeoutErr
+				}
+				timeout = 0 // poll once more
+			}
+		}
+		var did int
+		did, err = r.track(r.read(p[n:], timeout, timeoutErr))
+		n += did
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Discard skips the next n bytes, like the method of bufio.Reader does, yet
+// without a buffer from the caller. The timeout covers the call as a whole,
+// like with ReadAtLeast. Discard fails with ErrNoData when the timeout
+// expires before n is reached, in which case discarded counts the bytes
+// skipped. Source errors are returned as is, including io.EOF.
+func (r *Reader) Discard(n int) (discarded int, err error) {
+	if !atomic.CompareAndSwapInt32(&r.reading, 0, 1) {
+		return 0, ErrConcurrentRead
+	}
+	defer atomic.StoreInt32(&r.reading, 0)
+
+	timeout, timeoutErr := r.timeout, ErrNoData
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = r.clock.Now().Add(timeout)
+	}
+	if !r.deadline.IsZero() && (deadline.IsZero() || r.deadline.Before(deadline)) {
+		deadline, timeoutErr = r.deadline, ErrDeadlineExceeded
+	}
+
+	if r.stashed && n > 0 {
+		r.stashed = false
+		if r.cp.outstanding != 0 {
+			r.record([]byte{r.stash})
+		}
+		r.consumed(1)
+		discarded++
+	}
+	// skipped data can't be unread
+	r.lastOK = false
+
+	for discarded < n {
+		if !deadline.IsZero() {
+			timeout = deadline.Sub(r.clock.Now())
+			if timeout <= 0 {
+				if timeoutErr == ErrDeadlineExceeded {
+					break
+				}
+				timeout = 0 // poll once more
+			}
+		}
+		if err = r.await(timeout, timeoutErr); err != nil {
+			break
+		}
+
+		skip := r.buf[r.i:]
+		if len(skip) > n-discarded {
+			skip = skip[:n-discarded]
+		}
+		if r.cp.outstanding != 0 {
+			r.record(skip)
+		}
+		r.i += len(skip)
+		r.consumed(len(skip))
+		discarded += len(skip)
+	}
+	r.RecycleConsumed()
+	r.track(discarded, err)
+	return discarded, err
+}
+
+// ReadBuffers fills bufs in order, like a Read on each would, yet with one
+// wait for the first byte only. Once data is available, it copies what is
+// buffered at the time, without waiting for more, e.g., a header and its
+// payload get their data in one call. A timeout without any data gives
+// ErrNoData as usual. Source errors are returned as is, together with any
+// data before the error.
+func (r *Reader) ReadBuffers(bufs net.Buffers) (n int64, err error) {
+	timeout, timeoutErr := r.timeout, ErrNoData
+	if !r.deadline.IsZero() {
+		wait := r.deadline.Sub(r.clock.Now())
+		if wait <= 0 {
+			return 0, ErrDeadlineExceeded
+		}
+		if timeout < 0 || wait < timeout {
+			timeout, timeoutErr = wait, ErrDeadlineExceeded
+		}
+	}
+
+	for _, p := range bufs {
+		if len(p) == 0 {
+			continue
+		}
+		var did int
+		did, err = r.read(p, timeout, timeoutErr)
+		n += int64(did)
+		if err != nil {
+			if n != 0 && err == timeoutErr {
+				err = nil // got data
+			}
+			break
+		}
+		if did < len(p) {
+			break // no more buffered
+		}
+		timeout = 0
+	}
+	r.track(int(n), err)
+	return n, err
+}
+
+// ReadBlocks fills each block in full, in order, and it returns the number
+// of full blocks. ReadBlocks fails with ErrNoData when a read times out
+// before all blocks are full. The progress is retained until all blocks
+// are full, such that the next call continues with the same blocks where
+// the previous left off. The return then counts blocks from the first
+// call.
+func (r *Reader) ReadBlocks(blocks [][]byte) (int, error) {
+	for r.blocksDone < len(blocks) {
+		block := blocks[r.blocksDone]
+		for r.blockOff < len(block) {
+			n, err := r.track(r.read(block[r.blockOff:], r.timeout, ErrNoData))
+			r.blockOff += n
+			if err != nil {
+				return r.blocksDone, err
+			}
+		}
+		r.blocksDone++
+		r.blockOff = 0
+	}
+
+	n := r.blocksDone
+	r.blocksDone = 0
+	return n, nil
+}
+
+// StepRead waits for the data of the next read on the source, and it
+// discards any data buffered before the call. Unlike any other read, it
+// bypasses buffered data, which makes it useful for stepping through the
+// chunks of a source, e.g., in tests. The data of one source read stays
+// apart, as with PreserveBoundaries. A timeout gives ErrNoData as usual.
+func (r *Reader) StepRead(p []byte) (int, error) {
+discard:
+	for r.buf != nil {
+		r.consumed(len(r.buf) - r.i)
+		r.i = len(r.buf)
+		select {
+		case buf := <-r.next:
+			r.swap(buf)
+		default:
+			break discard
+		}
+	}
+
+	boundaries := r.boundaries
+	r.boundaries = true
+	n, err := r.read(p, r.timeout, ErrNoData)
+	r.boundaries = boundaries
+	return r.track(n, err)
+}
+
+// track applies the OnActive hook and the timeout limit on a read outcome.
+func (r *Reader) track(n int, err error) (int, error) {
+	if r.observer != nil {
+		r.observe(n, err)
+	}
+	var active bool
+	switch {
+	case n != 0:
+		atomic.AddUint64(&r.stats.delivered, uint64(n))
+		active = true
+		r.timeouts = 0
+	case err == ErrNoData:
+		atomic.AddUint64(&r.stats.timeouts, 1)
+		active = false
+		r.timeouts++
+		if r.maxTimeouts != 0 && r.timeouts >= r.maxTimeouts {
+			r.fatal = ErrTooManyTimeouts
+			r.closeSource()
+		}
+	default:
+		return n, err
+	}
+
+	if r.active != active {
+		r.active = active
+		if r.onActive != nil {
+			r.onActive(active)
+		}
+	}
+	return n, err
+}
+
+// read gives timeoutErr when no data arrives in time. Any UnreadByte
+// goes first.
+func (r *Reader) read(p []byte, timeout time.Duration, timeoutErr error) (int, error) {
+	if !atomic.CompareAndSwapInt32(&r.reading, 0, 1) {
+		return 0, ErrConcurrentRead
+	}
+	defer atomic.StoreInt32(&r.reading, 0)
+
+	if r.stashed && len(p) != 0 {
+		p[0] = r.stash
+		r.stashed = false
+		r.last, r.lastOK = r.stash, true
+		if r.cp.outstanding != 0 {
+			r.record(p[:1])
+		}
+		r.consumed(1)
+		return 1, nil
+	}
+
+	n, err := r.readBuf(p, timeout, timeoutErr)
+	r.lastOK = n != 0
+	if n != 0 {
+		r.last = p[n-1]
+	}
+	return n, err
+}
+
+// readBuf is read without UnreadByte.
+func (r *Reader) readBuf(p []byte, timeout time.Duration, timeoutErr error) (int, error) {
+	if err := r.await(timeout, timeoutErr); err != nil {
+		return 0, err
+	}
+	buf := r.buf
+
+	var n int
+	for {
+		did := copy(p, buf[r.i:])
+		if r.cp.outstanding != 0 {
+			r.record(buf[r.i : r.i+did])
+		}
+		r.i += did
+		n += did
+		r.consumed(did)
+
+		if n >= len(p) {
+			// filled buffer
+			r.RecycleConsumed()
+			return n, r.lastEOF()
+		}
+		p = p[did:]
+		if r.boundaries {
+			// end of source read
+			r.RecycleConsumed()
+			return n, r.lastEOF()
+		}
+
+		select {
+		default:
+			// don't wait for more
+			r.RecycleConsumed()
+			return n, nil
+
+		case buf = <-r.next:
+			r.swap(buf)
+
+			if buf == nil {
+				// an error occured
+				return n, r.lastEOF()
+			}
+		}
+	}
+}
+
+// LastEOF returns io.EOF when DeliverEOFWithData applies, i.e., when all
+// data is consumed, and when source ended with io.EOF. Readers from
+// NewStreamReader get any source error.
+func (r *Reader) lastEOF() error {
+	if !(r.eofWithData || r.streaming) || (r.buf != nil && r.i < len(r.buf)) {
+		return nil
+	}
+	var err error
+	select {
+	case err = <-r.err:
+		r.err <- err
+	default:
+		return nil // no error yet
+	}
+	if r.buf != nil {
+		// the read routine sends any pending data promptly
+		buf := <-r.next
+		r.swap(buf)
+		if buf != nil {
+			return nil
+		}
+	}
+
+	if err != io.EOF && !r.streaming {
+		return nil
+	}
+	r.surfaced = true
+	return err
+}
+
+// await ensures unread data in the current buffer. It gives timeoutErr
+// when no data arrives in time, or the sticky error when applicable.
+func (r *Reader) await(timeout time.Duration, timeoutErr error) error {
+	if atomic.LoadInt32(&r.isClosed) != 0 {
+		return ErrClosed
+	}
+	r.Start()
+	r.lastWait = WaitNone
+	if r.fatal != nil {
+		return r.fatal
+	}
+	var session time.Duration // wait until session deadline, if any
+	if !r.session.IsZero() {
+		session = r.session.Sub(r.clock.Now())
+		if session <= 0 {
+			return ErrSessionExpired
+		}
+	}
+	if r.inject != nil && r.buf != nil && r.i >= len(r.buf) {
+		select {
+		case buf := <-r.next:
+			r.swap(buf)
+		default:
+			r.fatal = r.inject
+			return r.fatal
+		}
+	}
+
+	buf := r.buf
+	if buf != nil && r.i < len(buf) {
+		// data ready
+		return nil
+	}
+
+	var expire <-chan time.Time // lazy init; nil blocks
+	blocking := timeout < 0 || atomic.LoadInt32(&r.blocking) != 0
+	poll := timeout == 0 && !blocking
+	if session != 0 && (blocking || session < timeout) {
+		timeout, timeoutErr = session, ErrSessionExpired
+		blocking = false
+	}
+	var done <-chan struct{} // nil blocks
+	if r.waitCtx != nil {
+		done = r.waitCtx.Done()
+	}
+	closed := r.closed  // nil blocks
+	var began time.Time // wait start, if any
+	defer func() {
+		if !began.IsZero() {
+			d := r.clock.Now().Sub(began)
+			atomic.AddInt64(&r.stats.waited, int64(d))
+			if t := r.loadTrace(); t != nil && t.ReadWaited != nil {
+				t.ReadWaited(d)
+			}
+		}
+	}()
+
+	// ensure data or timeout
+	for buf != nil && r.i >= len(buf) {
+		// data has priority
+		select {
+		case buf = <-r.next:
+			r.swap(buf)
+			continue
+		default:
+			if poll {
+				r.lastWait = WaitReason(atomic.LoadInt32(&r.waitState))
+				return timeoutErr
+			}
+		}
+		// timer only when a wait is due
+		if !blocking && expire == nil {
+			expire = r.resetTimer(timeout)
+		}
+		if began.IsZero() {
+			began = r.clock.Now()
+		}
+
+		select {
+		case <-expire:
+			r.lastWait = WaitReason(atomic.LoadInt32(&r.waitState))
+			return timeoutErr
+
+		case <-done:
+			if expire != nil {
+				r.stopTimer()
+			}
+			return r.waitCtx.Err()
+
+		case <-r.cancel:
+			if expire != nil {
+				r.stopTimer()
+			}
+			return ErrReadCanceled
+
+		case <-closed:
+			if atomic.LoadInt32(&r.isClosed) == 0 {
+				// closed for another cause; error follows
+				closed = nil
+				continue
+			}
+			// source may not interrupt
+			if expire != nil {
+				r.stopTimer()
+			}
+			return ErrClosed
+
+		case buf = <-r.next:
+			r.swap(buf)
+		}
+	}
+
+	if expire != nil {
+		r.stopTimer()
+	}
+
+	if buf == nil {
+		// an error occured
+		err := <-r.err
+		r.err <- err
+		r.surfaced = true
+		return err
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes ready for reads, without blocking.
+// The value is a lower bound, as the read routine may add more at any
+// time. Buffered must be called from the goroutine which reads.
+func (r *Reader) Buffered() int {
+	return int(atomic.LoadInt64(&r.buffered))
+}
+
+// HighWaterMark returns the largest amount of bytes ever buffered at once,
+// i.e., data read from source which was not delivered yet.
+func (r *Reader) HighWaterMark() int {
+	return int(atomic.LoadInt64(&r.highWater))
+}
+
+// queued accounts n bytes read from source.
+func (r *Reader) queued(n int) {
+	v := atomic.AddInt64(&r.buffered, int64(n))
+	for {
+		peak := atomic.LoadInt64(&r.highWater)
+		if v <= peak || atomic.CompareAndSwapInt64(&r.highWater, peak, v) {
+			return
+		}
+	}
+}
+
+// consumed accounts n bytes delivered.
+func (r *Reader) consumed(n int) {
+	if n != 0 {
+		atomic.AddInt64(&r.buffered, -int64(n))
+		if r.drained != nil {
+			r.decayReadahead(n)
+			select {
+			case r.drained <- struct{}{}:
+			default:
+				// signal pending already
+			}
+		}
+		r.pos += int64(n)
+		if r.cp.outstanding != 0 && r.pos != r.cp.journalPos+int64(len(r.cp.journal)) {
+			// consumed without record
+			r.endCheckpoints(errCheckpointLost)
+		}
+	}
+}
+
+// ScanAhead passes the unread data to match, without consuming anything,
+// until match reports found with the offset of its choice. Each "need more"
+// waits at most the timeout for the next read from source, after which
+// match sees the data so far once again, plus the new. ScanAhead fails with
+// ErrBufferFull when the data exceeds the read-ahead of all buffers, or the
+// bound of NewReaderBudget, without a match, with ErrNoData on timeout, and with the sticky error of source,
+// if any. Neither failure consumes any data.
+func (r *Reader) ScanAhead(match func([]byte) (offset int, found bool)) (int, error) {
+	if err := r.await(r.timeout, ErrNoData); err != nil {
+		return 0, err
+	}
+	budget := cap(r.pool) * r.BufferSize()
+
+	for {
+		offset, found := match(r.buf[r.i:])
+		if found {
+			return offset, nil
+		}
+		if len(r.buf)-r.i >= budget || !r.withinBudget(len(r.buf)-r.i+r.BufferSize()) {
+			return 0, ErrBufferFull
+		}
+
+		var expire <-chan time.Time // nil blocks
+		if r.timeout >= 0 {
+			expire = r.resetTimer(r.timeout)
+		}
+		var buf []byte
+		select {
+		case <-expire:
+			return 0, ErrNoData
+		case buf = <-r.next:
+			if expire != nil {
+				r.stopTimer()
+			}
+		}
+		if buf == nil {
+			// an error occured; next remains closed
+			err := <-r.err
+			r.err <- err
+			return 0, err
+		}
+
+		// join into a loose buffer, and recycle both
+		joined := make([]byte, 0, len(r.buf)-r.i+len(buf))
+		joined = append(joined, r.buf[r.i:]...)
+		joined = append(joined, buf...)
+		r.pool <- buf
+		r.swap(joined)
+		r.loose = true
+	}
+}
+
+// Peek returns the next n bytes without consuming them. The slice is
+// valid until the next read or peek. Peek waits for data like ScanAhead
+// does, with ErrNoData on timeout. When the source fails before n bytes,
+// then Peek returns the data available together with the sticky error.
+// Peek fails with ErrBufferFull when n exceeds the read-ahead of all
+// buffers, without any wait.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	if n > cap(r.pool)*r.BufferSize() {
+		return nil, ErrBufferFull
+	}
+	_, err := r.ScanAhead(func(unread []byte) (int, bool) {
+		return n, len(unread) >= n
+	})
+	switch {
+	case err == nil:
+		return r.buf[r.i : r.i+n], nil
+	case err != r.PeekErr(), r.buf == nil:
+		// no source error, e.g., ErrNoData
+		return nil, err
+	}
+	return r.buf[r.i:], err
+}
+
+// Next consumes the next n bytes, and it returns them without copy, i.e.,
+// the slice is a view on the buffers, valid until the next read or peek.
+// Next waits for data like Peek does, with ErrNoData on timeout, in which
+// case nothing is consumed. When the source fails before n bytes, then
+// Next consumes the data available, and it returns them together with the
+// sticky error. Next fails with ErrBufferFull when n exceeds the read-ahead
+// of all buffers, without any wait. A byte from UnreadByte comes first, at
+// the cost of a copy.
+func (r *Reader) Next(n int) ([]byte, error) {
+	if !r.stashed || n == 0 {
+		view, err := r.Peek(n)
+		r.consumeView(view, err)
+		return view, err
+	}
+
+	var view []byte
+	var err error
+	if n > 1 {
+		view, err = r.Peek(n - 1)
+		if view == nil && err != nil && err != r.PeekErr() {
+			return nil, err
+		}
+	}
+	out := append([]byte{r.stash}, view...)
+	r.stashed = false
+	if r.cp.outstanding != 0 {
+		r.record(out[:1])
+	}
+	r.consumed(1)
+	r.last, r.lastOK = r.stash, true
+	r.track(1, nil)
+	r.consumeView(view, err)
+	return out, err
+}
+
+// ReadSlice reads until the first occurrence of delim, like bufio.Reader
+// does. The return includes delim, and it is valid until the next read or
+// peek. ReadSlice waits for data like ScanAhead does. A record which does
+// not complete in time fails with ErrNoData, in which case the partial
+// record remains buffered for the next call. Records which exceed the
+// read-ahead of all buffers fail with ErrBufferFull, without consuming
+// anything either. When the source fails before delim, then ReadSlice
+// returns the data available together with the sticky error.
+func (r *Reader) ReadSlice(delim byte) ([]byte, error) {
+	var held int // unread byte, if any
+	if r.stashed {
+		if r.stash == delim {
+			return r.Next(1)
+		}
+		held = 1
+	}
+
+	offset, err := r.ScanAhead(func(unread []byte) (int, bool) {
+		i := bytes.IndexByte(unread, delim)
+		return i + 1, i >= 0
+	})
+	switch {
+	case err == nil:
+		return r.Next(held + offset)
+	case err != r.PeekErr(), r.buf == nil, held+len(r.buf[r.i:]) == 0:
+		// no source error, e.g., ErrNoData, or no data left
+		r.track(0, err)
+		return nil, err
+	}
+	// remainder before the source error
+	view, _ := r.Next(held + len(r.buf[r.i:]))
+	return view, err
+}
+
+// ReadString is like ReadSlice, yet it returns a copy as a string.
+func (r *Reader) ReadString(delim byte) (string, error) {
+	view, err := r.ReadSlice(delim)
+	return string(view), err
+}
+
+// ConsumeView advances past view, as returned by Peek.
+func (r *Reader) consumeView(view []byte, err error) {
+	if len(view) == 0 {
+		r.track(0, err)
+		return
+	}
+	if r.cp.outstanding != 0 {
+		r.record(view)
+	}
+	r.i += len(view)
+	r.consumed(len(view))
+	r.last, r.lastOK = view[len(view)-1], true
+	r.track(len(view), nil)
+}
+
+// resetTimer arms the timer for d, regardless of its prior state, i.e.,
+// any expiry from before does not show on the return.
+func (r *Reader) resetTimer(d time.Duration) <-chan time.Time {
+	if r.timer == nil {
+		r.timer = r.clock.NewTimer(d)
+	} else {
+		r.stopTimer()
+		r.timer.Reset(d)
+	}
+	return r.timer.C()
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (r *Reader) stopTimer() {
+	if !r.timer.Stop() {
+		select {
+		case <-r.timer.C():
+		default:
+			// received already
+		}
+	}
+}
+
+// swap replaces the current buffer with buf, and it recycles the former.
+// Buffers without capacity are not recycled, nor are loose buffers.
+func (r *Reader) swap(buf []byte) {
+	if cap(r.buf) != 0 && !r.loose {
+		if r.onDemand {
+			r.shared.Put(r.buf[:cap(r.buf)])
+			r.pool <- emptyBuf
+		} else {
+			r.pool <- r.buf
+		}
+	}
+	r.buf = buf
+	r.i = 0
+	r.loose = false
+	if len(buf) != 0 {
+		r.delivered(1)
+	}
+}
+
+// Delivered counts the next n source reads as consumed.
+func (r *Reader) delivered(n int) {
+	l, _ := r.latency.Load().(*latencyRing)
+	for ; n > 0; n-- {
+		r.seq++
+		if l != nil {
+			l.deliver(r.seq)
+		}
+	}
+}
+
+// RecycleConsumed returns the current buffer to the read routine when it
+// is consumed entirely. Read does so before it returns, such that the read
+// routine need not wait on the next Read for a buffer. Other means of
+// consumption may hold on to a buffer for as long as the consumer pauses.
+// The call is a no-op when unread data remains.
+func (r *Reader) RecycleConsumed() {
+	if r.buf != nil && r.i >= len(r.buf) {
+		r.swap(r.buf[:0:0])
+	}
+}
+
+// PushToLossy starts delivery of all data to ch, as an alternative to
+// Read. Each value on ch holds a copy of the data from one source read,
+// in order of arrival. Data which does not fit in ch awaits delivery in a
+// queue of at most maxBuffered entries. When the queue is exceeded, then
+// the oldest entry is dropped, as counted by Dropped. Thus ch receives the
+// most recent data at the expense of older data. Ch is closed once the
+// source failed, and once the queue is delivered. PeekErr has the error.
+// Read must not be used after PushToLossy.
+func (r *Reader) PushToLossy(ch chan<- []byte, maxBuffered int) {
+	var queue [][]byte
+	if r.buf != nil && r.i < len(r.buf) {
+		queue = append(queue, append([]byte(nil), r.buf[r.i:]...))
+	}
+	r.swap(r.buf[:0:0])
+
+	r.Start()
+	go r.pushLossy(ch, maxBuffered, queue)
+}
+
+// PushTo starts delivery of all data to ch, as an alternative to Read.
+// Each value on ch holds the data from one source read, in order of
+// arrival, without copy. The buffers remain property of the Reader. The
+// function returned passes a buffer back for reuse, after which its data
+// is no longer valid. Each buffer must be recycled once exactly, from any
+// goroutine. Pending buffers stall reading when they run out. Ch is closed
+// once the source failed, or on Close. PeekErr has the error. Read must not
+// be used after PushTo.
+func (r *Reader) PushTo(ch chan<- []byte) (recycle func(buf []byte)) {
+	var head []byte
+	if r.buf != nil && r.i < len(r.buf) {
+		// current buffer may be loose, or in use since an offset
+		head = append([]byte(nil), r.buf[r.i:]...)
+		r.consumed(len(head))
+	}
+	r.swap(r.buf[:0:0])
+
+	r.Start()
+	pool := r.pool // may change with Reset
+	go r.push(ch, head)
+	return func(buf []byte) {
+		if cap(buf) == 0 || (head != nil && &buf[:1][0] == &head[0]) {
+			return // not from the pool
+		}
+		pool <- buf[:cap(buf)]
+	}
+}
+
+func (r *Reader) push(ch chan<- []byte, head []byte) {
+	defer close(ch)
+
+	closed := r.closed // nil blocks
+	send := func(buf []byte) bool {
+		for {
+			select {
+			case ch <- buf:
+				return true
+			case <-closed:
+				if atomic.LoadInt32(&r.isClosed) == 0 {
+					// closed for another cause; error follows
+					closed = nil
+					continue
+				}
+				return false
+			}
+		}
+	}
+
+	if head != nil && !send(head) {
+		return
+	}
+	for buf := range r.next {
+		r.consumed(len(buf))
+		if !send(buf) {
+			r.pool <- buf
+			break
+		}
+	}
+	// flush to kill Go routine
+	for buf := range r.next {
+		r.pool <- buf
+	}
+}
+
+// Dropped returns the number of entries discarded by PushToLossy.
+func (r *Reader) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *Reader) pushLossy(ch chan<- []byte, maxBuffered int, queue [][]byte) {
+	next := r.next
+	for {
+		// deliver what ch accepts before any drop
+		for len(queue) != 0 && trySend(ch, queue[0]) {
+			queue = queue[1:]
+		}
+		if next == nil && len(queue) == 0 {
+			break
+		}
+
+		var out chan<- []byte
+		var head []byte
+		if len(queue) != 0 {
+			out = ch
+			head = queue[0]
+		}
+
+		select {
+		case buf, ok := <-next:
+			if !ok {
+				next = nil
+				break
+			}
+			queue = append(queue, append([]byte(nil), buf...))
+			r.consumed(len(buf))
+			r.pool <- buf
+			if len(queue) > maxBuffered {
+				queue = queue[1:]
+				atomic.AddUint64(&r.dropped, 1)
+			}
+
+		case out <- head:
+			queue = queue[1:]
+		}
+	}
+	close(ch)
+}
+
+func trySend(ch chan<- []byte, buf []byte) bool {
+	select {
+	case ch <- buf:
+		return true
+	default:
+		return false
+	}
+}
+
+// Lease hands out the unread data of one source read without copy. The
+// buffer remains property of the Reader. Release returns it for reuse,
+// after which the data is no longer valid. Release must be called once
+// exactly, from any goroutine; a second call panics. Pending leases take
+// buffers out of the cycle, which stalls reading when they run out. A
+// timeout gives ErrNoData as usual, and errors are sticky as with Read.
+func (r *Reader) Lease() (buf []byte, release func(), err error) {
+	if err := r.await(r.timeout, ErrNoData); err != nil {
+		r.track(0, err)
+		return nil, nil, err
+	}
+
+	buf = r.buf[r.i:]
+	r.consumed(len(buf))
+	leased := r.buf
+	if r.loose {
+		leased = nil
+	}
+	// ownership moves to release
+	r.buf = r.buf[:0:0]
+	r.i = 0
+	r.loose = false
+
+	var released int32
+	pool := r.pool // may change with Reset
+	release = func() {
+		if atomic.AddInt32(&released, 1) != 1 {
+			panic("nbio: lease released more than once")
+		}
+		if leased != nil {
+			pool <- leased
+		}
+	}
+	r.track(len(buf), nil)
+	return buf, release, nil
+}
+
+// errExported is the sticky error after ExportState.
+var errExported = errors.New("reader state exported")
+
+// ExportState stops reading, and it returns any data which is buffered
+// yet unread, in order. The source must have a SetReadDeadline method,
+// such as net.Conn, with which it is interrupted. The deadline is cleared
+// on return, as the source is meant to be passed elsewhere, including the
+// return, e.g., to NewReaderWithPrefix in another process. No read may be
+// in progress during the call. Any read afterwards fails.
+func (r *Reader) ExportState() ([]byte, error) {
+	d, ok := r.r.(deadliner)
+	if !ok {
+		return nil, errors.New("source without SetReadDeadline can not stop reading")
+	}
+	state, err := r.stop(d)
+	if err != nil {
+		return nil, err
+	}
+	r.fatal = errExported
+
+	return state, d.SetReadDeadline(r.deadline)
+}
+
+// Deadliner is the read deadline of net.Conn and os.File.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// Stop interrupts the source with a read deadline in the past, and it
+// returns any data buffered yet unread, in order. The read routine is
+// terminated on return. The deadline remains.
+func (r *Reader) stop(d deadliner) ([]byte, error) {
+	// the deadline error is no cause for WithRetry
+	atomic.StoreInt32(&r.halting, 1)
+	if err := d.SetReadDeadline(time.Unix(1, 0)); err != nil {
+		return nil, err
+	}
+	r.startOnce.Do(r.noRoutine)
+
+	var state []byte
+	if r.buf != nil {
+		state = append(state, r.buf[r.i:]...)
+		r.i = len(r.buf)
+	}
+	for buf := range r.next {
+		state = append(state, buf...)
+		r.pool <- buf
+	}
+	r.consumed(len(state))
+	return state, nil
+}
+
+// Seek implements the io.Seeker interface. Sources with io.Seeker support
+// have their offsets in effect, i.e., the position in source of the next
+// byte to read, regardless of where source was at on construction, and
+// regardless of any prefix from NewReaderWithPrefix, or any magic from
+// NewReaderMagic. Their first seek goes to the source, which invalidates
+// all buffered data, as do all seeks outside of the current buffer. Seeks
+// within the current buffer just skip from then on. A pending io.EOF is
+// cleared on seeks which go to the source. Sources without io.Seeker count
+// the bytes delivered instead, and they support forward seeks only, by
+// discarding data, which fails with ErrNoData on timeout, like Read does.
+// Backward seeks and io.SeekEnd fail with ErrUnsupported in such case.
+// Seek waits for any leased buffers to be released.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.fatal != nil {
+		return r.pos, r.fatal
+	}
+	abs := offset
+	switch whence {
+	case io.SeekStart:
+		break
+	case io.SeekCurrent:
+		abs += r.pos
+	case io.SeekEnd:
+		abs = -1
+	default:
+		return r.pos, errors.New("invalid whence")
+	}
+
+	seeker, ok := r.r.(io.Seeker)
+	ahead := abs - r.pos
+	if (!ok || r.srcPos) && whence != io.SeekEnd && ahead >= 0 && r.buf != nil && ahead <= int64(len(r.buf)-r.i) {
+		r.i += int(ahead)
+		r.consumed(int(ahead))
+		return r.pos, nil
+	}
+
+	if !ok {
+		if whence == io.SeekEnd || ahead < 0 {
+			return r.pos, ErrUnsupported
+		}
+		return r.discard(ahead)
+	}
+	if whence == io.SeekStart && abs < 0 {
+		return r.pos, errors.New("negative position")
+	}
+
+	r.Start()
+	resume := make(chan struct{})
+	defer close(resume)
+	discarded, running := r.halt(resume)
+	if whence == io.SeekCurrent {
+		// source is past the data which was not delivered
+		offset -= discarded
+	}
+	if !running {
+		// read routine terminated
+		err := <-r.err
+		if err != io.EOF {
+			r.err <- err
+			return r.pos, err
+		}
+		r.failed.Store(errorBox{})
+		r.surfaced = false
+		r.next = r.makeNext()
+		defer func() { go r.readRoutine() }()
+	}
+
+	pos, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return r.pos, err
+	}
+	r.pos = pos
+	r.srcPos = true
+	return pos, nil
+}
+
+// Halt gets the read routine to wait for resume to close, with any data
+// buffered discarded. The return has the number of bytes discarded, and
+// whether the routine is still running, i.e., false when it terminated.
+func (r *Reader) halt(resume chan struct{}) (discarded int64, running bool) {
+	if r.buf == nil {
+		// next closed
+		r.buf = emptyBuf
+		return 0, false
+	}
+	discarded = int64(len(r.buf) - r.i)
+	atomic.AddInt64(&r.buffered, -discarded)
+	r.swap(r.buf[:0:0])
+
+	for {
+		select {
+		case r.pause <- resume:
+			// collect what was sent before the pause
+			for {
+				select {
+				case buf := <-r.next:
+					discarded += int64(len(buf))
+					atomic.AddInt64(&r.buffered, -int64(len(buf)))
+					r.seq++
+					r.pool <- buf
+				default:
+					return discarded, true
+				}
+			}
+
+		case buf, ok := <-r.next:
+			if !ok {
+				return discarded, false
+			}
+			discarded += int64(len(buf))
+			atomic.AddInt64(&r.buffered, -int64(len(buf)))
+			r.seq++
+			r.pool <- buf
+		}
+	}
+}
+
+// discard skips n bytes of data.
+func (r *Reader) discard(n int64) (int64, error) {
+	for n != 0 {
+		if err := r.await(r.timeout, ErrNoData); err != nil {
+			return r.pos, err
+		}
+		skip := len(r.buf) - r.i
+		if int64(skip) > n {
+			skip = int(n)
+		}
+		r.i += skip
+		r.consumed(skip)
+		n -= int64(skip)
+	}
+	return r.pos, nil
+}
+
+// Unwrap returns the current source, for access to its methods. Reads on
+// the source conflict with the read routine, which makes them unsafe. The
+// source changes with UpgradeTLS and SetSource.
+func (r *Reader) Unwrap() io.ReadCloser {
+	return r.r
+}
+
+// NetConn returns the source as a net.Conn, if it is one.
+func (r *Reader) NetConn() (net.Conn, bool) {
+	conn, ok := r.r.(net.Conn)
+	return conn, ok
+}
+
+// File returns the source as an *os.File, if it is one.
+func (r *Reader) File() (*os.File, bool) {
+	f, ok := r.r.(*os.File)
+	return f, ok
+}
@@ -1,21 +1,27 @@
 package nbio
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
+	"net"
+	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/iotest"
 	"time"
+	"unicode/utf8"
 )
 
 // Feed is a data sample.
 const feed = "Hello World!"
 
 // ReadRoutineStackEl is assumed present in the Go routine stack trace.
-const readRoutineStackEl = "NewReader"
+const readRoutineStackEl = "readRoutine"
 
 // Non blocking Reader must indicate no-data and recover.
 func TestReadWithPause(t *testing.T) {
@@ -132,3 +138,2887 @@ func stackDump() string {
 	n := runtime.Stack(buf, true)
 	return string(buf[:n])
 }
+
+// Non blocking Reader must report state transitions only.
+func TestReadOnActive(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	var got []bool
+	r.OnActive(func(active bool) {
+		got = append(got, active)
+	})
+
+	buf := make([]byte, len(feed))
+	r.Read(buf) // idle from the start
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	r.Read(buf[:1])
+	r.Read(buf[1:]) // still active
+	r.Read(buf)
+	r.Read(buf) // still idle
+
+	want := []bool{true, false}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got transitions %t, want %t", got, want)
+	}
+}
+
+// Non blocking Reader must not lose data on early recycling.
+func TestReadRecycleConsumed(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf[:5]); n != 5 || err != nil {
+		t.Fatalf("head: Read = (%d, %v), want (5, <nil>)", n, err)
+	}
+	r.RecycleConsumed() // no-op with pending data
+	if n, err := r.Read(buf[5:]); n != len(feed)-5 || err != nil {
+		t.Fatalf("tail: Read = (%d, %v), want (%d, <nil>)", n, err, len(feed)-5)
+	}
+	if got := string(buf); got != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+
+	r.RecycleConsumed()
+	r.RecycleConsumed() // no-op without buffer
+
+	go pw.Write([]byte(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Fatalf("after recycle: Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if got := string(buf); got != feed {
+		t.Errorf("after recycle: got %q, want %q", got, feed)
+	}
+}
+
+// Non blocking Reader must not merge source reads on request.
+func TestReadPreserveBoundaries(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+	r.PreserveBoundaries(true)
+
+	// each pipe write is read from source as is
+	go func() {
+		pw.Write([]byte(feed[:5]))
+		pw.Write([]byte(feed[5:]))
+	}()
+	time.Sleep(9 * time.Millisecond)
+
+	buf := make([]byte, 3*len(feed))
+	for _, step := range []struct {
+		size int
+		want string
+	}{
+		{3, feed[:3]}, // partial record
+		{len(buf), feed[3:5]},
+		{len(buf), feed[5:]},
+	} {
+		n, err := r.Read(buf[:step.size])
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if got := string(buf[:n]); got != step.want {
+			t.Errorf("got %q, want %q", got, step.want)
+		}
+	}
+}
+
+// Non blocking Reader must expose the error ahead of pending data.
+func TestReadPeekErr(t *testing.T) {
+	r := NewReader(errCloser{iotest.DataErrReader(strings.NewReader(feed))}, time.Hour)
+	defer r.Close()
+
+	// ensure read routine completed
+	time.Sleep(9 * time.Millisecond)
+	if err := r.PeekErr(); err != io.EOF {
+		t.Errorf("PeekErr got %v, want %v", err, io.EOF)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read after drain = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if err := r.PeekErr(); err != io.EOF {
+		t.Errorf("PeekErr got %v after drain, want %v", err, io.EOF)
+	}
+}
+
+// Non blocking Reader must distinguish the timeout from the deadline.
+func TestReadBoth(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	// timeout first
+	r := NewReader(pr, 9*time.Millisecond)
+	buf := make([]byte, len(feed))
+	if n, err := r.ReadBoth(time.Now().Add(time.Hour), buf); n != 0 || err != ErrNoData {
+		t.Errorf("timeout first: ReadBoth = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	go pw.Write([]byte(feed))
+	if n, err := r.ReadBoth(time.Now().Add(time.Hour), buf); n != len(feed) || err != nil {
+		t.Errorf("recover: ReadBoth = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	r.Close()
+
+	// deadline first
+	pr, pw = io.Pipe()
+	defer pw.Close()
+	r = NewReader(pr, time.Hour)
+	defer r.Close()
+	start := time.Now()
+	if n, err := r.ReadBoth(start.Add(9*time.Millisecond), buf); n != 0 || err != ErrDeadlineExceeded {
+		t.Errorf("deadline first: ReadBoth = (%d, %v), want (0, %v)", n, err, ErrDeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("deadline first: took %s", d)
+	}
+	if n, err := r.ReadBoth(start, buf); n != 0 || err != ErrDeadlineExceeded {
+		t.Errorf("deadline passed: ReadBoth = (%d, %v), want (0, %v)", n, err, ErrDeadlineExceeded)
+	}
+	go pw.Write([]byte(feed))
+	if n, err := r.ReadBoth(time.Now().Add(time.Second), buf); n != len(feed) || err != nil {
+		t.Errorf("recover: ReadBoth = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
+
+// MessageSource gives an io.EOF for each empty message.
+type messageSource struct {
+	msgs []string
+}
+
+func (s *messageSource) Read(p []byte) (int, error) {
+	if len(s.msgs) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if s.msgs[0] == "" {
+		s.msgs = s.msgs[1:]
+		return 0, io.EOF
+	}
+	n := copy(p, s.msgs[0])
+	s.msgs[0] = s.msgs[0][n:]
+	if s.msgs[0] == "" {
+		s.msgs = s.msgs[1:]
+	}
+	return n, nil
+}
+
+func (s *messageSource) Close() error { return nil }
+
+// Non blocking Reader must continue after EOF on request.
+func TestReadReArmAfterEOF(t *testing.T) {
+	r := NewReader(&messageSource{msgs: []string{feed[:5], "", feed[5:], ""}}, time.Hour)
+	defer r.Close()
+
+	if err := r.ReArmAfterEOF(); err == nil {
+		t.Error("ReArmAfterEOF before EOF got no error")
+	}
+
+	for _, want := range []string{feed[:5], feed[5:]} {
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal("read error:", err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if err := r.ReArmAfterEOF(); err != nil {
+			t.Fatal("ReArmAfterEOF error:", err)
+		}
+	}
+
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("got read error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := r.ReArmAfterEOF(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReArmAfterEOF got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// Non blocking Reader must continue after a cleared error.
+func TestReadClearErr(t *testing.T) {
+	r := NewReader(&stepSource{{data: "ab", err: errTransient}, {data: "cd"}}, time.Second)
+	defer r.Close()
+
+	if err := r.ClearErr(); err == nil {
+		t.Error("ClearErr before error got no error")
+	}
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); n != 2 || err != nil || string(buf[:n]) != "ab" {
+		t.Errorf("Read = (%d, %v) %q, want (2, <nil>) \"ab\"", n, err, buf[:n])
+	}
+	if n, err := r.Read(buf); n != 0 || err != errTransient {
+		t.Fatalf("Read = (%d, %v), want (0, %v)", n, err, errTransient)
+	}
+
+	if err := r.ClearErr(); err != nil {
+		t.Fatal("ClearErr error:", err)
+	}
+	if err := r.PeekErr(); err != nil {
+		t.Errorf("PeekErr after ClearErr got %v", err)
+	}
+	if n, err := r.Read(buf); n != 2 || err != nil || string(buf[:n]) != "cd" {
+		t.Errorf("Read after ClearErr = (%d, %v) %q, want (2, <nil>) \"cd\"", n, err, buf[:n])
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+}
+
+// Non blocking Reader must not re-arm on data which ran into EOF.
+func TestReadReArmAfterDataEOF(t *testing.T) {
+	r := NewReader(&messageSource{msgs: []string{feed[:5], "", feed[5:], ""}}, time.Hour)
+	defer r.Close()
+
+	// ensure EOF pending behind the data
+	time.Sleep(9 * time.Millisecond)
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 5 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (5, <nil>)", n, err)
+	}
+	if err := r.ReArmAfterEOF(); err == nil {
+		t.Error("ReArmAfterEOF before EOF from Read got no error")
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+	if err := r.ReArmAfterEOF(); err != nil {
+		t.Fatal("ReArmAfterEOF error:", err)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != feed[5:] {
+		t.Errorf("got %q, want %q", got, feed[5:])
+	}
+}
+
+// Non blocking Reader must not exceed the allocation budget.
+func TestReaderBudget(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	if _, err := NewReaderBudget(pr, time.Hour, 3*2048-1); err == nil {
+		t.Error("budget below buffer size got no error")
+	}
+
+	r, err := NewReaderBudget(pr, 9*time.Millisecond, 3*2048)
+	if err != nil {
+		t.Fatal("budget of buffer size got error:", err)
+	}
+	defer r.Close()
+	go pw.Write([]byte(feed))
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
+
+// Non blocking Reader must not join data past the allocation budget.
+func TestReaderBudgetJoin(t *testing.T) {
+	for _, budget := range []int{3 * 2048, 5 * 2048} {
+		pr, pw := io.Pipe()
+		r, err := NewReaderBudget(pr, time.Second, budget)
+		if err != nil {
+			t.Fatal("NewReaderBudget error:", err)
+		}
+		go func() {
+			pw.Write([]byte("ab"))
+			pw.Write([]byte("cd"))
+		}()
+
+		got, err := r.Peek(4)
+		if budget == 3*2048 {
+			if err != ErrBufferFull {
+				t.Errorf("budget %d: Peek got error %v, want %v", budget, err, ErrBufferFull)
+			}
+		} else if string(got) != "abcd" || err != nil {
+			t.Errorf("budget %d: Peek = (%q, %v), want (%q, <nil>)", budget, got, err, "abcd")
+		}
+
+		// fail consumes nothing
+		buf := make([]byte, 2)
+		if n, err := r.Read(buf); string(buf[:n]) != "ab" || err != nil {
+			t.Errorf("budget %d: Read = (%q, %v), want (%q, <nil>)", budget, buf[:n], err, "ab")
+		}
+
+		// bound stays on Reset
+		r.Reset(&stepSource{{data: "ab"}, {data: "cd"}}, time.Second)
+		if r.budget != budget {
+			t.Errorf("budget %d: got %d after Reset", budget, r.budget)
+		}
+		r.Close()
+		pw.Close()
+	}
+}
+
+// StepSource returns each step from Read in order.
+type stepSource []struct {
+	data string
+	err  error
+}
+
+func (s *stepSource) Read(p []byte) (int, error) {
+	if len(*s) == 0 {
+		return 0, io.EOF
+	}
+	step := (*s)[0]
+	*s = (*s)[1:]
+	return copy(p, step.data), step.err
+}
+
+func (s *stepSource) Close() error { return nil }
+
+// GateSource blocks reads until the gate closes.
+type gateSource struct {
+	gate <-chan struct{}
+	io.ReadCloser
+}
+
+func (s gateSource) Read(p []byte) (int, error) {
+	<-s.gate
+	return s.ReadCloser.Read(p)
+}
+
+var errTransient = errors.New("transient error test")
+
+var errMapped = errors.New("mapped error test")
+
+// Non blocking Reader must apply the error mapping before sticking.
+func TestReadMapError(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+	r.MapError(func(err error) error {
+		switch err {
+		case errTransient:
+			return nil
+		case errOnClose:
+			return errMapped
+		}
+		return err
+	})
+
+	go func() {
+		pw.Write([]byte(feed))
+		pw.CloseWithError(errOnClose)
+	}()
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if n, err := r.Read(buf); n != 0 || err != errMapped {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, errMapped)
+	}
+	if err := r.PeekErr(); err != errMapped {
+		t.Errorf("PeekErr got %v, want %v", err, errMapped)
+	}
+}
+
+// Non blocking Reader must continue on errors mapped to nil.
+func TestReadMapErrorIgnore(t *testing.T) {
+	source := &stepSource{
+		{feed[:5], errTransient},
+		{"", errTransient},
+		{feed[5:], nil},
+	}
+	start := make(chan struct{})
+	r := NewReader(gateSource{start, source}, time.Hour)
+	defer r.Close()
+	r.MapError(func(err error) error {
+		if err == errTransient {
+			return nil
+		}
+		return err
+	})
+	close(start)
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+}
+
+// Non blocking Reader must drop the oldest data when push is congested.
+func TestPushToLossy(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	ch := make(chan []byte, 1)
+	r.PushToLossy(ch, 2)
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		pw.Write([]byte(s))
+	}
+	pw.Close()
+	time.Sleep(9 * time.Millisecond) // queue all
+
+	var got []string
+	for buf := range ch {
+		got = append(got, string(buf))
+	}
+	if want := []string{"a", "d", "e"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := r.Dropped(); n != 2 {
+		t.Errorf("got %d dropped, want 2", n)
+	}
+	if err := r.PeekErr(); err != io.EOF {
+		t.Errorf("PeekErr got %v, want %v", err, io.EOF)
+	}
+}
+
+// Non blocking Reader must push its buffers without loss.
+func TestPushTo(t *testing.T) {
+	payload := make([]byte, 100*minBufferSize)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	r := NewReaderSize(errCloser{bytes.NewReader(payload)}, time.Second, minBufferSize)
+	defer r.Close()
+
+	head := make([]byte, 10)
+	if n, err := r.Read(head); n != len(head) || err != nil {
+		t.Fatalf("Read = (%d, %v), want (%d, <nil>)", n, err, len(head))
+	}
+
+	ch := make(chan []byte)
+	recycle := r.PushTo(ch)
+	got := append([]byte(nil), head...)
+	for buf := range ch {
+		got = append(got, buf...)
+		recycle(buf)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", len(got), len(payload))
+	}
+	if err := r.PeekErr(); err != io.EOF {
+		t.Errorf("PeekErr got %v, want %v", err, io.EOF)
+	}
+}
+
+// Non blocking Reader must end push on Close.
+func TestPushToClose(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	ch := make(chan []byte)
+	r.PushTo(ch)
+	<-ch // no recycle
+
+	r.Close()
+	for range ch {
+		// buffers in transit
+	}
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Non blocking Reader must step over buffered data on request.
+func TestStepRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 99*time.Millisecond)
+	defer r.Close()
+
+	go func() {
+		pw.Write([]byte(feed[:5]))
+		pw.Write([]byte(feed[5:7]))
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte(feed[7:]))
+		pw.Write([]byte(feed))
+	}()
+	time.Sleep(time.Millisecond)
+
+	buf := make([]byte, 2*len(feed))
+	if n, err := r.StepRead(buf); err != nil {
+		t.Fatal("StepRead error:", err)
+	} else if got := string(buf[:n]); got != feed[7:] {
+		t.Errorf("got %q, want %q", got, feed[7:])
+	}
+	if n, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	} else if got := string(buf[:n]); got != feed {
+		t.Errorf("got %q after step, want %q", got, feed)
+	}
+
+	if n, err := r.StepRead(buf); n != 0 || err != ErrNoData {
+		t.Errorf("StepRead = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+}
+
+// Non blocking Reader must fail terminally after the deadline.
+func TestReaderDeadline(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	deadline := time.Now().Add(99 * time.Millisecond)
+	r := NewReaderDeadline(conn, 9*time.Millisecond, deadline)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("idle: Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	peer.Write([]byte(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	go peer.Write([]byte(feed))
+	time.Sleep(time.Until(deadline))
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrDeadlineExceeded {
+			t.Errorf("expired: Read = (%d, %v), want (0, %v)", n, err, ErrDeadlineExceeded)
+		}
+	}
+
+	time.Sleep(9 * time.Millisecond) // stop read routine
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Non blocking Reader must lease buffers exactly once.
+func TestLease(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	if buf, release, err := r.Lease(); buf != nil || release != nil || err != ErrNoData {
+		t.Errorf("idle: Lease got error %v, want %v", err, ErrNoData)
+	}
+
+	// more leases than buffers
+	for i := 0; i < 7; i++ {
+		go pw.Write([]byte(feed))
+		buf, release, err := r.Lease()
+		if err != nil {
+			t.Fatal("Lease error:", err)
+		}
+		if string(buf) != feed {
+			t.Errorf("lease %d: got %q, want %q", i, buf, feed)
+		}
+		release()
+	}
+
+	go pw.Write([]byte(feed))
+	_, release, err := r.Lease()
+	if err != nil {
+		t.Fatal("Lease error:", err)
+	}
+	release()
+	defer func() {
+		if recover() == nil {
+			t.Error("second release did not panic")
+		}
+	}()
+	release()
+}
+
+var errInjected = errors.New("injected error test")
+
+// Non blocking Reader must fail with an injected error after pending data.
+func TestInjectErr(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	r.InjectErr(errInjected)
+	if err := r.PeekErr(); err != errInjected {
+		t.Errorf("PeekErr got %v, want %v", err, errInjected)
+	}
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf[:5]); n != 5 || err != nil {
+		t.Errorf("Read = (%d, %v), want (5, <nil>)", n, err)
+	}
+	if n, err := r.Read(buf[5:]); n != len(feed)-5 || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed)-5)
+	}
+	go pw.Write([]byte(feed))
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != errInjected {
+			t.Errorf("Read = (%d, %v), want (0, %v)", n, err, errInjected)
+		}
+		time.Sleep(9 * time.Millisecond)
+	}
+}
+
+// Non blocking Reader must stop the read routine on context cancellation.
+func TestReaderCtxCancel(t *testing.T) {
+	for _, withDeadline := range []bool{true, false} {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		var source io.ReadCloser = conn
+		if !withDeadline {
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			source = pr
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		r := NewReaderCtxCancel(ctx, source, time.Hour)
+		defer r.Close()
+
+		// ensure read routine blocked on source
+		time.Sleep(9 * time.Millisecond)
+		cancel()
+
+		buf := make([]byte, len(feed))
+		if n, err := r.Read(buf); n != 0 || err != context.Canceled {
+			t.Errorf("deadline %t: Read = (%d, %v), want (0, %v)", withDeadline, n, err, context.Canceled)
+		}
+		time.Sleep(time.Millisecond)
+		if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+			t.Errorf("deadline %t: read routine element %q still present in:\n%s", withDeadline, readRoutineStackEl, dump)
+		}
+	}
+}
+
+// Non blocking Reader must follow the source read size within bounds.
+func TestAutoSizeReader(t *testing.T) {
+	// full reads
+	payload := strings.Repeat(feed, 100000)
+	r := NewAutoSizeReader(errCloser{strings.NewReader(payload)}, time.Hour, 512, 64*1024)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != payload {
+		t.Error("payload mismatch")
+	}
+	if n := r.BufferSize(); n != 64*1024 {
+		t.Errorf("got buffer size %d after full reads, want %d", n, 64*1024)
+	}
+	r.Close()
+
+	// small reads
+	source := make(stepSource, 100)
+	for i := range source {
+		source[i].data = feed
+	}
+	r = NewAutoSizeReader(&source, time.Hour, 512, 64*1024)
+	defer r.Close()
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != strings.Repeat(feed, 100) {
+		t.Errorf("got %q, want %d times %q", got, 100, feed)
+	}
+	if n := r.BufferSize(); n != 512 {
+		t.Errorf("got buffer size %d after small reads, want 512", n)
+	}
+}
+
+// Non blocking Reader must verify the magic bytes across source reads.
+func TestReaderMagic(t *testing.T) {
+	golden := []struct {
+		steps   []string
+		deliver bool
+		want    string
+		err     error
+	}{
+		{[]string{"Hel", "lo World!"}, false, " World!", nil},
+		{[]string{"H", "e", "llo World!"}, true, feed, nil},
+		{[]string{"Hello"}, false, "", nil},
+		{[]string{"Help"}, false, "", ErrBadMagic},
+		{[]string{"He", "lp"}, true, "", ErrBadMagic},
+	}
+	for _, gold := range golden {
+		source := make(stepSource, len(gold.steps))
+		for i, s := range gold.steps {
+			source[i].data = s
+		}
+		r := NewReaderMagic(&source, time.Hour, []byte("Hello"), gold.deliver)
+		got, err := ioutil.ReadAll(r)
+		if err != gold.err {
+			t.Errorf("%q: got error %v, want %v", gold.steps, err, gold.err)
+		}
+		if string(got) != gold.want {
+			t.Errorf("%q: got %q, want %q", gold.steps, got, gold.want)
+		}
+		r.Close()
+	}
+}
+
+// CountSource counts the reads.
+type countSource struct {
+	reads int32
+	io.ReadCloser
+}
+
+func (s *countSource) Read(p []byte) (int, error) {
+	atomic.AddInt32(&s.reads, 1)
+	return s.ReadCloser.Read(p)
+}
+
+// Non blocking Reader must not read from source before use in lazy mode.
+func TestLazyReader(t *testing.T) {
+	source := &countSource{ReadCloser: errCloser{strings.NewReader(feed)}}
+	r := NewLazyReader(source, time.Hour)
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if n := atomic.LoadInt32(&source.reads); n != 0 {
+		t.Errorf("got %d source reads before Read, want none", n)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+
+	// explicit start
+	source = &countSource{ReadCloser: errCloser{strings.NewReader(feed)}}
+	r = NewLazyReader(source, time.Hour)
+	defer r.Close()
+	r.Start()
+	r.Start()
+	time.Sleep(9 * time.Millisecond)
+	if n := atomic.LoadInt32(&source.reads); n == 0 {
+		t.Error("got no source reads after Start")
+	}
+
+	// close unused
+	r = NewLazyReader(errCloser{strings.NewReader(feed)}, time.Hour)
+	if err := r.Close(); err != errOnClose {
+		t.Errorf("got close error %v, want %v", err, errOnClose)
+	}
+}
+
+// Non blocking Reader must give up on a silent source.
+func TestReaderMaxTimeouts(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReaderMaxTimeouts(pr, time.Millisecond, 3)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+			t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+		}
+	}
+	go pw.Write([]byte(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	// permanently silent
+	for i := 0; i < 3; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+			t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+		}
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrTooManyTimeouts {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrTooManyTimeouts)
+	}
+
+	time.Sleep(time.Millisecond)
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Non blocking Reader must hand over unread data to a successor.
+func TestExportState(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	r := NewReader(conn, time.Second)
+
+	go peer.Write([]byte(feed))
+	buf := make([]byte, 5)
+	if n, err := r.Read(buf); n != 5 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (5, <nil>)", n, err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	state, err := r.ExportState()
+	if err != nil {
+		t.Fatal("ExportState error:", err)
+	}
+	if string(state) != feed[5:] {
+		t.Errorf("got state %q, want %q", state, feed[5:])
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Error("Read after ExportState got no error")
+	}
+
+	r = NewReaderWithPrefix(state, conn, time.Second)
+	defer r.Close()
+	go peer.Write([]byte(feed))
+	got := make([]byte, len(state)+len(feed))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal("read error:", err)
+	}
+	if want := feed[5:] + feed; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Non blocking Reader must refuse export without means to interrupt.
+func TestExportStateUnsupported(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+	if _, err := r.ExportState(); err == nil {
+		t.Error("ExportState on pipe got no error")
+	}
+}
+
+// Non blocking Reader must fill silence with heartbeats.
+func TestHeartbeat(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewHeartbeatReader(pr, 5*time.Millisecond, 20*time.Millisecond, []byte("♥"))
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "♥" || !r.IsHeartbeat() {
+		t.Errorf("Read = (%d, %v) %q heartbeat %t, want the heartbeat", n, err, buf[:n], r.IsHeartbeat())
+	}
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	n, err = r.Read(buf)
+	if err != nil || string(buf[:n]) != feed || r.IsHeartbeat() {
+		t.Errorf("Read = (%d, %v) %q heartbeat %t, want the feed", n, err, buf[:n], r.IsHeartbeat())
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read after data = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+}
+
+// Non blocking Reader must scan across source reads without consuming.
+func TestScanAhead(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World"}, {data: "!\nnext"}}, time.Second)
+	defer r.Close()
+
+	var calls int
+	offset, err := r.ScanAhead(func(p []byte) (int, bool) {
+		calls++
+		i := strings.IndexByte(string(p), '\n')
+		return i, i >= 0
+	})
+	if err != nil || offset != len(feed) {
+		t.Fatalf("ScanAhead = (%d, %v), want (%d, <nil>)", offset, err, len(feed))
+	}
+	if calls != 3 {
+		t.Errorf("got %d match calls, want 3", calls)
+	}
+
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(r, buf[:len(feed)+5])
+	if err != nil || string(buf[:n]) != feed+"\nnext" {
+		t.Errorf("Read = (%d, %v) %q, want %q", n, err, buf[:n], feed+"\nnext")
+	}
+}
+
+// Non blocking Reader must limit the scan to its read-ahead.
+func TestScanAheadBufferFull(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 4*bufferSize))), time.Second)
+	defer r.Close()
+
+	_, err := r.ScanAhead(func(p []byte) (int, bool) { return 0, false })
+	if err != ErrBufferFull {
+		t.Fatalf("ScanAhead got error %v, want ErrBufferFull", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if len(got) != 4*bufferSize {
+		t.Errorf("got %d bytes after ScanAhead, want %d", len(got), 4*bufferSize)
+	}
+}
+
+// Non blocking Reader must combine the last data with EOF on request.
+func TestDeliverEOFWithData(t *testing.T) {
+	for _, combine := range []bool{false, true} {
+		r := NewReader(&stepSource{{data: feed}}, time.Second)
+		r.DeliverEOFWithData(combine)
+		time.Sleep(9 * time.Millisecond)
+
+		buf := make([]byte, 64)
+		n, err := r.Read(buf)
+		if string(buf[:n]) != feed {
+			t.Errorf("combine %t: got %q, want %q", combine, buf[:n], feed)
+		}
+		if combine {
+			if err != io.EOF {
+				t.Errorf("combine %t: got error %v, want io.EOF", combine, err)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("combine %t: got error %v", combine, err)
+			}
+			if n, err := r.Read(buf); n != 0 || err != io.EOF {
+				t.Errorf("combine %t: Read after data = (%d, %v), want (0, io.EOF)", combine, n, err)
+			}
+		}
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("combine %t: final Read = (%d, %v), want (0, io.EOF)", combine, n, err)
+		}
+		r.Close()
+	}
+}
+
+// Non blocking Reader must track the peak of data buffered.
+func TestHighWaterMark(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World!"}}, time.Second)
+	defer r.Close()
+	time.Sleep(9 * time.Millisecond)
+
+	// one buffer in next, and one pending send
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf[:1]); n != 1 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (1, <nil>)", n, err)
+	}
+	time.Sleep(9 * time.Millisecond)
+	if got := r.HighWaterMark(); got != len(feed) {
+		t.Errorf("got high-water mark %d, want %d", got, len(feed))
+	}
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal("read error:", err)
+	}
+	if got := r.HighWaterMark(); got != len(feed) {
+		t.Errorf("got high-water mark %d after drain, want %d", got, len(feed))
+	}
+}
+
+// Non blocking Reader must resume block fills after a timeout.
+func TestReadBlocks(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	blocks := [][]byte{make([]byte, 4), make([]byte, 4), make([]byte, 4)}
+	go pw.Write([]byte(feed[:6]))
+	if n, err := r.ReadBlocks(blocks); n != 1 || err != ErrNoData {
+		t.Fatalf("ReadBlocks = (%d, %v), want (1, ErrNoData)", n, err)
+	}
+
+	go pw.Write([]byte(feed[6:]))
+	if n, err := r.ReadBlocks(blocks); n != 3 || err != nil {
+		t.Fatalf("ReadBlocks = (%d, %v), want (3, <nil>)", n, err)
+	}
+	for i, want := range []string{"Hell", "o Wo", "rld!"} {
+		if got := string(blocks[i]); got != want {
+			t.Errorf("block %d got %q, want %q", i, got, want)
+		}
+	}
+}
+
+// Non blocking Reader must tell who stalls on timeout.
+func TestLastWaitReason(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	if _, err := r.Read(buf); err != ErrNoData {
+		t.Fatalf("Read got error %v, want ErrNoData", err)
+	}
+	if got := r.LastWaitReason(); got != WaitSource {
+		t.Errorf("got wait reason %q, want %q", got, WaitSource)
+	}
+
+	// hold on to all buffers
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		go pw.Write([]byte(feed))
+		_, release, err := r.Lease()
+		if err != nil {
+			t.Fatal("Lease error:", err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := r.Read(buf); err != ErrNoData {
+		t.Fatalf("Read got error %v, want ErrNoData", err)
+	}
+	if got := r.LastWaitReason(); got != WaitPool {
+		t.Errorf("got wait reason %q, want %q", got, WaitPool)
+	}
+	for _, release := range releases {
+		release()
+	}
+
+	go pw.Write([]byte(feed))
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	}
+	if got := r.LastWaitReason(); got != WaitNone {
+		t.Errorf("got wait reason %q, want %q", got, WaitNone)
+	}
+}
+
+// SeekSource is a source with io.Seeker support.
+type seekSource struct{ *strings.Reader }
+
+func (s seekSource) Close() error { return nil }
+
+// Non blocking Reader must seek the source when supported.
+func TestSeekSource(t *testing.T) {
+	r := NewReader(seekSource{strings.NewReader(feed)}, time.Second)
+	defer r.Close()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal("read error:", err)
+	}
+	if pos, err := r.Seek(0, io.SeekCurrent); pos != 5 || err != nil {
+		t.Errorf("Seek current = (%d, %v), want (5, <nil>)", pos, err)
+	}
+	if pos, err := r.Seek(-5, io.SeekCurrent); pos != 0 || err != nil {
+		t.Errorf("Seek back = (%d, %v), want (0, <nil>)", pos, err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != feed {
+		t.Errorf("got %q, %v after Seek, want %q", got, err, feed)
+	}
+
+	// seek after EOF
+	if pos, err := r.Seek(-6, io.SeekEnd); pos != 6 || err != nil {
+		t.Errorf("Seek end = (%d, %v), want (6, <nil>)", pos, err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil || string(got) != feed[6:] {
+		t.Errorf("got %q, %v after Seek, want %q", got, err, feed[6:])
+	}
+}
+
+// Non blocking Reader must seek on the offsets of source, regardless of
+// its position on construction, of any prefix and of any magic skipped.
+func TestSeekSourceOffset(t *testing.T) {
+	atOffset := strings.NewReader(feed)
+	atOffset.Seek(3, io.SeekStart)
+	exported := strings.NewReader(feed)
+	exported.Seek(3, io.SeekStart)
+	tests := []struct {
+		name string
+		r    *Reader
+	}{
+		{"offset", NewReader(seekSource{atOffset}, time.Second)},
+		{"prefix", NewReaderWithPrefix([]byte(feed[1:3]), seekSource{exported}, time.Second)},
+		{"magic", NewReaderMagic(seekSource{strings.NewReader(feed)}, time.Second, []byte(feed[:1]), false)},
+	}
+	for _, test := range tests {
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(test.r, buf); err != nil {
+			t.Fatalf("%s: read error: %v", test.name, err)
+		}
+		// next byte to read is buf[2] in all cases
+		want := int64(strings.Index(feed, string(buf)) + 2)
+		if pos, err := test.r.Seek(0, io.SeekCurrent); pos != want || err != nil {
+			t.Errorf("%s: Seek current = (%d, %v), want (%d, <nil>)", test.name, pos, err, want)
+		}
+		if pos, err := test.r.Seek(-1, io.SeekCurrent); pos != want-1 || err != nil {
+			t.Errorf("%s: Seek back = (%d, %v), want (%d, <nil>)", test.name, pos, err, want-1)
+		}
+		got, err := ioutil.ReadAll(test.r)
+		if err != nil || string(got) != feed[want-1:] {
+			t.Errorf("%s: got %q, %v after Seek, want %q", test.name, got, err, feed[want-1:])
+		}
+		test.r.Close()
+	}
+}
+
+// Non blocking Reader must seek forward on any source.
+func TestSeekDiscard(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World"}, {data: "!"}}, time.Second)
+	defer r.Close()
+
+	buf := make([]byte, 1)
+	if n, err := r.Read(buf); n != 1 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (1, <nil>)", n, err)
+	}
+	if pos, err := r.Seek(-1, io.SeekCurrent); pos != 1 || err != ErrUnsupported {
+		t.Errorf("Seek back = (%d, %v), want (1, ErrUnsupported)", pos, err)
+	}
+	if pos, err := r.Seek(1, io.SeekCurrent); pos != 2 || err != nil {
+		t.Errorf("Seek in buffer = (%d, %v), want (2, <nil>)", pos, err)
+	}
+	if pos, err := r.Seek(8, io.SeekStart); pos != 8 || err != nil {
+		t.Errorf("Seek discard = (%d, %v), want (8, <nil>)", pos, err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != feed[8:] {
+		t.Errorf("got %q, %v after Seek, want %q", got, err, feed[8:])
+	}
+}
+
+// Non blocking Reader must fail reads after Close.
+func TestReadAfterClose(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	time.Sleep(9 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Fatal("Close error:", err)
+	}
+
+	buf := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrClosed {
+			t.Errorf("Read %d after Close = (%d, %v), want (0, ErrClosed)", i, n, err)
+		}
+	}
+	if _, _, err := r.Lease(); err != ErrClosed {
+		t.Errorf("Lease after Close got error %v, want ErrClosed", err)
+	}
+}
+
+// Non blocking Reader must wait without timeout in blocking mode.
+func TestSetBlocking(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+
+	r.SetBlocking(true)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("blocking Read = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+
+	r.SetBlocking(false)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read after toggle = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+}
+
+// Non blocking Reader must expose its source.
+func TestSourceAccessors(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	r := NewReader(conn, time.Second)
+	defer r.Close()
+	if got, ok := r.NetConn(); !ok || got != conn {
+		t.Errorf("NetConn = (%v, %t), want (%v, true)", got, ok, conn)
+	}
+	if _, ok := r.File(); ok {
+		t.Error("File got a net.Pipe")
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pw.Close()
+	r = NewReader(pr, time.Second)
+	defer r.Close()
+	if got, ok := r.File(); !ok || got != pr {
+		t.Errorf("File = (%v, %t), want (%v, true)", got, ok, pr)
+	}
+	if _, ok := r.NetConn(); ok {
+		t.Error("NetConn got an os.Pipe")
+	}
+}
+
+// Non blocking Reader must number each source read.
+func TestReadSeq(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World"}, {data: "!"}}, time.Second)
+	defer r.Close()
+	time.Sleep(9 * time.Millisecond)
+
+	golden := []struct {
+		data string
+		seq  uint64
+	}{
+		{"Hel", 1}, {"lo ", 1}, {"World", 2}, {"!", 3},
+	}
+	for _, gold := range golden {
+		buf := make([]byte, 3)
+		if len(gold.data) > 3 {
+			buf = make([]byte, 64)
+		}
+		n, seq, err := r.ReadSeq(buf)
+		if err != nil {
+			t.Fatal("ReadSeq error:", err)
+		}
+		if string(buf[:n]) != gold.data || seq != gold.seq {
+			t.Errorf("got %q with sequence %d, want %q with sequence %d", buf[:n], seq, gold.data, gold.seq)
+		}
+	}
+}
+
+// Non blocking Reader must not read beyond the credit granted.
+func TestCreditReader(t *testing.T) {
+	source := strings.NewReader(feed + feed)
+	r := NewCreditReader(ioutil.NopCloser(source), 9*time.Millisecond, 5)
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed[:5] {
+		t.Fatalf("Read = (%d, %v) %q, want %q", n, err, buf[:n], feed[:5])
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read without credit = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+	if got, want := source.Len(), 2*len(feed)-5; got != want {
+		t.Errorf("source has %d bytes left, want %d", got, want)
+	}
+
+	r.Grant(len(feed) - 5)
+	n, err = r.Read(buf)
+	if err != nil || string(buf[:n]) != feed[5:] {
+		t.Fatalf("Read after Grant = (%d, %v) %q, want %q", n, err, buf[:n], feed[5:])
+	}
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read without credit = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+	if got, want := source.Len(), len(feed); got != want {
+		t.Errorf("source has %d bytes left, want %d", got, want)
+	}
+}
+
+// Timer must not carry a stale expiry after reset.
+func TestResetTimerFired(t *testing.T) {
+	r := newReader(ioutil.NopCloser(strings.NewReader("")), time.Millisecond)
+	// fired, yet not received
+	r.resetTimer(0)
+	time.Sleep(9 * time.Millisecond)
+
+	select {
+	case <-r.resetTimer(time.Hour):
+		t.Error("expiry from before reset")
+	case <-time.After(9 * time.Millisecond):
+	}
+
+	// fired, and received
+	<-r.resetTimer(0)
+	r.stopTimer()
+	select {
+	case <-r.resetTimer(time.Hour):
+		t.Error("expiry from before reset")
+	case <-time.After(9 * time.Millisecond):
+	}
+}
+
+// Non blocking Reader must apply timeout changes after a timeout.
+func TestSetReadTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Fatalf("Read = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+	r.SetReadTimeout(time.Second)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("Read = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+}
+
+// Non blocking Reader must read into a region at the offset.
+func TestReadIntoMmap(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	defer r.Close()
+
+	region := []byte("............................")
+	n, err := r.ReadIntoMmap(region, 3)
+	if n != len(feed) || err != nil {
+		t.Fatalf("ReadIntoMmap = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if want := "..." + feed + "............."; string(region) != want {
+		t.Errorf("got region %q, want %q", region, want)
+	}
+	if _, err := r.ReadIntoMmap(region, len(region)+1); err == nil {
+		t.Error("ReadIntoMmap out of bounds got no error")
+	}
+}
+
+// Non blocking Reader must warn once on low credit.
+func TestOnCreditLow(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewCreditReader(pr, time.Second, 10)
+	defer r.Close()
+
+	calls := make(chan int, 9)
+	r.OnCreditLow(4, func(remaining int) { calls <- remaining })
+
+	buf := make([]byte, 64)
+	for _, chunk := range []string{"Hello", " ", "Worl"} {
+		go pw.Write([]byte(chunk))
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal("Read error:", err)
+		}
+	}
+	if len(calls) != 1 || <-calls != 0 {
+		t.Fatalf("got %d callbacks, want 1 with 0 remaining", len(calls))
+	}
+
+	r.Grant(5)
+	go pw.Write([]byte("d!"))
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	}
+	if len(calls) != 1 || <-calls != 3 {
+		t.Errorf("got %d callbacks after Grant, want 1 with 3 remaining", len(calls))
+	}
+}
+
+// EmptySource reads without data nor error until the count runs out.
+type emptySource struct {
+	empty int32 // remaining reads without data
+	reads int32 // call count
+	io.ReadCloser
+}
+
+func (s *emptySource) Read(p []byte) (int, error) {
+	atomic.AddInt32(&s.reads, 1)
+	if atomic.AddInt32(&s.empty, -1) >= 0 {
+		return 0, nil
+	}
+	return s.ReadCloser.Read(p)
+}
+
+// Non blocking Reader must not spin on reads without data nor error.
+func TestReadEmptySource(t *testing.T) {
+	source := &emptySource{empty: 1 << 30, ReadCloser: ioutil.NopCloser(strings.NewReader(feed))}
+	r := NewReader(source, time.Second)
+	defer r.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&source.reads); n > 1000 {
+		t.Errorf("got %d source reads in 50 ms", n)
+	}
+
+	atomic.StoreInt32(&source.empty, 5)
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("Read = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+}
+
+// StuckSource blocks reads until release, regardless of Close.
+type stuckSource struct {
+	release chan struct{}
+}
+
+func (s stuckSource) Read(p []byte) (int, error) {
+	<-s.release
+	return 0, io.EOF
+}
+
+func (s stuckSource) Close() error { return nil }
+
+// Non blocking Reader must not hang on Close with a stuck source.
+func TestCloseWithTimeout(t *testing.T) {
+	source := stuckSource{release: make(chan struct{})}
+	r := NewReader(source, time.Second)
+	time.Sleep(9 * time.Millisecond)
+
+	start := time.Now()
+	if err := r.CloseWithTimeout(9 * time.Millisecond); err != ErrCloseTimeout {
+		t.Errorf("CloseWithTimeout got error %v, want ErrCloseTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("CloseWithTimeout took %s", d)
+	}
+	if n, err := r.Read(make([]byte, 64)); n != 0 || err != ErrClosed {
+		t.Errorf("Read after close = (%d, %v), want (0, ErrClosed)", n, err)
+	}
+
+	// end read routine
+	close(source.release)
+}
+
+// StuckCloseSource blocks on Close until release.
+type stuckCloseSource struct {
+	io.Reader
+	release chan struct{}
+}
+
+func (s stuckCloseSource) Close() error {
+	<-s.release
+	return nil
+}
+
+// Non blocking Reader must not hang on CloseWithTimeout with a source
+// which blocks on close.
+func TestCloseWithTimeoutStuckClose(t *testing.T) {
+	source := stuckCloseSource{strings.NewReader(feed), make(chan struct{})}
+	r := NewReader(source, time.Second)
+	time.Sleep(9 * time.Millisecond)
+
+	if err := r.CloseWithTimeout(9 * time.Millisecond); err != ErrCloseTimeout {
+		t.Errorf("CloseWithTimeout got error %v, want ErrCloseTimeout", err)
+	}
+	if n, err := r.Read(make([]byte, 64)); n != 0 || err != ErrClosed {
+		t.Errorf("Read after close = (%d, %v), want (0, ErrClosed)", n, err)
+	}
+
+	// end close on source
+	close(source.release)
+}
+
+// MaxReadSource tracks the largest read requested.
+type maxReadSource struct {
+	max int
+	io.ReadCloser
+}
+
+func (s *maxReadSource) Read(p []byte) (int, error) {
+	if len(p) > s.max {
+		s.max = len(p)
+	}
+	return s.ReadCloser.Read(p)
+}
+
+// Non blocking Reader must reassemble data over small buffers.
+func TestReaderSize(t *testing.T) {
+	payload := strings.Repeat(feed, 100)
+	source := &maxReadSource{ReadCloser: ioutil.NopCloser(strings.NewReader(payload))}
+	r := NewReaderSize(source, time.Second, 100)
+	defer r.Close()
+	if got := r.BufferSize(); got != 100 {
+		t.Errorf("got buffer size %d, want 100", got)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	if string(got) != payload {
+		t.Errorf("got %d bytes, want the %d bytes of payload", len(got), len(payload))
+	}
+	if source.max != 100 {
+		t.Errorf("got source reads up to %d bytes, want 100", source.max)
+	}
+
+	r = NewReaderSize(ioutil.NopCloser(strings.NewReader(feed)), time.Second, 1)
+	defer r.Close()
+	if got := r.BufferSize(); got != minBufferSize {
+		t.Errorf("got buffer size %d, want the minimum of %d", got, minBufferSize)
+	}
+}
+
+// Non blocking Reader must time out with a net.Error.
+func TestNoDataNetError(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Millisecond)
+	defer r.Close()
+
+	_, err := r.Read(make([]byte, 64))
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("got error %v, want ErrNoData", err)
+	}
+	ne, ok := err.(net.Error)
+	if !ok {
+		t.Fatalf("got error type %T, want a net.Error", err)
+	}
+	if !ne.Timeout() || !ne.Temporary() {
+		t.Errorf("got Timeout %t and Temporary %t, want both true", ne.Timeout(), ne.Temporary())
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got error %v, want a match for os.ErrDeadlineExceeded", err)
+	}
+}
+
+// Non blocking Reader must report the data staged.
+func TestBuffered(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+	if n := r.Buffered(); n != 0 {
+		t.Errorf("got %d bytes buffered initially, want 0", n)
+	}
+
+	go pw.Write([]byte("Hello "))
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	}
+	go pw.Write([]byte("World!"))
+	time.Sleep(9 * time.Millisecond)
+	if n := r.Buffered(); n < len(feed)-1 {
+		t.Errorf("got %d bytes buffered, want at least %d", n, len(feed)-1)
+	}
+
+	buf = make([]byte, 64)
+	if _, err := io.ReadAtLeast(r, buf, len(feed)-1); err != nil {
+		t.Fatal("read error:", err)
+	}
+	if n := r.Buffered(); n != 0 {
+		t.Errorf("got %d bytes buffered after read of %d, want 0", n, len(feed)-1)
+	}
+}
+
+// Non blocking Reader must write its buffers as is.
+func TestWriteTo(t *testing.T) {
+	payload := strings.Repeat(feed, 3*bufferSize/len(feed)+1)
+	r := NewReader(ioutil.NopCloser(strings.NewReader(payload)), time.Second)
+	defer r.Close()
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, r)
+	if err != nil {
+		t.Fatal("copy error:", err)
+	}
+	if n != int64(len(payload)) || out.String() != payload {
+		t.Errorf("got %d bytes, want the %d bytes of payload", n, len(payload))
+	}
+}
+
+// Non blocking Reader must write all data before the source error.
+func TestWriteToErr(t *testing.T) {
+	sourceErr := errors.New("test error")
+	r := NewReader(&stepSource{{data: "Hello "}, {data: "World!", err: sourceErr}}, time.Second)
+	defer r.Close()
+
+	var out bytes.Buffer
+	n, err := r.WriteTo(&out)
+	if err != sourceErr {
+		t.Errorf("got error %v, want %v", err, sourceErr)
+	}
+	if n != int64(len(feed)) || out.String() != feed {
+		t.Errorf("got %d bytes %q, want %q", n, out.String(), feed)
+	}
+}
+
+// Non blocking Reader must deliver buffered data despite cancellation.
+func TestReadContextCanceled(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	buf := make([]byte, 64)
+	n, err := r.ReadContext(ctx, buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("ReadContext = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+	if n, err := r.ReadContext(ctx, buf); n != 0 || err != context.Canceled {
+		t.Errorf("ReadContext without data = (%d, %v), want (0, %v)", n, err, context.Canceled)
+	}
+}
+
+// Non blocking Reader must stop the wait on cancellation.
+func TestReadContextCancelWait(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(9*time.Millisecond, cancel)
+	if n, err := r.ReadContext(ctx, make([]byte, 64)); n != 0 || err != context.Canceled {
+		t.Errorf("ReadContext = (%d, %v), want (0, %v)", n, err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 9*time.Millisecond)
+	defer cancel()
+	if n, err := r.ReadContext(ctx, make([]byte, 64)); n != 0 || err != context.DeadlineExceeded {
+		t.Errorf("ReadContext = (%d, %v), want (0, %v)", n, err, context.DeadlineExceeded)
+	}
+
+	// timeout applies still
+	r.SetReadTimeout(time.Millisecond)
+	if n, err := r.ReadContext(context.Background(), make([]byte, 64)); n != 0 || err != ErrNoData {
+		t.Errorf("ReadContext = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+}
+
+// Non blocking Reader must switch between poll, timeout and block mode.
+func TestSetReadTimeoutModes(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+	buf := make([]byte, 64)
+
+	r.SetReadTimeout(0)
+	start := time.Now()
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("poll Read = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+	if d := time.Since(start); d > 5*time.Millisecond {
+		t.Errorf("poll Read took %s", d)
+	}
+
+	r.SetReadTimeout(9 * time.Millisecond)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read = (%d, %v), want (0, ErrNoData)", n, err)
+	}
+
+	r.SetReadTimeout(-1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("block Read = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+
+	r.SetReadTimeout(0)
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	n, err = r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("poll Read with data = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+}
+
+// Non blocking Reader must be reusable with another source.
+func TestReset(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	bufs := r.BufferSize()
+
+	for i := 0; i < 5; i++ {
+		got, err := ioutil.ReadAll(r)
+		if err != nil || string(got) != feed {
+			t.Fatalf("cycle %d: ReadAll = (%q, %v), want %q", i, got, err, feed)
+		}
+		// after full read
+		r.Reset(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	}
+
+	// after error
+	sourceErr := errors.New("test error")
+	r.Reset(&stepSource{{data: "Hello ", err: sourceErr}}, time.Second)
+	if got, err := ioutil.ReadAll(r); err != sourceErr || string(got) != "Hello " {
+		t.Errorf("ReadAll = (%q, %v), want (%q, %v)", got, err, "Hello ", sourceErr)
+	}
+	r.Reset(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != feed {
+		t.Errorf("ReadAll after error reset = (%q, %v), want %q", got, err, feed)
+	}
+	if got := r.BufferSize(); got != bufs {
+		t.Errorf("got buffer size %d after reset, want %d", got, bufs)
+	}
+
+	// blocked read routine pending
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r.Reset(pr, time.Hour)
+	time.Sleep(9 * time.Millisecond)
+	r.Reset(ioutil.NopCloser(strings.NewReader(feed)), time.Second)
+	r.Close()
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Non blocking Reader must be done with its fields once the read routine
+// closes next, as Reset reuses the Reader right after. The race detector
+// catches any signal past then.
+func TestResetAfterFail(t *testing.T) {
+	sourceErr := errors.New("test error")
+	r := NewReader(&stepSource{{err: sourceErr}}, time.Second)
+	for i := 0; i < 100; i++ {
+		if n, err := r.Read(make([]byte, 8)); n != 0 || err != sourceErr {
+			t.Fatalf("cycle %d: Read = (%d, %v), want (0, %v)", i, n, err, sourceErr)
+		}
+		r.Reset(&stepSource{{err: sourceErr}}, time.Second)
+	}
+	r.Close()
+	select {
+	case <-r.Ready():
+	default:
+		t.Error("no ready signal on error")
+	}
+}
+
+// Non blocking Reader must terminate on sources without Close.
+func TestReaderFromReader(t *testing.T) {
+	// endless source
+	r := NewReaderFromReader(iotest.OneByteReader(zeroReader{}), time.Second)
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n == 0 || err != nil {
+		t.Errorf("Read = (%d, %v), want data", n, err)
+	}
+	r.Close()
+	time.Sleep(9 * time.Millisecond) // terminates in the background
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+
+	r = NewReaderFromReader(iotest.HalfReader(strings.NewReader(feed)), time.Second)
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != feed {
+		t.Errorf("ReadAll = (%q, %v), want %q", got, err, feed)
+	}
+}
+
+// Non blocking Reader must not wait on a pending read of a source without
+// Close.
+func TestReaderFromReaderCloseBlocked(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReaderFromReader(struct{ io.Reader }{pr}, time.Hour)
+	time.Sleep(9 * time.Millisecond) // read routine on source
+
+	done := make(chan error, 1)
+	go func() { done <- r.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Close error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on the pending source read")
+	}
+	if n, err := r.Read(make([]byte, 8)); n != 0 || err != ErrClosed {
+		t.Errorf("Read after Close = (%d, %v), want (0, %v)", n, err, ErrClosed)
+	}
+
+	// pending read returns
+	pw.Write([]byte(feed))
+	pw.Close()
+	time.Sleep(9 * time.Millisecond)
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// ZeroReader is an endless stream of zeros, without Close.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Non blocking Reader must fill the minimum in one call.
+func TestReadAtLeast(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	go func() {
+		pw.Write([]byte("Hel"))
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte("lo"))
+	}()
+	buf := make([]byte, 8)
+	if n, err := r.ReadAtLeast(buf, 5); n != 5 || err != nil {
+		t.Errorf("ReadAtLeast = (%d, %v), want (5, <nil>)", n, err)
+	} else if got := string(buf[:n]); got != "Hello" {
+		t.Errorf("got %q, want %q", got, "Hello")
+	}
+
+	if n, err := r.ReadAtLeast(buf[:2], 3); n != 0 || err != io.ErrShortBuffer {
+		t.Errorf("ReadAtLeast with short buffer = (%d, %v), want (0, %v)", n, err, io.ErrShortBuffer)
+	}
+}
+
+// Non blocking Reader must apply the timeout to ReadAtLeast as a whole.
+func TestReadAtLeastTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 30*time.Millisecond)
+	defer r.Close()
+
+	go func() {
+		// each write within the timeout of the previous
+		for i := 0; i < 20; i++ {
+			if _, err := pw.Write([]byte{'x'}); err != nil {
+				return
+			}
+			time.Sleep(9 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	buf := make([]byte, 20)
+	n, err := r.ReadAtLeast(buf, len(buf))
+	if err != ErrNoData {
+		t.Fatalf("ReadAtLeast = (%d, %v), want ErrNoData", n, err)
+	}
+	if n == 0 || n == len(buf) {
+		t.Errorf("ReadAtLeast got %d bytes, want partial data", n)
+	}
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Errorf("ReadAtLeast took %s with a timeout of 30ms", elapsed)
+	}
+}
+
+// Non blocking Reader must pass source errors from ReadAtLeast with the
+// partial data.
+func TestReadAtLeastEOF(t *testing.T) {
+	r := NewReader(&stepSource{{data: "Hel"}}, time.Second)
+	defer r.Close()
+
+	buf := make([]byte, 8)
+	if n, err := r.ReadAtLeast(buf, 5); n != 3 || err != io.EOF {
+		t.Errorf("ReadAtLeast = (%d, %v), want (3, %v)", n, err, io.EOF)
+	} else if got := string(buf[:n]); got != "Hel" {
+		t.Errorf("got %q, want %q", got, "Hel")
+	}
+}
+
+// Non blocking Reader must signal on each arrival after Read drained.
+func TestReady(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 0)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	for round := 1; round <= 2; round++ {
+		go pw.Write([]byte(feed))
+
+		select {
+		case <-r.Ready():
+		case <-time.After(time.Second):
+			t.Fatalf("round %d: no ready signal", round)
+		}
+		var got []byte
+		for {
+			n, err := r.Read(buf)
+			got = append(got, buf[:n]...)
+			if err == ErrNoData {
+				break
+			}
+			if err != nil {
+				t.Fatalf("round %d: Read error: %v", round, err)
+			}
+		}
+		if string(got) != feed {
+			t.Errorf("round %d: got %q, want %q", round, got, feed)
+		}
+	}
+
+	pw.CloseWithError(errOnClose)
+	select {
+	case <-r.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("no ready signal on source error")
+	}
+	if n, err := r.Read(buf); n != 0 || err != errOnClose {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, errOnClose)
+	}
+}
+
+// Non blocking Reader must deliver peeked data on read.
+func TestPeek(t *testing.T) {
+	r := NewReaderSize(&stepSource{{data: "GET "}, {data: "/ HTTP/1.1\r\n"}}, time.Second, 64)
+	defer r.Close()
+
+	got, err := r.Peek(3)
+	if err != nil || string(got) != "GET" {
+		t.Fatalf("Peek(3) = (%q, %v), want (\"GET\", <nil>)", got, err)
+	}
+	// across source reads
+	got, err = r.Peek(6)
+	if err != nil || string(got) != "GET / " {
+		t.Fatalf("Peek(6) = (%q, %v), want (\"GET / \", <nil>)", got, err)
+	}
+
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); n != 4 || err != nil || string(buf) != "GET " {
+		t.Errorf("Read = (%d, %v) %q, want (4, <nil>) \"GET \"", n, err, buf[:n])
+	}
+
+	// source ends before n
+	got, err = r.Peek(20)
+	if err != io.EOF || string(got) != "/ HTTP/1.1\r\n" {
+		t.Errorf("Peek(20) at end = (%q, %v), want (%q, %v)", got, err, "/ HTTP/1.1\r\n", io.EOF)
+	}
+
+	if got, err := r.Peek(3*64 + 1); got != nil || err != ErrBufferFull {
+		t.Errorf("Peek beyond buffers = (%q, %v), want (\"\", %v)", got, err, ErrBufferFull)
+	}
+}
+
+// Non blocking Reader must time out on Peek without data.
+func TestPeekTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	go pw.Write([]byte("ab"))
+	if got, err := r.Peek(3); got != nil || err != ErrNoData {
+		t.Errorf("Peek(3) = (%q, %v), want (\"\", %v)", got, err, ErrNoData)
+	}
+	if got, err := r.Peek(2); err != nil || string(got) != "ab" {
+		t.Errorf("Peek(2) = (%q, %v), want (\"ab\", <nil>)", got, err)
+	}
+}
+
+// Non blocking Reader must consume views with Next.
+func TestNext(t *testing.T) {
+	r := NewReaderSize(&stepSource{{data: "\x03abc"}, {data: "\x02de"}, {data: "f"}}, time.Second, 64)
+	defer r.Close()
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		size, err := r.ReadByte()
+		if err != nil {
+			t.Fatal("ReadByte error:", err)
+		}
+		frame, err := r.Next(int(size))
+		if err != nil {
+			t.Fatalf("Next(%d) error: %v", size, err)
+		}
+		got = append(got, string(frame))
+	}
+	if len(got) != 2 || got[0] != "abc" || got[1] != "de" {
+		t.Errorf("got frames %q, want [\"abc\" \"de\"]", got)
+	}
+
+	// unread byte first
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal("UnreadByte error:", err)
+	}
+	if view, err := r.Next(2); err != nil || string(view) != "ef" {
+		t.Errorf("Next(2) after UnreadByte = (%q, %v), want (\"ef\", <nil>)", view, err)
+	}
+
+	if view, err := r.Next(2); len(view) != 0 || err != io.EOF {
+		t.Errorf("Next(2) at end = (%q, %v), want (\"\", %v)", view, err, io.EOF)
+	}
+}
+
+// Stream Reader must deliver data and error from one source read at once.
+func TestStreamReader(t *testing.T) {
+	r := NewStreamReader(errCloser{iotest.DataErrReader(strings.NewReader(feed))}, time.Second)
+	defer r.Close()
+
+	buf := make([]byte, len(feed)+1)
+	n, err := r.Read(buf)
+	if n != len(feed) || err != io.EOF {
+		t.Fatalf("Read = (%d, %v), want (%d, %v)", n, err, len(feed), io.EOF)
+	}
+	if got := string(buf[:n]); got != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after EOF = (%d, %v), want (0, %v)", n, err, io.EOF)
+	}
+}
+
+// Stream Reader must deliver any source error with the data.
+func TestStreamReaderError(t *testing.T) {
+	r := NewStreamReader(&stepSource{{data: "Hello "}, {data: "World!", err: errTransient}}, time.Second)
+	defer r.Close()
+
+	var got []byte
+	buf := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == nil {
+			continue
+		}
+		if err != errTransient || n == 0 {
+			t.Errorf("Read = (%d, %v), want (>0, %v)", n, err, errTransient)
+		}
+		break
+	}
+	if string(got) != "Hello World!" {
+		t.Errorf("got %q, want %q", got, "Hello World!")
+	}
+}
+
+// Non blocking Reader must pass source errors as is, distinct from a
+// timeout.
+func TestReadSourceErrorType(t *testing.T) {
+	opErr := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrPermission}
+	r := NewReader(&stepSource{{data: feed, err: opErr}}, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Fatalf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	for i := 0; i < 2; i++ {
+		_, err := r.Read(buf)
+		if errors.Is(err, ErrNoData) {
+			t.Errorf("Read %d error %v is ErrNoData", i, err)
+		}
+		var got *net.OpError
+		if !errors.As(err, &got) || got != opErr {
+			t.Errorf("Read %d error %v (%T) does not recover the *net.OpError", i, err, err)
+		}
+		if !errors.Is(err, os.ErrPermission) {
+			t.Errorf("Read %d error %v lost the error chain", i, err)
+		}
+	}
+}
+
+// Non blocking Reader must not stick on a timeout from a nested Reader.
+func TestReadNestedTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	inner := NewReader(pr, time.Millisecond)
+	r := NewReader(inner, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Fatalf("Read = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if err := r.PeekErr(); err != nil {
+		t.Fatalf("timeout from inner Reader got sticky: %v", err)
+	}
+
+	go pw.Write([]byte(feed))
+	n, err := r.Read(buf)
+	for i := 0; err == ErrNoData && i < 100; i++ {
+		n, err = r.Read(buf)
+	}
+	if n != len(feed) || err != nil {
+		t.Errorf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
+
+// Non blocking Reader must deliver all data before io.EOF, including the
+// buffers pending when the source ends.
+func TestReadAllBeforeEOF(t *testing.T) {
+	payload := make([]byte, 10*bufferSize+17)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	for i := 0; i < 50; i++ {
+		sources := []io.Reader{
+			bytes.NewReader(payload),
+			// EOF along with the last data
+			iotest.DataErrReader(bytes.NewReader(payload)),
+		}
+		for j, src := range sources {
+			r := NewReader(errCloser{src}, time.Second)
+			if i == 0 {
+				// all buffers pending
+				time.Sleep(9 * time.Millisecond)
+			}
+			got, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("source %d, run %d: ReadAll error: %v", j, i, err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("source %d, run %d: got %d bytes, want %d", j, i, len(got), len(payload))
+			}
+		}
+	}
+}
+
+// Non blocking Reader must stop source reads while paused.
+func TestPauseResume(t *testing.T) {
+	source := &countSource{ReadCloser: errCloser{iotest.OneByteReader(zeroReader{})}}
+	r := NewReader(source, 9*time.Millisecond)
+	defer r.Close()
+
+	r.Pause()
+	buf := make([]byte, 64)
+	// drain what was read before the pause
+	for i := 0; ; i++ {
+		_, err := r.Read(buf)
+		if err == ErrNoData {
+			break
+		}
+		if err != nil || i > 1000 {
+			t.Fatalf("Read %d while paused: error %v", i, err)
+		}
+	}
+	if got := r.LastWaitReason(); got != WaitPaused {
+		t.Errorf("got wait reason %q, want %q", got, WaitPaused)
+	}
+	reads := atomic.LoadInt32(&source.reads)
+	time.Sleep(9 * time.Millisecond)
+	if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+		t.Errorf("Read while paused = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if got := atomic.LoadInt32(&source.reads); got != reads {
+		t.Errorf("source reads advanced from %d to %d while paused", reads, got)
+	}
+
+	r.Resume()
+	if n, err := r.Read(buf); n == 0 || err != nil {
+		t.Errorf("Read after Resume = (%d, %v), want (>0, <nil>)", n, err)
+	}
+	if got := atomic.LoadInt32(&source.reads); got <= reads {
+		t.Errorf("source reads stuck at %d after Resume", got)
+	}
+}
+
+// Benchmark reads from buffered data, without any wait.
+func BenchmarkReadBuffered(b *testing.B) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+
+	buf := make([]byte, 16)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Read(buf); err != nil {
+			b.Fatal("read error:", err)
+		}
+	}
+}
+
+// Benchmark reads of a full buffer each, i.e., a handoff per read.
+func BenchmarkReadHandoff(b *testing.B) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+
+	buf := make([]byte, bufferSize)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Read(buf); err != nil {
+			b.Fatal("read error:", err)
+		}
+	}
+}
+
+// Benchmark reads of half a buffer each, i.e., every other read ends
+// on a buffer boundary.
+func BenchmarkReadBoundary(b *testing.B) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+
+	buf := make([]byte, bufferSize/2)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Read(buf); err != nil {
+			b.Fatal("read error:", err)
+		}
+	}
+}
+
+// Non blocking Reader must recycle a consumed buffer before Read returns.
+func TestReadRecycleOnReturn(t *testing.T) {
+	payload := make([]byte, 100*minBufferSize)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	r := NewReaderSize(errCloser{bytes.NewReader(payload)}, time.Second, minBufferSize)
+	defer r.Close()
+
+	var got []byte
+	buf := make([]byte, minBufferSize/4)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read error:", err)
+		}
+		if len(got)%minBufferSize == 0 && cap(r.buf) != 0 {
+			t.Fatalf("consumed buffer not recycled at offset %d", len(got))
+		}
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", len(got), len(payload))
+	}
+
+	// read routine terminated with all buffers returned once exactly
+	if n := len(r.pool); n != cap(r.pool) {
+		t.Errorf("got %d buffers in pool, want %d", n, cap(r.pool))
+	}
+}
+
+// Non blocking Reader must deliver an unread byte first.
+func TestUnreadByte(t *testing.T) {
+	r := NewReader(&stepSource{{data: "ab"}, {data: "cd"}}, 9*time.Millisecond)
+	defer r.Close()
+
+	if err := r.UnreadByte(); err != ErrInvalidUnreadByte {
+		t.Errorf("UnreadByte before read got error %v, want %v", err, ErrInvalidUnreadByte)
+	}
+
+	var got []byte
+	readByte := func() {
+		c, err := r.ReadByte()
+		if err != nil {
+			t.Fatal("ReadByte error:", err)
+		}
+		got = append(got, c)
+	}
+	unread := func() {
+		if err := r.UnreadByte(); err != nil {
+			t.Fatal("UnreadByte error:", err)
+		}
+	}
+	readByte()
+	unread()
+	readByte()
+	readByte()
+	unread()
+	if err := r.UnreadByte(); err != ErrInvalidUnreadByte {
+		t.Errorf("second UnreadByte got error %v, want %v", err, ErrInvalidUnreadByte)
+	}
+	// across the buffer boundary
+	readByte()
+	readByte()
+	unread()
+	buf := make([]byte, 4)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal("Read error:", err)
+	}
+	got = append(got, buf[:n]...)
+	unread()
+	readByte()
+	n, err = r.Read(buf)
+	got = append(got, buf[:n]...)
+	if err != nil {
+		t.Fatal("Read error:", err)
+	}
+
+	if want := "aabbcccd"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if c, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte at end = (%q, %v), want %v", c, err, io.EOF)
+	}
+}
+
+// Non blocking Reader must keep partial encodings on ReadRune timeout.
+func TestReadRune(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+	var _ io.RuneReader = r
+
+	readRune := func(want rune, wantSize int) {
+		t.Helper()
+		ch, size, err := r.ReadRune()
+		if ch != want || size != wantSize || err != nil {
+			t.Errorf("ReadRune = (%q, %d, %v), want (%q, %d, <nil>)", ch, size, err, want, wantSize)
+		}
+	}
+
+	go pw.Write([]byte("a\xe2\x82"))
+	readRune('a', 1)
+	if ch, size, err := r.ReadRune(); err != ErrNoData {
+		t.Errorf("ReadRune on partial = (%q, %d, %v), want %v", ch, size, err, ErrNoData)
+	}
+	go pw.Write([]byte("\xac\xff\xe2"))
+	readRune('€', 3)
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal("UnreadByte error:", err)
+	}
+	readRune(utf8.RuneError, 1) // continuation byte
+	readRune(utf8.RuneError, 1) // invalid byte
+	pw.Close()
+	readRune(utf8.RuneError, 1) // partial at end
+	if ch, size, err := r.ReadRune(); err != io.EOF {
+		t.Errorf("ReadRune at end = (%q, %d, %v), want %v", ch, size, err, io.EOF)
+	}
+}
+
+// Non blocking Reader must keep partial records on ReadString timeout.
+func TestReadString(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	go pw.Write([]byte("HELO exa"))
+	time.Sleep(9 * time.Millisecond)
+	if s, err := r.ReadString('\n'); s != "" || err != ErrNoData {
+		t.Errorf("ReadString on partial = (%q, %v), want (\"\", %v)", s, err, ErrNoData)
+	}
+	go pw.Write([]byte("mple.com\r\nQUIT"))
+	if s, err := r.ReadString('\n'); s != "HELO example.com\r\n" || err != nil {
+		t.Errorf("ReadString = (%q, %v), want (\"HELO example.com\\r\\n\", <nil>)", s, err)
+	}
+
+	// unread delim
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal("UnreadByte error:", err)
+	}
+	if s, err := r.ReadString('\n'); s != "\n" || err != nil {
+		t.Errorf("ReadString after UnreadByte = (%q, %v), want (\"\\n\", <nil>)", s, err)
+	}
+
+	pw.Close()
+	if s, err := r.ReadString('\n'); s != "QUIT" || err != io.EOF {
+		t.Errorf("ReadString at end = (%q, %v), want (\"QUIT\", %v)", s, err, io.EOF)
+	}
+	if s, err := r.ReadString('\n'); s != "" || err != io.EOF {
+		t.Errorf("ReadString after end = (%q, %v), want (\"\", %v)", s, err, io.EOF)
+	}
+}
+
+// Non blocking Reader must call the OnIdle hook once per silence.
+func TestOnIdle(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	var fired int32
+	r.OnIdle(30*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+
+	buf := make([]byte, len(feed))
+	for i := 0; i < 5; i++ {
+		time.Sleep(9 * time.Millisecond)
+		go pw.Write([]byte(feed))
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal("Read error:", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Errorf("hook fired %d times while data arrived", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Errorf("hook fired %d times on silence, want 1", n)
+	}
+}
+
+// Non blocking Reader must terminate a concurrent Read on Close.
+func TestReadConcurrentClose(t *testing.T) {
+	for _, timeout := range []time.Duration{-1, 0, 9 * time.Millisecond} {
+		pr, pw := io.Pipe()
+		go func() {
+			for {
+				if _, err := pw.Write([]byte(feed)); err != nil {
+					return
+				}
+			}
+		}()
+		r := NewReader(pr, timeout)
+
+		done := make(chan error)
+		go func() {
+			buf := make([]byte, 7)
+			for {
+				_, err := r.Read(buf)
+				if err != nil && err != ErrNoData {
+					done <- err
+					return
+				}
+			}
+		}()
+		time.Sleep(9 * time.Millisecond)
+		r.Close()
+
+		select {
+		case err := <-done:
+			if err != ErrClosed && err != io.ErrClosedPipe {
+				t.Errorf("timeout %s: got error %v, want %v or %v", timeout, err, ErrClosed, io.ErrClosedPipe)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout %s: Read did not terminate on Close", timeout)
+		}
+	}
+}
+
+// Non blocking Reader must unblock a concurrent Read on Close, even when
+// source does not interrupt.
+func TestReadCloseStuckSource(t *testing.T) {
+	gate := make(chan struct{})
+	defer close(gate)
+	r := NewReader(gateSource{gate, errCloser{strings.NewReader(feed)}}, -1)
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 7))
+		done <- err
+	}()
+	time.Sleep(9 * time.Millisecond)
+	go r.Close()
+
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Errorf("got error %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not terminate on Close")
+	}
+}
+
+// Non blocking Reader must report the time spent on ReadTimed.
+func TestReadTimed(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, 5)
+	n, waited, err := r.ReadTimed(buf)
+	if n != 0 || err != ErrNoData {
+		t.Fatalf("ReadTimed = (%d, %s, %v), want (0, ~9ms, %v)", n, waited, err, ErrNoData)
+	}
+	if waited < 9*time.Millisecond || waited > 90*time.Millisecond {
+		t.Errorf("waited %s on timeout of 9ms", waited)
+	}
+
+	pw.Write([]byte(feed))
+	// remainder buffered
+	if n, _, err := r.ReadTimed(buf); n != 5 || err != nil {
+		t.Fatalf("ReadTimed = (%d, %v), want (5, <nil>)", n, err)
+	}
+	n, waited, err = r.ReadTimed(buf)
+	if n != 5 || err != nil {
+		t.Fatalf("ReadTimed on buffered = (%d, %v), want (5, <nil>)", n, err)
+	}
+	if waited > 5*time.Millisecond {
+		t.Errorf("waited %s on buffered data", waited)
+	}
+}
+
+// CloseFlagSource records Close.
+type closeFlagSource struct {
+	closed int32
+	io.Reader
+}
+
+func (s *closeFlagSource) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	return nil
+}
+
+// Non blocking Reader must drain buffered data after StopReading, and
+// leave the source open.
+func TestStopReading(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	source := &closeFlagSource{Reader: pr}
+	r := NewReader(source, time.Second)
+	defer r.Close()
+
+	pw.Write([]byte("Hello "))
+	// await the next read on source
+	time.Sleep(9 * time.Millisecond)
+	if err := r.StopReading(); err != nil {
+		t.Fatal("StopReading error:", err)
+	}
+	// completes the pending read on source
+	pw.Write([]byte("World!"))
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll error:", err)
+	}
+	if string(got) != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+	if atomic.LoadInt32(&source.closed) != 0 {
+		t.Error("source closed by StopReading")
+	}
+
+	// source free for other use
+	go pw.Write([]byte("next"))
+	buf := make([]byte, 4)
+	if n, err := pr.Read(buf); n != 4 || err != nil || string(buf) != "next" {
+		t.Errorf("source Read = (%d, %v) %q, want (4, <nil>) \"next\"", n, err, buf[:n])
+	}
+}
+
+// Non blocking Reader must end a paused read routine on StopReading.
+func TestStopReadingPaused(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+	r.Pause()
+	time.Sleep(9 * time.Millisecond)
+	r.StopReading()
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal("ReadAll error:", err)
+	}
+}
+
+// Non blocking Reader must poll with a zero timeout, and block with a
+// negative timeout.
+func TestReadTimeoutSign(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 0)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	for i := 0; i < 3; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+			t.Errorf("poll %d: Read = (%d, %v), want (0, %v)", i, n, err, ErrNoData)
+		}
+	}
+	if r.timer != nil {
+		t.Error("timer created by poll")
+	}
+
+	r.SetReadTimeout(-1)
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("blocking Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if r.timer != nil {
+		t.Error("timer created by blocking Read")
+	}
+}
+
+// Non blocking Reader must poll on TryRead, regardless of the timeout.
+func TestTryRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	buf := make([]byte, len(feed))
+	start := time.Now()
+	if n, err := r.TryRead(buf); n != 0 || err != ErrNoData {
+		t.Errorf("TryRead = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("TryRead took %s", d)
+	}
+	if r.timer != nil {
+		t.Error("timer created by TryRead")
+	}
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if n, err := r.TryRead(buf); n != len(feed) || err != nil {
+		t.Errorf("TryRead with data = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
+
+// Non blocking Reader must detect concurrent reads.
+func TestConcurrentRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 64))
+		done <- err
+	}()
+	time.Sleep(9 * time.Millisecond)
+	if n, err := r.Read(make([]byte, 64)); n != 0 || err != ErrConcurrentRead {
+		t.Errorf("concurrent Read = (%d, %v), want (0, %v)", n, err, ErrConcurrentRead)
+	}
+	r.Close()
+	if err := <-done; err != ErrClosed {
+		t.Errorf("first Read got error %v, want %v", err, ErrClosed)
+	}
+}
+
+// Non blocking Reader must end a Read wait on CancelRead.
+func TestCancelRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Hour)
+	defer r.Close()
+
+	go func() {
+		time.Sleep(9 * time.Millisecond)
+		r.CancelRead()
+	}()
+	buf := make([]byte, len(feed))
+	start := time.Now()
+	if n, err := r.Read(buf); n != 0 || err != ErrReadCanceled {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrReadCanceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("canceled Read took %s", d)
+	}
+
+	// source remains in use
+	go pw.Write([]byte(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read after cancel = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+
+	pr2, pw2 := io.Pipe()
+	defer pw2.Close()
+	r.Reset(pr2, time.Hour)
+	r.CancelRead()
+	if n, err := r.Read(buf); n != 0 || err != ErrReadCanceled {
+		t.Errorf("Read after Reset = (%d, %v), want (0, %v)", n, err, ErrReadCanceled)
+	}
+}
+
+// Non blocking Reader must drain buffered data after CloseRead.
+func TestCloseRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	go pw.Write([]byte(feed))
+	// await the next read on source
+	time.Sleep(9 * time.Millisecond)
+	if err := r.CloseRead(); err != nil {
+		t.Fatal("CloseRead error:", err)
+	}
+	if _, err := pw.Write([]byte(feed)); err != io.ErrClosedPipe {
+		t.Errorf("source write got error %v, want %v", err, io.ErrClosedPipe)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll error:", err)
+	}
+	if string(got) != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+	if err := r.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+}
+
+// Non blocking Reader must leave no timer armed once reads return.
+func TestReadTimerDisarm(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+
+	buf := make([]byte, len(feed))
+	if _, err := r.Read(buf); err != ErrNoData {
+		t.Fatalf("Read got error %v, want %v", err, ErrNoData)
+	}
+	if r.timer.Stop() {
+		t.Error("timer armed after timeout")
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		pw.Write([]byte(feed))
+	}()
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal("Read error:", err)
+	}
+	if r.timer.Stop() {
+		t.Error("timer armed after data")
+	}
+
+	r.SetReadTimeout(time.Hour)
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(buf)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond)
+	r.Close()
+	if err := <-done; err != ErrClosed && err != io.ErrClosedPipe {
+		t.Errorf("Read during Close got error %v", err)
+	}
+	if r.timer.Stop() {
+		t.Error("timer armed after Close")
+	}
+}
+
+// Non blocking Reader must continue with a new source after failure.
+func TestSetSource(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	if err := r.SetSource(&stepSource{}); err == nil {
+		t.Error("SetSource on healthy source got no error")
+	}
+
+	go func() {
+		pw.Write([]byte("Hello "))
+		pw.Write([]byte("World"))
+		pw.CloseWithError(errTransient)
+	}()
+	buf := make([]byte, 3)
+	if n, err := r.Read(buf); n != 3 || err != nil {
+		t.Fatalf("Read = (%d, %v), want (3, <nil>)", n, err)
+	}
+	// await source failure
+	time.Sleep(9 * time.Millisecond)
+	if err := r.SetSource(&stepSource{{data: "!"}}); err != nil {
+		t.Fatal("SetSource error:", err)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll error:", err)
+	}
+	if want := "lo World!"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := r.SetSource(&stepSource{{data: "again"}}); err != nil {
+		t.Fatal("SetSource after EOF error:", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil || string(got) != "again" {
+		t.Errorf("ReadAll = (%q, %v), want (\"again\", <nil>)", got, err)
+	}
+
+	r.Close()
+	if dump := stackDump(); strings.Contains(dump, readRoutineStackEl) {
+		t.Errorf("read routine element %q still present in:\n%s", readRoutineStackEl, dump)
+	}
+}
+
+// Non blocking Reader must expose its source.
+func TestUnwrap(t *testing.T) {
+	source := errCloser{strings.NewReader(feed)}
+	var rc io.ReadCloser = NewReader(source, time.Second)
+	defer rc.Close()
+
+	u, ok := rc.(interface{ Unwrap() io.ReadCloser })
+	if !ok {
+		t.Fatal("Reader does not implement Unwrap")
+	}
+	if got := u.Unwrap(); got != source {
+		t.Errorf("Unwrap = %#v, want %#v", got, source)
+	}
+}
+
+// Non blocking Reader must skip data with Discard, across source reads.
+func TestDiscard(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	go func() {
+		pw.Write([]byte("Hel"))
+		time.Sleep(9 * time.Millisecond)
+		pw.Write([]byte("lo World"))
+	}()
+	if b, err := r.ReadByte(); b != 'H' || err != nil {
+		t.Fatalf("ReadByte = (%q, %v), want ('H', <nil>)", b, err)
+	}
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal("UnreadByte error:", err)
+	}
+	if n, err := r.Discard(6); n != 6 || err != nil {
+		t.Errorf("Discard = (%d, %v), want (6, <nil>)", n, err)
+	}
+	if err := r.UnreadByte(); err == nil {
+		t.Error("UnreadByte after Discard got no error")
+	}
+	buf := make([]byte, 8)
+	if n, err := r.Read(buf); string(buf[:n]) != "World" || err != nil {
+		t.Errorf("Read = (%d, %v) %q, want (5, <nil>) %q", n, err, buf[:n], "World")
+	}
+
+	r.SetReadTimeout(9 * time.Millisecond)
+	if n, err := r.Discard(1); n != 0 || err != ErrNoData {
+		t.Errorf("Discard without data = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+}
+
+// Non blocking Reader must scatter buffered data over net.Buffers.
+func TestReadBuffers(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	go pw.Write([]byte(feed))
+	header, payload := make([]byte, 4), make([]byte, 16)
+	n, err := r.ReadBuffers(net.Buffers{header, nil, payload})
+	if n != int64(len(feed)) || err != nil {
+		t.Fatalf("ReadBuffers = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if got := string(header) + string(payload[:n-4]); got != feed {
+		t.Errorf("got %q, want %q", got, feed)
+	}
+
+	r.SetReadTimeout(9 * time.Millisecond)
+	if n, err := r.ReadBuffers(net.Buffers{header}); n != 0 || err != ErrNoData {
+		t.Errorf("ReadBuffers without data = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+}
+
+// Non blocking Reader must fail reads past the session deadline, until
+// lifted.
+func TestSessionDeadline(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+
+	start := time.Now()
+	r.SetSessionDeadline(start.Add(30 * time.Millisecond))
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != 0 || err != ErrSessionExpired {
+		t.Errorf("Read = (%d, %v), want (0, %v)", n, err, ErrSessionExpired)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Read took %s with a session of 30ms", elapsed)
+	}
+
+	go pw.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if n, err := r.Read(buf); n != 0 || err != ErrSessionExpired {
+		t.Errorf("Read with data = (%d, %v), want (0, %v)", n, err, ErrSessionExpired)
+	}
+
+	r.SetSessionDeadline(time.Time{})
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Errorf("Read after lift = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+}
+
+// Non blocking Reader must refuse SetSource on an injected error, while
+// the read routine is still on the source.
+func TestSetSourceInjectErr(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	r.InjectErr(errTransient)
+	done := make(chan error, 1)
+	go func() { done <- r.SetSource(&stepSource{{data: "!"}}) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("SetSource after InjectErr got no error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SetSource blocked after InjectErr")
+	}
+}
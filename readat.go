@@ -0,0 +1,64 @@
+package nbio
+
+import (
+	"io"
+	"time"
+)
+
+// ReaderAt is an io.ReaderAt with a timeout per call, as returned by
+// NewReaderAt.
+type ReaderAt struct {
+	source  io.ReaderAt
+	timeout time.Duration
+}
+
+// NewReaderAt returns a new wrapper whose ReadAt function gives a time out
+// (with ErrNoData) when source does not complete within timeout, e.g., on a
+// stalled network filesystem. Each ReadAt runs on a routine of its own,
+// with a buffer of its own, as source may not return in time. Such reads
+// hold on to their routine and buffer until source returns, and the result
+// is discarded then. A timeout of zero makes ReadAt wait for nothing, while
+// a negative timeout makes it wait without limit.
+//
+// Unlike Reader, ReaderAt has no sticky errors, and it permits concurrent
+// use, to the extent source does.
+func NewReaderAt(source io.ReaderAt, timeout time.Duration) *ReaderAt {
+	return &ReaderAt{source: source, timeout: timeout}
+}
+
+// ReadAtResult is the outcome of a read on source.
+type readAtResult struct {
+	buf []byte
+	err error
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	done := make(chan readAtResult, 1)
+	go func() {
+		buf := make([]byte, len(p))
+		n, err := r.source.ReadAt(buf, off)
+		done <- readAtResult{buf[:n], err}
+	}()
+
+	var res readAtResult
+	select {
+	case res = <-done:
+	default:
+		if r.timeout == 0 {
+			return 0, ErrNoData
+		}
+		var expire <-chan time.Time // nil blocks
+		if r.timeout > 0 {
+			timer := time.NewTimer(r.timeout)
+			defer timer.Stop()
+			expire = timer.C
+		}
+		select {
+		case res = <-done:
+		case <-expire:
+			return 0, ErrNoData
+		}
+	}
+	return copy(p, res.buf), res.err
+}
@@ -0,0 +1,37 @@
+package nbio
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// StallReaderAt blocks reads until release is closed.
+type stallReaderAt struct {
+	io.ReaderAt
+	release chan struct{}
+}
+
+func (s stallReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	<-s.release
+	return s.ReaderAt.ReadAt(p, off)
+}
+
+// ReaderAt must time out on a stalled source.
+func TestReaderAt(t *testing.T) {
+	source := stallReaderAt{strings.NewReader(feed), make(chan struct{})}
+	r := NewReaderAt(source, 9*time.Millisecond)
+
+	buf := make([]byte, 5)
+	if n, err := r.ReadAt(buf, 6); n != 0 || err != ErrNoData {
+		t.Errorf("stalled: ReadAt = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	close(source.release)
+	if n, err := r.ReadAt(buf, 6); string(buf[:n]) != "World" || err != nil {
+		t.Errorf("ReadAt = (%d, %v) %q, want (5, <nil>) %q", n, err, buf[:n], "World")
+	}
+	if n, err := r.ReadAt(buf, 10); string(buf[:n]) != "d!" || err != io.EOF {
+		t.Errorf("ReadAt at end = (%d, %v) %q, want (2, %v) %q", n, err, buf[:n], io.EOF, "d!")
+	}
+}
@@ -0,0 +1,196 @@
+package nbio
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ring is a byte queue of fixed capacity, for one producer and one
+// consumer, as returned by NewRing. The producer and the consumer may run
+// in goroutines of their own, without locks, while the read methods must
+// not be called concurrently with each other, and neither may the write
+// methods.
+type Ring struct {
+	head uint64 // read position, owned by the consumer
+	tail uint64 // write position, owned by the producer
+
+	buf []byte
+
+	readable chan struct{} // signals new data
+	writable chan struct{} // signals new room
+	closed   chan struct{} // signals Close
+
+	readTimer  *time.Timer // lazy init, reusable, consumer owned
+	writeTimer *time.Timer // lazy init, reusable, producer owned
+
+	closeOnce sync.Once
+}
+
+// NewRing returns a new queue with room for size bytes.
+func NewRing(size int) *Ring {
+	return &Ring{
+		buf:      make([]byte, size),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Len returns the number of bytes pending for the consumer.
+func (r *Ring) Len() int {
+	return int(atomic.LoadUint64(&r.tail) - atomic.LoadUint64(&r.head))
+}
+
+// Cap returns the capacity in bytes.
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
+
+// TryWrite copies as much of p as fits, without wait, and it returns the
+// number of bytes copied.
+func (r *Ring) TryWrite(p []byte) int {
+	tail := r.tail // producer owned
+	room := len(r.buf) - int(tail-atomic.LoadUint64(&r.head))
+	if len(p) > room {
+		p = p[:room]
+	}
+	if len(p) == 0 {
+		return 0
+	}
+	i := int(tail % uint64(len(r.buf)))
+	n := copy(r.buf[i:], p)
+	copy(r.buf, p[n:])
+	atomic.StoreUint64(&r.tail, tail+uint64(len(p)))
+	signal(r.readable)
+	return len(p)
+}
+
+// TryRead copies as much pending data into p as fits, without wait, and
+// it returns the number of bytes copied.
+func (r *Ring) TryRead(p []byte) int {
+	head := r.head // consumer owned
+	pending := int(atomic.LoadUint64(&r.tail) - head)
+	if len(p) > pending {
+		p = p[:pending]
+	}
+	if len(p) == 0 {
+		return 0
+	}
+	i := int(head % uint64(len(r.buf)))
+	n := copy(p, r.buf[i:])
+	copy(p[n:], r.buf)
+	atomic.StoreUint64(&r.head, head+uint64(len(p)))
+	signal(r.writable)
+	return len(p)
+}
+
+// WriteTimeout copies all of p, and it waits for room up to d in total.
+// Expiry gives ErrWriteBufferFull, with n for the bytes copied. Zero d
+// waits for nothing, while negative d waits without limit. Writes after
+// Close fail with io.ErrClosedPipe.
+func (r *Ring) WriteTimeout(p []byte, d time.Duration) (n int, err error) {
+	var expire <-chan time.Time // lazy init; nil blocks
+	defer func() {
+		if expire != nil {
+			stopStdTimer(r.writeTimer)
+		}
+	}()
+	for {
+		select {
+		case <-r.closed:
+			return n, io.ErrClosedPipe
+		default:
+		}
+		n += r.TryWrite(p[n:])
+		if n >= len(p) {
+			return n, nil
+		}
+		if d == 0 {
+			return n, ErrWriteBufferFull
+		}
+		if expire == nil && d > 0 {
+			expire = resetStdTimer(&r.writeTimer, d)
+		}
+		select {
+		case <-r.writable:
+		case <-r.closed:
+		case <-expire:
+			expire = nil // fired
+			return n, ErrWriteBufferFull
+		}
+	}
+}
+
+// ReadTimeout copies pending data into p, and it waits for data up to d.
+// Expiry gives ErrNoData. Zero d waits for nothing, while negative d waits
+// without limit. Reads give io.EOF once Close happened, and once all data
+// was read.
+func (r *Ring) ReadTimeout(p []byte, d time.Duration) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var expire <-chan time.Time // lazy init; nil blocks
+	defer func() {
+		if expire != nil {
+			stopStdTimer(r.readTimer)
+		}
+	}()
+	for {
+		if n := r.TryRead(p); n != 0 {
+			return n, nil
+		}
+		select {
+		case <-r.closed:
+			// data before Close may race the flag
+			if n := r.TryRead(p); n != 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		default:
+		}
+		if d == 0 {
+			return 0, ErrNoData
+		}
+		if expire == nil && d > 0 {
+			expire = resetStdTimer(&r.readTimer, d)
+		}
+		select {
+		case <-r.readable:
+		case <-r.closed:
+		case <-expire:
+			expire = nil // fired
+			return 0, ErrNoData
+		}
+	}
+}
+
+// Close ends the writes. Reads get the pending data, and io.EOF after.
+// Close must be called by the producer, or when the producer is done.
+func (r *Ring) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+// resetStdTimer arms *t for d, regardless of its prior state.
+func resetStdTimer(t **time.Timer, d time.Duration) <-chan time.Time {
+	if *t == nil {
+		*t = time.NewTimer(d)
+	} else {
+		stopStdTimer(*t)
+		(*t).Reset(d)
+	}
+	return (*t).C
+}
+
+// stopStdTimer disarms t, including any expiry not received yet.
+func stopStdTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+			// received already
+		}
+	}
+}
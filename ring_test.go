@@ -0,0 +1,76 @@
+package nbio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// Ring must pass data in order, across the wrap-around.
+func TestRing(t *testing.T) {
+	r := NewRing(5)
+	if n := r.TryWrite([]byte(feed)); n != 5 {
+		t.Errorf("TryWrite got %d bytes in, want 5", n)
+	}
+	if n, err := r.WriteTimeout([]byte("!"), 9*time.Millisecond); n != 0 || err != ErrWriteBufferFull {
+		t.Errorf("WriteTimeout on full ring = (%d, %v), want (0, %v)", n, err, ErrWriteBufferFull)
+	}
+	buf := make([]byte, 3)
+	if n := r.TryRead(buf); string(buf[:n]) != "Hel" {
+		t.Errorf("TryRead got %q, want %q", buf[:n], "Hel")
+	}
+	if n := r.TryWrite([]byte(feed[5:])); n != 3 {
+		t.Errorf("TryWrite got %d bytes in, want 3", n)
+	}
+	if got := r.Len(); got != 5 {
+		t.Errorf("got length %d, want 5", got)
+	}
+	buf = make([]byte, 8)
+	if n, err := r.ReadTimeout(buf, 0); string(buf[:n]) != "lo Wo" || err != nil {
+		t.Errorf("ReadTimeout = (%d, %v) %q, want (5, <nil>) %q", n, err, buf[:n], "lo Wo")
+	}
+	if n, err := r.ReadTimeout(buf, 9*time.Millisecond); n != 0 || err != ErrNoData {
+		t.Errorf("ReadTimeout on empty ring = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+}
+
+// Ring must pass a stream from a producer to a consumer in full.
+func TestRingStream(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	r := NewRing(1000)
+	go func() {
+		for i := 0; i < len(payload); i += 333 {
+			end := i + 333
+			if end > len(payload) {
+				end = len(payload)
+			}
+			if _, err := r.WriteTimeout(payload[i:end], -1); err != nil {
+				t.Error("WriteTimeout error:", err)
+			}
+		}
+		r.Close()
+	}()
+
+	var got bytes.Buffer
+	buf := make([]byte, 777)
+	for {
+		n, err := r.ReadTimeout(buf, time.Second)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("ReadTimeout error:", err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), payload) {
+		t.Errorf("got %d bytes, want the %d bytes of payload", got.Len(), len(payload))
+	}
+	if _, err := r.WriteTimeout([]byte(feed), 0); err != io.ErrClosedPipe {
+		t.Errorf("WriteTimeout after Close got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
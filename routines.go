@@ -0,0 +1,98 @@
+package nbio
+
+import "sync"
+
+// Routines limits the number of read routines which read at once, for any
+// number of Readers with WithRoutines, as returned by NewRoutines. Readers
+// wait for a slot before their first read on source, and they hold on to
+// the slot until their read routine terminates.
+type Routines struct {
+	mutex   sync.Mutex
+	free    int              // slots available
+	seq     uint64           // arrival count
+	waiters []*routineWaiter // pending in any order
+}
+
+// RoutineWaiter is a Reader pending on a slot.
+type routineWaiter struct {
+	priority int
+	seq      uint64        // arrival order
+	granted  chan struct{} // closed on grant
+}
+
+// NewRoutines returns a new budget of n read routines. Free slots go to the
+// waiter with the highest priority first, and to the one which waits the
+// longest among equals. High priority readers may starve the others for
+// as long as they keep coming. Each grant which passes over a waiter
+// raises its priority by one, to prevent indefinite starvation. A waiter
+// thus gets its slot after the priority difference in grants at most, i.e.,
+// the lower the priority, the longer the wait on contention.
+func NewRoutines(n int) *Routines {
+	return &Routines{free: n}
+}
+
+// Waiting returns the number of Readers pending on a slot.
+func (rs *Routines) Waiting() int {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return len(rs.waiters)
+}
+
+// Join registers a waiter, which may be granted a slot right away.
+func (rs *Routines) join(priority int) *routineWaiter {
+	w := &routineWaiter{
+		priority: priority,
+		granted:  make(chan struct{}),
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.free > 0 && len(rs.waiters) == 0 {
+		rs.free--
+		close(w.granted)
+		return w
+	}
+	rs.seq++
+	w.seq = rs.seq
+	rs.waiters = append(rs.waiters, w)
+	return w
+}
+
+// Leave withdraws w, including any slot granted.
+func (rs *Routines) leave(w *routineWaiter) {
+	rs.mutex.Lock()
+	for i, pending := range rs.waiters {
+		if pending == w {
+			rs.waiters = append(rs.waiters[:i], rs.waiters[i+1:]...)
+			rs.mutex.Unlock()
+			return
+		}
+	}
+	rs.mutex.Unlock()
+	// granted already
+	rs.release()
+}
+
+// Release passes a slot on to the next waiter, if any.
+func (rs *Routines) release() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if len(rs.waiters) == 0 {
+		rs.free++
+		return
+	}
+
+	next := 0
+	for i, w := range rs.waiters {
+		best := rs.waiters[next]
+		if w.priority > best.priority || w.priority == best.priority && w.seq < best.seq {
+			next = i
+		}
+	}
+	w := rs.waiters[next]
+	rs.waiters = append(rs.waiters[:next], rs.waiters[next+1:]...)
+	for _, skipped := range rs.waiters {
+		skipped.priority++
+	}
+	close(w.granted)
+}
@@ -0,0 +1,118 @@
+package nbio
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// OrderSource reports its name on the first read.
+type orderSource struct {
+	name  string
+	order chan<- string
+	io.ReadCloser
+}
+
+func (s *orderSource) Read(p []byte) (int, error) {
+	if s.order != nil {
+		s.order <- s.name
+		s.order = nil
+	}
+	return s.ReadCloser.Read(p)
+}
+
+// Non blocking Readers must get their read routine in order of priority.
+func TestRoutinesPriority(t *testing.T) {
+	rs := NewRoutines(1)
+	order := make(chan string, 3)
+	var writers []*io.PipeWriter
+	defer func() {
+		for _, pw := range writers {
+			pw.Close()
+		}
+	}()
+	newReader := func(name string, priority int) *Reader {
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+		return NewReaderOptions(&orderSource{name, order, pr},
+			WithTimeout(9*time.Millisecond),
+			WithRoutines(rs, priority))
+	}
+
+	holder := newReader("holder", 0)
+	defer holder.Close()
+	if got := <-order; got != "holder" {
+		t.Fatalf("got first read from %q, want the holder", got)
+	}
+	low := newReader("low", 0)
+	defer low.Close()
+	high := newReader("high", 1)
+	defer high.Close()
+	time.Sleep(9 * time.Millisecond)
+	if n := rs.Waiting(); n != 2 {
+		t.Errorf("got %d waiting, want 2", n)
+	}
+	if n, err := low.Read(make([]byte, 1)); n != 0 || err != ErrNoData {
+		t.Errorf("Read while waiting = (%d, %v), want (0, %v)", n, err, ErrNoData)
+	}
+	if got := low.LastWaitReason(); got != WaitRoutine {
+		t.Errorf("got wait reason %q, want %q", got, WaitRoutine)
+	}
+
+	holder.Close()
+	if got := <-order; got != "high" {
+		t.Errorf("got read from %q after release, want high", got)
+	}
+	high.Close()
+	if got := <-order; got != "low" {
+		t.Errorf("got read from %q after release, want low", got)
+	}
+}
+
+// Routines must not starve low priority waiters for good.
+func TestRoutinesAging(t *testing.T) {
+	rs := NewRoutines(1)
+	rs.join(0) // holder
+	low := rs.join(0)
+
+	for round := 1; ; round++ {
+		high := rs.join(1)
+		rs.release()
+		select {
+		case <-low.granted:
+			if round != 2 {
+				t.Errorf("low priority got its slot in round %d, want 2", round)
+			}
+			return
+		case <-high.granted:
+			if round > 2 {
+				t.Fatalf("low priority still waiting after round %d", round)
+			}
+		}
+	}
+}
+
+// Non blocking Reader must leave the wait for a slot on Close.
+func TestRoutinesClose(t *testing.T) {
+	rs := NewRoutines(1)
+	rs.join(0) // holder
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewReaderOptions(pr, WithRoutines(rs, 0))
+	time.Sleep(9 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+	if n := rs.Waiting(); n != 0 {
+		t.Errorf("got %d waiting after Close, want 0", n)
+	}
+
+	// slot of the holder is free
+	rs.release()
+	select {
+	case <-rs.join(0).granted:
+	default:
+		t.Error("join after release got no slot")
+	}
+}
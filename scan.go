@@ -0,0 +1,125 @@
+package nbio
+
+import (
+	"bufio"
+	"io"
+)
+
+// Scanner is a non blocking tokenizer with bufio.SplitFunc compatibility,
+// as returned by NewScanner.
+type Scanner struct {
+	r     *Reader
+	split bufio.SplitFunc
+
+	token []byte // last token
+	err   error  // sticky error, or ErrNoData
+	done  bool   // bufio.ErrFinalToken seen
+}
+
+// NewScanner returns a new tokenizer on r, which splits lines, like
+// bufio.NewScanner does. Tokens which exceed the read-ahead of all the
+// buffers of r fail with ErrBufferFull. The unread byte of UnreadByte is
+// not seen.
+func NewScanner(r *Reader) *Scanner {
+	return &Scanner{r: r, split: bufio.ScanLines}
+}
+
+// Split sets the split function, which defaults to bufio.ScanLines. It
+// must be called before use of Scan.
+func (s *Scanner) Split(split bufio.SplitFunc) {
+	s.split = split
+}
+
+// Scan advances to the next token, which is then available through the
+// Bytes and Text methods. A token which does not complete in time makes
+// Scan return false, with ErrNoData from Err, i.e., no token yet. The
+// partial token remains buffered for the next Scan in such case. Any other
+// error is sticky, including io.EOF, and so is the end of tokens from the
+// split function with bufio.ErrFinalToken.
+func (s *Scanner) Scan() bool {
+	s.token = nil
+	if s.done || (s.err != nil && s.err != ErrNoData) {
+		return false
+	}
+	s.err = nil
+	r := s.r
+
+	for {
+		var advance int
+		var token []byte
+		var splitErr error
+		_, err := r.ScanAhead(func(unread []byte) (int, bool) {
+			advance, token, splitErr = s.split(unread, false)
+			return 0, advance != 0 || token != nil || splitErr != nil
+		})
+		atEOF := err != nil
+		if atEOF {
+			if err != r.PeekErr() {
+				// no source error, e.g., ErrNoData
+				r.track(0, err)
+				s.err = err
+				return false
+			}
+			// remainder before the source error
+			var rest []byte
+			if r.buf != nil {
+				rest = r.buf[r.i:]
+			}
+			advance, token, splitErr = s.split(rest, true)
+		}
+
+		if splitErr == bufio.ErrFinalToken {
+			s.done = true
+		} else if splitErr != nil {
+			s.err = splitErr
+			return false
+		}
+		var avail int
+		if r.buf != nil {
+			avail = len(r.buf) - r.i
+		}
+		switch {
+		case advance < 0:
+			s.err = bufio.ErrNegativeAdvance
+			return false
+		case advance > avail:
+			s.err = bufio.ErrAdvanceTooFar
+			return false
+		case advance != 0:
+			r.consumeView(r.buf[r.i:r.i+advance], nil)
+		}
+
+		if token != nil {
+			s.token = token
+			return true
+		}
+		if s.done {
+			return false
+		}
+		if atEOF && advance == 0 {
+			s.err = err
+			return false
+		}
+	}
+}
+
+// Bytes returns the token of the last Scan. The slice is valid until the
+// next Scan, or any other read on the Reader.
+func (s *Scanner) Bytes() []byte {
+	return s.token
+}
+
+// Text returns the token of the last Scan as a string.
+func (s *Scanner) Text() string {
+	return string(s.token)
+}
+
+// Err returns the error of the last Scan, if any. The return is nil on
+// io.EOF, like bufio.Scanner does. ErrNoData means that no token is
+// complete yet, after which Scan may be called again.
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
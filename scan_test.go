@@ -0,0 +1,46 @@
+package nbio
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+// Non blocking Scanner must retain partial tokens on timeout.
+func TestScan(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+	s := NewScanner(r)
+	s.Split(bufio.ScanWords)
+
+	go pw.Write([]byte("GPGGA 1234"))
+	if !s.Scan() {
+		t.Fatal("no token; error:", s.Err())
+	}
+	if got := s.Text(); got != "GPGGA" {
+		t.Errorf("got token %q, want %q", got, "GPGGA")
+	}
+	if s.Scan() || s.Err() != ErrNoData {
+		t.Errorf("Scan on partial = (%q, %v), want ErrNoData", s.Text(), s.Err())
+	}
+
+	go func() {
+		pw.Write([]byte("19 4807"))
+		pw.Close()
+	}()
+	for _, want := range []string{"123419", "4807"} {
+		if !s.Scan() {
+			t.Fatal("no token; error:", s.Err())
+		}
+		if got := s.Text(); got != want {
+			t.Errorf("got token %q, want %q", got, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if s.Scan() || s.Err() != nil {
+			t.Errorf("Scan %d at end = (%q, %v), want no token nor error", i, s.Text(), s.Err())
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package nbio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats has counters of a Reader since construction.
+type Stats struct {
+	// Delivered is the number of bytes returned by reads.
+	Delivered uint64
+	// Timeouts is the number of reads which gave ErrNoData.
+	Timeouts uint64
+	// Handoffs is the number of buffers passed by the read routine.
+	Handoffs uint64
+	// Reads is the number of Read calls.
+	Reads uint64
+	// Waited is the total amount of time reads spent waiting on data.
+	Waited time.Duration
+	// Stalls is the number of times the read routine found all buffers
+	// occupied, i.e., it waited on consumption.
+	Stalls uint64
+	// Failed is whether the source gave a sticky error.
+	Failed bool
+
+	// QueueSamples is the number of queue depth samples, which requires
+	// WithQueueSampling. The queue depth is the number of buffers which
+	// wait for Read, as seen by the read routine on each handoff. The
+	// fields below are zero without samples.
+	QueueSamples uint64
+	// QueueMin is the lowest queue depth sampled.
+	QueueMin int
+	// QueueMax is the highest queue depth sampled.
+	QueueMax int
+	// QueueAvg is the mean queue depth sampled. A high average means the
+	// consumer lags, while one near zero means the source is the limit.
+	QueueAvg float64
+}
+
+// Stats holds the atomic counters.
+type stats struct {
+	delivered uint64
+	timeouts  uint64
+	handoffs  uint64
+	reads     uint64
+	waited    int64 // nanoseconds
+	stalls    uint64
+
+	queueSamples uint64
+	queueSum     uint64
+	queueMin     int64
+	queueMax     int64
+}
+
+// Stats returns a snapshot of the counters. It is safe for use from any
+// goroutine.
+func (r *Reader) Stats() Stats {
+	s := Stats{
+		Delivered: atomic.LoadUint64(&r.stats.delivered),
+		Timeouts:  atomic.LoadUint64(&r.stats.timeouts),
+		Handoffs:  atomic.LoadUint64(&r.stats.handoffs),
+		Reads:     atomic.LoadUint64(&r.stats.reads),
+		Waited:    time.Duration(atomic.LoadInt64(&r.stats.waited)),
+		Stalls:    atomic.LoadUint64(&r.stats.stalls),
+		Failed:    r.PeekErr() != nil,
+	}
+	// sum first, as samples may advance in between
+	sum := atomic.LoadUint64(&r.stats.queueSum)
+	if n := atomic.LoadUint64(&r.stats.queueSamples); n != 0 {
+		s.QueueSamples = n
+		s.QueueMin = int(atomic.LoadInt64(&r.stats.queueMin))
+		s.QueueMax = int(atomic.LoadInt64(&r.stats.queueMax))
+		s.QueueAvg = float64(sum) / float64(n)
+	}
+	return s
+}
+
+// SampleQueue records the depth of next, at most once per interval of
+// WithQueueSampling. Only the read routine may call sampleQueue.
+func (r *Reader) sampleQueue() {
+	now := r.clock.Now()
+	if now.Sub(r.queueLast) < r.queueEvery {
+		return
+	}
+	r.queueLast = now
+
+	depth := int64(len(r.next))
+	n := atomic.LoadUint64(&r.stats.queueSamples)
+	if n == 0 || depth < atomic.LoadInt64(&r.stats.queueMin) {
+		atomic.StoreInt64(&r.stats.queueMin, depth)
+	}
+	if depth > atomic.LoadInt64(&r.stats.queueMax) {
+		atomic.StoreInt64(&r.stats.queueMax, depth)
+	}
+	atomic.AddUint64(&r.stats.queueSum, uint64(depth))
+	atomic.AddUint64(&r.stats.queueSamples, 1)
+}
+
+// Observer receives the events of a Reader, e.g., to feed a metrics system
+// without any dependency in this package. The calls happen on the goroutine
+// of Read, except for Closed, which happens on the goroutine of Close.
+type Observer interface {
+	// ReadDone is called on each read with data.
+	ReadDone(n int)
+	// Timeout is called on each read which gives ErrNoData.
+	Timeout()
+	// Error is called on each read which fails otherwise, including io.EOF.
+	Error(err error)
+	// Closed is called on the first Close.
+	Closed()
+}
+
+// SetObserver installs o for the events of r. The default is none, which
+// costs nothing. It must be called before use of Read.
+func (r *Reader) SetObserver(o Observer) {
+	r.observer = o
+}
+
+// Observe passes a read outcome to the observer.
+func (r *Reader) observe(n int, err error) {
+	switch {
+	case n != 0:
+		r.observer.ReadDone(n)
+	case err == ErrNoData:
+		r.observer.Timeout()
+	case err != nil:
+		r.observer.Error(err)
+	}
+}
+
+// WriterStats has counters of a Writer since construction.
+type WriterStats struct {
+	// Accepted is the number of bytes taken by writes.
+	Accepted uint64
+	// Writes is the number of Write calls.
+	Writes uint64
+	// Timeouts is the number of writes which gave ErrWriteBufferFull.
+	Timeouts uint64
+	// Stalls is the number of times a write found all buffers occupied.
+	Stalls uint64
+	// Dropped is the number of bytes discarded by the FullPolicy.
+	Dropped uint64
+	// Failed is whether the sink gave a sticky error.
+	Failed bool
+}
+
+// WriterStats holds the atomic counters.
+type writerStats struct {
+	accepted uint64
+	writes   uint64
+	timeouts uint64
+	stalls   uint64
+}
+
+// Stats returns a snapshot of the counters. It is safe for use from any
+// goroutine.
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Accepted: atomic.LoadUint64(&w.stats.accepted),
+		Writes:   atomic.LoadUint64(&w.stats.writes),
+		Timeouts: atomic.LoadUint64(&w.stats.timeouts),
+		Stalls:   atomic.LoadUint64(&w.stats.stalls),
+		Dropped:  w.Dropped(),
+		Failed:   w.stickyErr() != nil,
+	}
+}
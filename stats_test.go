@@ -0,0 +1,159 @@
+package nbio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must count deliveries, timeouts and handoffs.
+func TestStats(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 0 || err != ErrNoData {
+			t.Fatalf("Read %d = (%d, %v), want (0, %v)", i, n, err, ErrNoData)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		pw.Write([]byte(feed))
+		if n, err := r.Read(buf); n != len(feed) || err != nil {
+			t.Fatalf("Read %d = (%d, %v), want (%d, <nil>)", i, n, err, len(feed))
+		}
+	}
+	want := Stats{Delivered: 3 * uint64(len(feed)), Timeouts: 2, Handoffs: 3, Reads: 5}
+	got := r.Stats()
+	if got.Waited < 2*9*time.Millisecond {
+		t.Errorf("got %s waited, want at least the 2 timeouts", got.Waited)
+	}
+	got.Waited = 0
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	pw.CloseWithError(errOnClose)
+	if n, err := r.Read(buf); n != 0 || err != errOnClose {
+		t.Fatalf("Read = (%d, %v), want (0, %v)", n, err, errOnClose)
+	}
+	want.Failed = true
+	want.Reads++
+	got = r.Stats()
+	got.Waited = 0
+	if got != want {
+		t.Errorf("after error got %+v, want %+v", got, want)
+	}
+}
+
+// Non blocking Reader must count stalls on full buffers.
+func TestStatsStalls(t *testing.T) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Stats().Stalls; got != 1 {
+		t.Errorf("got %d stalls, want 1", got)
+	}
+}
+
+// Non blocking Reader must sample the queue depth on handoffs.
+func TestStatsQueueSampling(t *testing.T) {
+	r := NewReaderOptions(ioutil.NopCloser(zeroReader{}),
+		WithTimeout(time.Second),
+		WithBufferCount(5),
+		WithQueueSampling(time.Nanosecond))
+	defer r.Close()
+
+	// consumer lags
+	time.Sleep(9 * time.Millisecond)
+	got := r.Stats()
+	if got.QueueSamples == 0 {
+		t.Fatal("got no queue samples")
+	}
+	// room for 3 pending of 5 buffers
+	if got.QueueMin != 0 || got.QueueMax != 3 {
+		t.Errorf("got queue depth min %d and max %d, want 0 and 3", got.QueueMin, got.QueueMax)
+	}
+	if got.QueueAvg <= 0 || got.QueueAvg > 3 {
+		t.Errorf("got average queue depth %f, want in (0, 3]", got.QueueAvg)
+	}
+
+	// off by default
+	r = NewReaderOptions(ioutil.NopCloser(zeroReader{}), WithTimeout(time.Second))
+	defer r.Close()
+	time.Sleep(9 * time.Millisecond)
+	if got := r.Stats(); got.QueueSamples != 0 || got.QueueMax != 0 {
+		t.Errorf("got %d queue samples with max %d without sampling", got.QueueSamples, got.QueueMax)
+	}
+}
+
+// Non blocking Writer must count writes, timeouts and stalls.
+func TestWriterStats(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := NewWriter(pw, 9*time.Millisecond)
+	defer func() {
+		go ioutil.ReadAll(pr)
+		w.Close()
+	}()
+
+	w.Write([]byte(feed))
+	// await the write routine
+	time.Sleep(9 * time.Millisecond)
+	chunk := make([]byte, 3*bufferSize)
+	n, err := w.Write(chunk)
+	if err != ErrWriteBufferFull {
+		t.Fatalf("Write on full buffers = (%d, %v), want ErrWriteBufferFull", n, err)
+	}
+	got := w.Stats()
+	want := WriterStats{Accepted: uint64(len(feed) + n), Writes: 2, Timeouts: 1, Stalls: 1}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// RecordObserver keeps each event.
+type recordObserver []string
+
+func (o *recordObserver) ReadDone(n int)  { *o = append(*o, fmt.Sprint("read ", n)) }
+func (o *recordObserver) Timeout()        { *o = append(*o, "timeout") }
+func (o *recordObserver) Error(err error) { *o = append(*o, "error "+err.Error()) }
+func (o *recordObserver) Closed()         { *o = append(*o, "closed") }
+
+// Non blocking Reader must pass its events to the observer.
+func TestObserver(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, 9*time.Millisecond)
+	var events recordObserver
+	r.SetObserver(&events)
+
+	buf := make([]byte, 64)
+	r.Read(buf)
+	go pw.Write([]byte(feed))
+	r.Read(buf)
+	pw.Close()
+	r.Read(buf)
+	r.Close()
+	r.Close()
+
+	want := []string{"timeout", "read 12", "error EOF", "closed"}
+	if !reflect.DeepEqual([]string(events), want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
+
+// Non blocking Reader must not allocate without observer.
+func BenchmarkReadNoObserver(b *testing.B) {
+	r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+	defer r.Close()
+	buf := make([]byte, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Read(buf)
+	}
+}
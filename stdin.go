@@ -0,0 +1,61 @@
+package nbio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// StdinMaxLine is the line size limit of ReadStdinLine.
+const stdinMaxLine = 1 << 16
+
+// Stdin has the lines of os.Stdin, with one read routine for the lifetime
+// of the process. The standard input can not be closed in a meaningful way,
+// i.e., a read routine per Reader would stay stuck in a read after Close.
+var stdin = struct {
+	once  sync.Once
+	lines *sharedLines
+}{}
+
+// SharedLines serializes line reads with a timeout per call.
+type sharedLines struct {
+	mutex sync.Mutex
+	l     *LineReader
+}
+
+func newSharedLines(source io.Reader) *sharedLines {
+	return &sharedLines{l: NewBoundedLineReader(ioutil.NopCloser(source), 0, stdinMaxLine)}
+}
+
+func (s *sharedLines) readLine(timeout time.Duration) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.l.r.timeout = timeout
+	line, err := s.l.ReadLine()
+	return string(bytes.TrimSuffix(line, []byte{'\r'})), err
+}
+
+// ReadStdinLine returns the next line of os.Stdin, without its line ending.
+// ReadStdinLine fails with ErrNoData when no line completes within timeout,
+// with the semantics of NewReader otherwise. Any input typed so far is
+// retained for the next call in such case. All calls share one read routine
+// on os.Stdin, which runs until the end of the process, as the standard
+// input can not be closed to stop it. Lines are limited to 64 KiB, with
+// ErrLineTooLong. Errors are sticky, including io.EOF. Once in use, there
+// must be no other reads on os.Stdin, as input goes to the read routine.
+func ReadStdinLine(timeout time.Duration) (string, error) {
+	stdin.once.Do(func() { stdin.lines = newSharedLines(os.Stdin) })
+	return stdin.lines.readLine(timeout)
+}
+
+// Prompt writes prompt to os.Stdout, and then it reads an answer with
+// ReadStdinLine, e.g., Prompt("Continue? [y/N] ", 10*time.Second).
+func Prompt(prompt string, timeout time.Duration) (string, error) {
+	if _, err := io.WriteString(os.Stdout, prompt); err != nil {
+		return "", err
+	}
+	return ReadStdinLine(timeout)
+}
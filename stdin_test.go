@@ -0,0 +1,27 @@
+package nbio
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// Stdin lines must time out per call, and retain partial input.
+func TestStdinLines(t *testing.T) {
+	pr, pw := io.Pipe()
+	s := newSharedLines(pr)
+	defer s.l.Close()
+	defer pw.Close()
+
+	if line, err := s.readLine(9 * time.Millisecond); line != "" || err != ErrNoData {
+		t.Errorf("idle: got line %q with error %v, want ErrNoData", line, err)
+	}
+	go pw.Write([]byte("y"))
+	if line, err := s.readLine(9 * time.Millisecond); line != "" || err != ErrNoData {
+		t.Errorf("partial: got line %q with error %v, want ErrNoData", line, err)
+	}
+	go pw.Write([]byte("es\r\n"))
+	if line, err := s.readLine(time.Second); line != "yes" || err != nil {
+		t.Errorf("got line %q with error %v, want %q", line, err, "yes")
+	}
+}
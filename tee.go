@@ -0,0 +1,35 @@
+package nbio
+
+import "errors"
+
+// ErrTeeFull signals a copy from Tee which did not fit in the buffers of
+// the Writer.
+var ErrTeeFull = errors.New("tee writer buffers full")
+
+// Tee makes the read routine copy all data read from source to w, before
+// Read gets to see it, e.g., for traffic capture. The writes on w never
+// wait, such that a slow sink of w can not stall the reads. The FullPolicy
+// of w applies to copies which do not fit. FullDropNew and FullDropOldest
+// drop data from the copy, as counted by the Dropped method of w. With
+// FullWait, the default, a copy which does not fit fails the Reader with
+// ErrTeeFull instead, which keeps the copy free of gaps. Any error
+// of the sink of w fails the Reader too.
+//
+// The Reader does not close w. Writes on w must be left to the Reader. A
+// nil w stops the copies. Tee may be called from any goroutine.
+func (r *Reader) Tee(w *Writer) {
+	r.tee.Store(w)
+}
+
+// teeCopy passes p to the Writer of Tee, if any.
+func (r *Reader) teeCopy(p []byte) error {
+	w, _ := r.tee.Load().(*Writer)
+	if w == nil {
+		return nil
+	}
+	_, err := w.write(p, 0)
+	if err == ErrWriteBufferFull {
+		err = ErrTeeFull
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package nbio
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Non blocking Reader must copy all data from source to the Tee.
+func TestTee(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr, time.Second)
+	defer r.Close()
+	sink := new(recordSink)
+	w := NewWriter(sink, time.Second)
+	defer w.Close()
+	r.Tee(w)
+
+	go pw.Write([]byte(feed))
+	buf := make([]byte, len(feed))
+	if n, err := r.Read(buf); n != len(feed) || err != nil {
+		t.Fatalf("Read = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Flush error:", err)
+	}
+	sink.Lock()
+	got := strings.Join(sink.writes, "")
+	sink.Unlock()
+	if got != feed {
+		t.Errorf("tee got %q, want %q", got, feed)
+	}
+}
+
+// Non blocking Reader must not stall on a slow Tee.
+func TestTeeFull(t *testing.T) {
+	for _, policy := range []FullPolicy{FullDropNew, FullWait} {
+		sinkR, sinkW := io.Pipe() // stuck until close
+		w := NewWriter(sinkW, time.Second)
+		w.SetFullPolicy(policy)
+		r := NewReader(ioutil.NopCloser(zeroReader{}), time.Second)
+		r.Tee(w)
+
+		var total int
+		var err error
+		buf := make([]byte, 1024)
+		for total < 1<<20 && err == nil {
+			var n int
+			n, err = r.Read(buf)
+			total += n
+		}
+		switch policy {
+		case FullDropNew:
+			if err != nil {
+				t.Errorf("drop policy: Read error: %v", err)
+			}
+			if w.Dropped() == 0 {
+				t.Error("drop policy: no bytes dropped")
+			}
+		case FullWait:
+			if err != ErrTeeFull {
+				t.Errorf("wait policy: got Read error %v after %d bytes, want %v", err, total, ErrTeeFull)
+			}
+		}
+
+		r.Close()
+		sinkR.Close()
+		w.Close()
+	}
+}
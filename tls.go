@@ -0,0 +1,75 @@
+package nbio
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// UpgradeTLS switches the source to TLS as a server, e.g., for STARTTLS.
+// It must be called once the plaintext up to the upgrade point is read,
+// with no read in progress. Any data buffered at the moment is ahead of
+// the upgrade point, which makes it the start of the TLS handshake. Such
+// data is passed to TLS rather than dropped. Reads continue with the
+// decrypted data afterwards. The source must be a net.Conn, which is
+// interrupted with a read deadline to quiesce the read routine. NetConn
+// returns the *tls.Conn on success. A sticky error from before, such as
+// io.EOF, is lost.
+func (r *Reader) UpgradeTLS(config *tls.Config) error {
+	return r.upgrade(func(conn net.Conn) net.Conn {
+		return tls.Server(conn, config)
+	})
+}
+
+// UpgradeTLSClient is like UpgradeTLS, yet it acts as the client side.
+func (r *Reader) UpgradeTLSClient(config *tls.Config) error {
+	return r.upgrade(func(conn net.Conn) net.Conn {
+		return tls.Client(conn, config)
+	})
+}
+
+func (r *Reader) upgrade(wrap func(net.Conn) net.Conn) error {
+	if r.fatal != nil {
+		return r.fatal
+	}
+	conn, ok := r.r.(net.Conn)
+	if !ok {
+		return errors.New("TLS upgrade needs a net.Conn source")
+	}
+
+	ahead, err := r.stop(conn)
+	if err != nil {
+		return err
+	}
+	// interrupt error from stop
+	<-r.err
+	if err := conn.SetReadDeadline(r.deadline); err != nil {
+		r.err <- err
+		return err
+	}
+
+	r.r = wrap(&prefixConn{Conn: conn, prefix: ahead})
+	r.failed.Store(errorBox{})
+	r.surfaced = false
+	r.buf = emptyBuf
+	r.i = 0
+	r.loose = false
+	r.next = r.makeNext()
+	go r.readRoutine()
+	return nil
+}
+
+// PrefixConn reads prefix before any data from Conn.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	if len(c.prefix) == 0 {
+		return c.Conn.Read(p)
+	}
+	n := copy(p, c.prefix)
+	c.prefix = c.prefix[n:]
+	return n, nil
+}
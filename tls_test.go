@@ -0,0 +1,94 @@
+package nbio
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestCert returns a self-signed certificate.
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nbio.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"nbio.test"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// StartTLSConn writes the upgrade command together with the first write,
+// i.e., the start of the TLS handshake.
+type startTLSConn struct {
+	net.Conn
+	sent bool
+}
+
+func (c *startTLSConn) Write(p []byte) (int, error) {
+	if c.sent {
+		return c.Conn.Write(p)
+	}
+	c.sent = true
+	_, err := c.Conn.Write(append([]byte("STARTTLS\r\n"), p...))
+	return len(p), err
+}
+
+// Non blocking Reader must pass data ahead of the upgrade to TLS.
+func TestUpgradeTLS(t *testing.T) {
+	conn, peer := net.Pipe()
+	r := NewReader(conn, time.Second)
+	defer r.Close()
+
+	client := tls.Client(&startTLSConn{Conn: peer}, &tls.Config{InsecureSkipVerify: true})
+	defer client.Close()
+	clientErr := make(chan error, 1)
+	go func() {
+		if err := client.Handshake(); err != nil {
+			clientErr <- err
+			return
+		}
+		_, err := client.Write([]byte(feed))
+		clientErr <- err
+	}()
+
+	buf := make([]byte, 10)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "STARTTLS\r\n" {
+		t.Fatalf("plaintext Read = (%d, %v) %q, want the command", n, err, buf[:n])
+	}
+	if len(r.buf)-r.i == 0 {
+		t.Fatal("test needs handshake data buffered ahead of the upgrade")
+	}
+
+	err := r.UpgradeTLS(&tls.Config{Certificates: []tls.Certificate{testCert(t)}})
+	if err != nil {
+		t.Fatal("UpgradeTLS error:", err)
+	}
+	if _, ok := r.r.(*tls.Conn); !ok {
+		t.Errorf("got source type %T after upgrade, want *tls.Conn", r.r)
+	}
+
+	buf = make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != feed {
+		t.Errorf("TLS Read = (%d, %v) %q, want %q", n, err, buf[:n], feed)
+	}
+	if err := <-clientErr; err != nil {
+		t.Error("client error:", err)
+	}
+}
@@ -0,0 +1,556 @@
+package nbio
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWriteBufferFull signals a Write which timed out on a full buffer.
+// The error satisfies net.Error, with both Timeout and Temporary true, and
+// errors.Is matches os.ErrDeadlineExceeded, like ErrNoData does.
+var ErrWriteBufferFull error = &timeoutError{"write buffer full at the moment"}
+
+// ErrConcurrentWrite signals a write while another write is in progress.
+var ErrConcurrentWrite = errors.New("concurrent write on writer")
+
+// ErrWriterClosed signals use of a Writer after Close.
+var ErrWriterClosed = errors.New("write on closed writer")
+
+// FullPolicy is the behaviour of Write on full buffers.
+type FullPolicy int
+
+const (
+	// FullWait makes Write wait for buffer space, within the timeout,
+	// with ErrWriteBufferFull on expiry. A timeout of zero makes the
+	// error immediate.
+	FullWait FullPolicy = iota
+	// FullDropNew makes Write discard the data which does not fit, as
+	// if it was written, i.e., Write does not fail on full buffers.
+	FullDropNew
+	// FullDropOldest makes Write discard the pending data which did not
+	// pass to sink yet, in favour of the new data. Write does not fail
+	// on full buffers.
+	FullDropOldest
+)
+
+// Writer is a non blocking wrapper, as returned by NewWriter.
+type Writer struct {
+	stats    writerStats // counters for Stats
+	dropped  uint64      // bytes discarded by the FullPolicy
+	isClosed int32       // Close flag
+	writing  int32       // write in progress flag
+
+	w     io.WriteCloser // sink
+	timer *time.Timer    // lazy init, reusable
+
+	// maximum amount of time to wait for buffer space
+	timeout time.Duration
+	policy  FullPolicy // Write on full buffers
+
+	next chan []byte   // pending buffer, open for more data until taken
+	pool chan []byte   // buffer recycling
+	done chan struct{} // signals write routine termination
+
+	// optional idle payload, from NewKeepaliveWriter
+	keepalive  []byte
+	kaInterval time.Duration
+
+	// optional batch delay, with signals for the write routine
+	maxDelay time.Duration
+	arrived  chan struct{} // new pending buffer
+	flushSig chan struct{} // pending buffer due now, or Close
+
+	failed atomic.Value // errorBox with sticky error of sink
+
+	closeOnce sync.Once
+	closeErr  error // Close result
+}
+
+// NewWriter returns a new non blocking wrapper whose Write function gives
+// a time out (with ErrWriteBufferFull) when the buffers did not free up in
+// time. A write routine passes the buffers to sink. A timeout of zero
+// makes Write wait for nothing, while a negative timeout makes it wait
+// without limit.
+//
+// Errors of the underlying writer are sticky. Once a write on sink fails,
+// all successive calls to Write fail with the same. Writes which did not
+// go out yet are lost then. Close passes all pending data to sink before
+// it closes sink.
+func NewWriter(sink io.WriteCloser, timeout time.Duration) *Writer {
+	w := newWriter(sink, timeout)
+	go w.writeRoutine()
+	return w
+}
+
+// NewBatchWriter returns a new Writer like NewWriter does, yet the write
+// routine lets data pend for up to maxDelay, such that small writes pass
+// to sink in batches. A full buffer, Flush, and Close cut the delay short.
+// The latency of each byte thus stays within maxDelay, plus the time sink
+// needs to take preceding data.
+func NewBatchWriter(sink io.WriteCloser, timeout, maxDelay time.Duration) *Writer {
+	w := newWriter(sink, timeout)
+	w.maxDelay = maxDelay
+	w.arrived = make(chan struct{}, 1)
+	w.flushSig = make(chan struct{}, 1)
+	go w.batchRoutine()
+	return w
+}
+
+// NewKeepaliveWriter returns a new Writer like NewWriter does, yet the
+// write routine passes payload to sink once no data went to sink for
+// interval, e.g., to keep connections through NATs and load balancers
+// alive. Each write to sink, keepalive or not, restarts interval. The
+// keepalives never interleave with data, as the write routine is the
+// only one to write on sink.
+func NewKeepaliveWriter(sink io.WriteCloser, timeout, interval time.Duration, payload []byte) *Writer {
+	w := newWriter(sink, timeout)
+	w.keepalive = append([]byte{}, payload...)
+	w.kaInterval = interval
+	go w.writeRoutine()
+	return w
+}
+
+func newWriter(sink io.WriteCloser, timeout time.Duration) *Writer {
+	w := &Writer{
+		w:       sink,
+		timeout: timeout,
+		next:    make(chan []byte, 1),
+		pool:    make(chan []byte, 3),
+		done:    make(chan struct{}),
+	}
+	// 3 write buffers cycle through next and pool
+	mem := make([]byte, 3*bufferSize)
+	for i := 0; i < 3; i++ {
+		w.pool <- mem[i*bufferSize : i*bufferSize : (i+1)*bufferSize]
+	}
+	return w
+}
+
+// writeRoutine passes next to sink until Close. Buffers are discarded
+// once sink failed.
+func (w *Writer) writeRoutine() {
+	defer close(w.done)
+
+	var idle *time.Timer
+	var idleC <-chan time.Time // nil blocks
+	if w.keepalive != nil {
+		idle = time.NewTimer(w.kaInterval)
+		defer idle.Stop()
+		idleC = idle.C
+	}
+	for {
+		select {
+		case buf, ok := <-w.next:
+			if !ok {
+				return
+			}
+			w.pass(buf)
+			w.pool <- buf[:0]
+		case <-idleC:
+			w.pass(w.keepalive)
+		}
+
+		if idle != nil {
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+					// received already
+				}
+			}
+			idle.Reset(w.kaInterval)
+		}
+	}
+}
+
+// pass writes p to sink, unless sink failed before.
+func (w *Writer) pass(p []byte) {
+	if w.stickyErr() != nil {
+		return
+	}
+	if _, err := w.w.Write(p); err != nil {
+		w.failed.Store(errorBox{err})
+	}
+}
+
+// batchRoutine is the writeRoutine of NewBatchWriter.
+func (w *Writer) batchRoutine() {
+	defer close(w.done)
+
+	delay := time.NewTimer(w.maxDelay)
+	defer delay.Stop()
+	for {
+		select {
+		case <-w.arrived:
+			// linger for more data
+			if !delay.Stop() {
+				select {
+				case <-delay.C:
+				default:
+				}
+			}
+			delay.Reset(w.maxDelay)
+			select {
+			case <-delay.C:
+			case <-w.flushSig:
+			}
+		case <-w.flushSig:
+		}
+
+		var buf []byte
+		select {
+		case b, ok := <-w.next:
+			if !ok {
+				return
+			}
+			buf = b
+		default:
+			continue // none pending
+		}
+		w.pass(buf)
+		w.pool <- buf[:0]
+	}
+}
+
+// Write implements the io.Writer interface. The return counts the bytes
+// accepted for the write routine. A Write which could not pass all of p
+// within the timeout fails with ErrWriteBufferFull, in which case the
+// remainder is not written at all. Write must not be called concurrently
+// with itself, nor with Close. A concurrent Write, or ReadFrom, fails with
+// ErrConcurrentWrite, without any effect on the write in progress.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	return w.write(p, w.timeout)
+}
+
+func (w *Writer) write(p []byte, timeout time.Duration) (n int, err error) {
+	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
+		return 0, ErrConcurrentWrite
+	}
+	defer atomic.StoreInt32(&w.writing, 0)
+
+	atomic.AddUint64(&w.stats.writes, 1)
+	defer func() {
+		atomic.AddUint64(&w.stats.accepted, uint64(n))
+		if err == ErrWriteBufferFull {
+			atomic.AddUint64(&w.stats.timeouts, 1)
+		}
+	}()
+	if atomic.LoadInt32(&w.isClosed) != 0 {
+		return 0, ErrWriterClosed
+	}
+
+	var expire <-chan time.Time // lazy init; nil blocks
+	for len(p) != 0 {
+		if err := w.stickyErr(); err != nil {
+			if expire != nil {
+				w.stopTimer()
+			}
+			return n, err
+		}
+
+		// append to the pending buffer, if any
+		select {
+		case buf := <-w.next:
+			if len(buf) < cap(buf) {
+				did := copy(buf[len(buf):cap(buf)], p)
+				w.next <- buf[:len(buf)+did]
+				n += did
+				p = p[did:]
+				continue
+			}
+			w.next <- buf // full; still first in line
+			signal(w.flushSig)
+		default:
+			// none pending
+		}
+
+		// free buffers and a free next take priority over expiry
+		var buf []byte
+		select {
+		case buf = <-w.pool:
+		default:
+			atomic.AddUint64(&w.stats.stalls, 1)
+			switch w.policy {
+			case FullDropNew:
+				return w.dropNew(n, p, expire)
+			case FullDropOldest:
+				select {
+				case old := <-w.next:
+					atomic.AddUint64(&w.dropped, uint64(len(old)))
+					buf = old[:0]
+				default:
+					// none pending
+				}
+			}
+			if buf != nil {
+				break
+			}
+			if expire == nil && timeout >= 0 {
+				expire = w.resetTimer(timeout)
+			}
+			select {
+			case buf = <-w.pool:
+			case <-expire:
+				return n, ErrWriteBufferFull
+			}
+		}
+		did := copy(buf[:cap(buf)], p)
+		select {
+		case w.next <- buf[:did]:
+		default:
+			atomic.AddUint64(&w.stats.stalls, 1)
+			switch w.policy {
+			case FullDropNew:
+				w.pool <- buf[:0]
+				return w.dropNew(n, p, expire)
+			case FullDropOldest:
+				select {
+				case old := <-w.next:
+					atomic.AddUint64(&w.dropped, uint64(len(old)))
+					w.pool <- old[:0]
+				default:
+					// taken in the mean time
+				}
+			}
+			if expire == nil && timeout >= 0 {
+				expire = w.resetTimer(timeout)
+			}
+			select {
+			case w.next <- buf[:did]:
+			case <-expire:
+				// pending buffer not taken in time
+				w.pool <- buf[:0]
+				return n, ErrWriteBufferFull
+			}
+		}
+		signal(w.arrived)
+		n += did
+		p = p[did:]
+	}
+	if expire != nil {
+		w.stopTimer()
+	}
+	return n, nil
+}
+
+// DropNew discards p, as the remainder of a Write with n bytes accepted.
+func (w *Writer) dropNew(n int, p []byte, expire <-chan time.Time) (int, error) {
+	if expire != nil {
+		w.stopTimer()
+	}
+	atomic.AddUint64(&w.dropped, uint64(len(p)))
+	return n + len(p), nil
+}
+
+// ReadFrom implements the io.ReaderFrom interface, as used by io.Copy.
+// Reads from r go into the buffers directly, without the copy of Write,
+// until io.EOF, in which case the return is nil. Errors from r, including
+// ErrNoData, are returned as is. A ReadFrom which could not get a buffer
+// within the timeout fails with ErrWriteBufferFull. No data from r is lost
+// either way. ReadFrom must not be called concurrently with Write, nor
+// with Close.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
+		return 0, ErrConcurrentWrite
+	}
+	defer atomic.StoreInt32(&w.writing, 0)
+
+	if atomic.LoadInt32(&w.isClosed) != 0 {
+		return 0, ErrWriterClosed
+	}
+
+	var expire <-chan time.Time // lazy init; nil blocks
+	defer func() {
+		if expire != nil {
+			w.stopTimer()
+		}
+	}()
+	freeBuf := func() ([]byte, bool) {
+		select {
+		case buf := <-w.pool:
+			return buf, true
+		default:
+			if expire == nil && w.timeout >= 0 {
+				expire = w.resetTimer(w.timeout)
+			}
+			select {
+			case buf := <-w.pool:
+				return buf, true
+			case <-expire:
+				return nil, false
+			}
+		}
+	}
+
+	for {
+		if err := w.stickyErr(); err != nil {
+			return n, err
+		}
+
+		// append to the pending buffer, if any
+		var buf []byte
+		var nextFull bool
+		select {
+		case buf = <-w.next:
+			if len(buf) == cap(buf) {
+				w.next <- buf // still first in line
+				signal(w.flushSig)
+				buf, nextFull = nil, true
+			}
+		default:
+			// none pending
+		}
+
+		if buf == nil {
+			var ok bool
+			buf, ok = freeBuf()
+			if !ok {
+				return n, ErrWriteBufferFull
+			}
+			if nextFull {
+				// A second free buffer proves that the write routine
+				// is not stuck on sink, and that it takes next soon.
+				// Data read from r must not wait on sink.
+				spare, ok := freeBuf()
+				if !ok {
+					w.pool <- buf
+					return n, ErrWriteBufferFull
+				}
+				w.pool <- spare
+			}
+		}
+
+		did, err := r.Read(buf[len(buf):cap(buf)])
+		n += int64(did)
+		buf = buf[:len(buf)+did]
+		if len(buf) != 0 {
+			w.next <- buf
+			signal(w.arrived)
+		} else {
+			w.pool <- buf
+		}
+
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return n, nil
+		default:
+			return n, err
+		}
+	}
+}
+
+// SetFullPolicy sets the behaviour of Write on full buffers, which is
+// FullWait by default. Log shippers may prefer FullDropOldest, for example.
+// The policy does not apply to ReadFrom, which always waits. It must be
+// set before use of Write.
+func (w *Writer) SetFullPolicy(p FullPolicy) {
+	w.policy = p
+}
+
+// Dropped returns the number of bytes discarded by the FullPolicy. It is
+// safe for use from any goroutine.
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Flush waits for all pending data to pass to sink, within the timeout of
+// Write, with ErrWriteBufferFull on expiry. The return is the sticky error
+// of sink, if any. Flush must not be called concurrently with Write, nor
+// with Close.
+func (w *Writer) Flush() error {
+	return w.FlushTimeout(w.timeout)
+}
+
+// FlushTimeout is like Flush, yet it waits for at most d instead of the
+// timeout of Write. A negative d waits without limit.
+func (w *Writer) FlushTimeout(d time.Duration) error {
+	if atomic.LoadInt32(&w.isClosed) != 0 {
+		return ErrWriterClosed
+	}
+	signal(w.flushSig)
+
+	// all buffers free means nothing pending
+	var expire <-chan time.Time // lazy init; nil blocks
+	free := make([][]byte, 0, cap(w.pool))
+	defer func() {
+		for _, buf := range free {
+			w.pool <- buf
+		}
+	}()
+	for len(free) < cap(w.pool) {
+		select {
+		case buf := <-w.pool:
+			free = append(free, buf)
+			continue
+		default:
+		}
+		if expire == nil && d >= 0 {
+			expire = w.resetTimer(d)
+		}
+		select {
+		case buf := <-w.pool:
+			free = append(free, buf)
+		case <-expire:
+			return ErrWriteBufferFull
+		}
+	}
+	if expire != nil {
+		w.stopTimer()
+	}
+	return w.stickyErr()
+}
+
+// stickyErr returns the error of sink, if any.
+func (w *Writer) stickyErr() error {
+	box, _ := w.failed.Load().(errorBox)
+	return box.err
+}
+
+// resetTimer arms the timer for d, regardless of its prior state.
+func (w *Writer) resetTimer(d time.Duration) <-chan time.Time {
+	if w.timer == nil {
+		w.timer = time.NewTimer(d)
+	} else {
+		w.stopTimer()
+		w.timer.Reset(d)
+	}
+	return w.timer.C
+}
+
+// stopTimer disarms the timer, including any expiry not received yet.
+func (w *Writer) stopTimer() {
+	if !w.timer.Stop() {
+		select {
+		case <-w.timer.C:
+		default:
+			// received already
+		}
+	}
+}
+
+// Close passes any pending data to sink, and then it closes sink. The
+// write routine terminates before Close returns, which blocks for as long
+// as sink does. The return is the sticky error of sink, if any, or the
+// result of Close on sink otherwise. Any Write afterwards fails with
+// ErrWriterClosed.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		atomic.StoreInt32(&w.isClosed, 1)
+		close(w.next)
+		if w.flushSig != nil {
+			close(w.flushSig)
+		}
+		<-w.done
+
+		w.closeErr = w.w.Close()
+		if err := w.stickyErr(); err != nil {
+			w.closeErr = err
+		}
+	})
+	return w.closeErr
+}
@@ -0,0 +1,313 @@
+package nbio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+const writeRoutineStackEl = "writeRoutine"
+
+// Non blocking Writer must indicate a full buffer and recover.
+func TestWriteWithPause(t *testing.T) {
+	// test subject with (blocking) pipe attached
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := NewWriter(pw, 9*time.Millisecond)
+
+	// small writes merge into the pending buffer
+	for i := 0; i < 10; i++ {
+		if n, err := w.Write([]byte(feed)); n != len(feed) || err != nil {
+			t.Fatalf("Write %d = (%d, %v), want (%d, <nil>)", i, n, err, len(feed))
+		}
+	}
+
+	chunk := make([]byte, 3*bufferSize)
+	n, err := w.Write(chunk)
+	if err != ErrWriteBufferFull {
+		t.Fatalf("Write on full buffers = (%d, %v), want ErrWriteBufferFull", n, err)
+	}
+	if n >= len(chunk) {
+		t.Errorf("Write on full buffers got %d bytes, want less than %d", n, len(chunk))
+	}
+
+	if dump := stackDump(); !strings.Contains(dump, writeRoutineStackEl) {
+		t.Fatalf("can't locate write routine element %q in:\n%s", writeRoutineStackEl, dump)
+	}
+
+	got := make(chan []byte)
+	go func() {
+		all, _ := ioutil.ReadAll(pr)
+		got <- all
+	}()
+	if err := w.Close(); err != nil {
+		t.Error("close error:", err)
+	}
+	want := append(bytes.Repeat([]byte(feed), 10), chunk[:n]...)
+	if all := <-got; !bytes.Equal(all, want) {
+		t.Errorf("sink got %d bytes, want %d", len(all), len(want))
+	}
+
+	if dump := stackDump(); strings.Contains(dump, writeRoutineStackEl) {
+		t.Errorf("write routine element %q still present in:\n%s", writeRoutineStackEl, dump)
+	}
+	if n, err := w.Write([]byte(feed)); n != 0 || err != ErrWriterClosed {
+		t.Errorf("Write after Close = (%d, %v), want (0, %v)", n, err, ErrWriterClosed)
+	}
+}
+
+// FailSink fails each write.
+type failSink struct{ err error }
+
+func (s failSink) Write(p []byte) (int, error) { return 0, s.err }
+func (s failSink) Close() error                { return nil }
+
+var errSink = errors.New("sink error test")
+
+// Non blocking Writer must pass sink errors as sticky.
+func TestWriteStickyError(t *testing.T) {
+	w := NewWriter(failSink{errSink}, 9*time.Millisecond)
+
+	if n, err := w.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Fatalf("first Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	// await the write routine
+	time.Sleep(9 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if n, err := w.Write([]byte(feed)); n != 0 || err != errSink {
+			t.Errorf("Write %d after sink failure = (%d, %v), want (0, %v)", i, n, err, errSink)
+		}
+	}
+	if err := w.Close(); err != errSink {
+		t.Errorf("Close = %v, want %v", err, errSink)
+	}
+}
+
+// Non blocking Writer must read straight into its buffers.
+func TestWriteReadFrom(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw, time.Second)
+	var _ io.ReaderFrom = w
+
+	payload := make([]byte, 100*bufferSize)
+	for i := range payload {
+		payload[i] = byte(i * 7 / 5)
+	}
+	got := make(chan []byte)
+	go func() {
+		all, _ := ioutil.ReadAll(pr)
+		got <- all
+	}()
+
+	// OneByteReader makes the pending buffers fill up in steps
+	src := io.MultiReader(bytes.NewReader(payload[:100]), iotest.OneByteReader(bytes.NewReader(payload[100:200])), bytes.NewReader(payload[200:]))
+	if n, err := w.ReadFrom(src); n != int64(len(payload)) || err != nil {
+		t.Errorf("ReadFrom = (%d, %v), want (%d, <nil>)", n, err, len(payload))
+	}
+	if err := w.Close(); err != nil {
+		t.Error("close error:", err)
+	}
+	if all := <-got; !bytes.Equal(all, payload) {
+		t.Errorf("sink got %d bytes, want the %d bytes of payload", len(all), len(payload))
+	}
+}
+
+// Non blocking Writer must time out on ReadFrom without free buffers.
+func TestWriteReadFromFull(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := NewWriter(pw, 9*time.Millisecond)
+
+	n, err := w.ReadFrom(ioutil.NopCloser(zeroReader{}))
+	if err != ErrWriteBufferFull {
+		t.Errorf("ReadFrom on full buffers = (%d, %v), want ErrWriteBufferFull", n, err)
+	}
+	// one buffer stuck on sink, one pending, and one free
+	if n != 2*bufferSize {
+		t.Errorf("ReadFrom got %d bytes, want %d", n, 2*bufferSize)
+	}
+
+	go ioutil.ReadAll(pr)
+	if err := w.Close(); err != nil {
+		t.Error("close error:", err)
+	}
+}
+
+// RecordSink keeps each write.
+type recordSink struct {
+	sync.Mutex
+	writes []string
+}
+
+func (s *recordSink) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.writes = append(s.writes, string(p))
+	return len(p), nil
+}
+
+func (s *recordSink) Close() error { return nil }
+
+func (s *recordSink) get() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+// Non blocking Writer must batch small writes within the delay.
+func TestBatchWriter(t *testing.T) {
+	sink := new(recordSink)
+	w := NewBatchWriter(sink, time.Second, 50*time.Millisecond)
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		if n, err := w.Write([]byte(feed)); n != len(feed) || err != nil {
+			t.Fatalf("Write %d = (%d, %v), want (%d, <nil>)", i, n, err, len(feed))
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got, want := sink.get(), []string{strings.Repeat(feed, 10)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sink got writes %q, want %q", got, want)
+	}
+}
+
+// Non blocking Writer must pass pending data to sink on Flush.
+func TestWriteFlush(t *testing.T) {
+	sink := new(recordSink)
+	w := NewBatchWriter(sink, time.Second, time.Hour)
+	defer w.Close()
+
+	w.Write([]byte(feed))
+	time.Sleep(9 * time.Millisecond)
+	if got := sink.get(); len(got) != 0 {
+		t.Errorf("sink got writes %q before Flush", got)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Flush error:", err)
+	}
+	if got, want := sink.get(), []string{feed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sink got writes %q, want %q", got, want)
+	}
+
+	// stuck sink
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w = NewWriter(pw, time.Second)
+	w.Write([]byte(feed))
+	if err := w.FlushTimeout(9 * time.Millisecond); err != ErrWriteBufferFull {
+		t.Errorf("FlushTimeout on stuck sink got error %v, want %v", err, ErrWriteBufferFull)
+	}
+	go ioutil.ReadAll(pr)
+	if err := w.Flush(); err != nil {
+		t.Error("Flush error:", err)
+	}
+	w.Close()
+	if err := w.Flush(); err != ErrWriterClosed {
+		t.Errorf("Flush after Close got error %v, want %v", err, ErrWriterClosed)
+	}
+}
+
+// Non blocking Writer must apply its FullPolicy on full buffers.
+func TestWriteFullPolicy(t *testing.T) {
+	tests := []struct {
+		policy FullPolicy
+		want   string // sink data
+	}{
+		{FullDropNew, "ab"},
+		{FullDropOldest, "ad"},
+	}
+	for _, test := range tests {
+		pr, pw := io.Pipe()
+		w := NewWriter(pw, 9*time.Millisecond)
+		w.SetFullPolicy(test.policy)
+
+		for i, c := range "abcd" {
+			chunk := bytes.Repeat([]byte{byte(c)}, bufferSize)
+			if n, err := w.Write(chunk); n != len(chunk) || err != nil {
+				t.Errorf("policy %d: Write %d = (%d, %v), want (%d, <nil>)", test.policy, i, n, err, len(chunk))
+			}
+			// await the write routine
+			time.Sleep(time.Millisecond)
+		}
+
+		got := make(chan []byte)
+		go func() {
+			all, _ := ioutil.ReadAll(pr)
+			got <- all
+		}()
+		if err := w.Close(); err != nil {
+			t.Errorf("policy %d: Close error: %v", test.policy, err)
+		}
+		var want []byte
+		for _, c := range test.want {
+			want = append(want, bytes.Repeat([]byte{byte(c)}, bufferSize)...)
+		}
+		if all := <-got; !bytes.Equal(all, want) {
+			t.Errorf("policy %d: sink got %d bytes, want %d bytes of %q", test.policy, len(all), len(want), test.want)
+		}
+		if got, want := w.Dropped(), uint64(len("abcd")-len(test.want))*bufferSize; got != want {
+			t.Errorf("policy %d: got %d bytes dropped, want %d", test.policy, got, want)
+		}
+	}
+}
+
+// Non blocking Writer must pass keepalives to sink while idle only.
+func TestKeepaliveWriter(t *testing.T) {
+	sink := new(recordSink)
+	w := NewKeepaliveWriter(sink, time.Second, 20*time.Millisecond, []byte("PING"))
+
+	time.Sleep(50 * time.Millisecond)
+	if n, err := w.Write([]byte(feed)); n != len(feed) || err != nil {
+		t.Fatalf("Write = (%d, %v), want (%d, <nil>)", n, err, len(feed))
+	}
+	time.Sleep(9 * time.Millisecond)
+	if err := w.Close(); err != nil {
+		t.Error("Close error:", err)
+	}
+
+	got := sink.get()
+	var pings, feeds int
+	for _, s := range got {
+		switch s {
+		case "PING":
+			pings++
+		case feed:
+			feeds++
+		default:
+			t.Errorf("sink got write %q", s)
+		}
+	}
+	if pings < 1 || pings > 2 || feeds != 1 || got[len(got)-1] != feed {
+		t.Errorf("sink got writes %q, want 1 or 2 keepalives followed by %q", got, feed)
+	}
+}
+
+// Non blocking Writer must detect concurrent writes.
+func TestConcurrentWrite(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw, -1)
+
+	done := make(chan error)
+	go func() {
+		// blocks on the third buffer
+		_, err := w.Write(make([]byte, 4*bufferSize))
+		done <- err
+	}()
+	time.Sleep(9 * time.Millisecond)
+	if n, err := w.Write([]byte(feed)); n != 0 || err != ErrConcurrentWrite {
+		t.Errorf("concurrent Write = (%d, %v), want (0, %v)", n, err, ErrConcurrentWrite)
+	}
+
+	go ioutil.ReadAll(pr)
+	if err := <-done; err != nil {
+		t.Error("first Write error:", err)
+	}
+	w.Close()
+}